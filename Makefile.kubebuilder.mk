@@ -33,6 +33,7 @@ deploy: manifests
 # Generate manifests e.g. CRD, RBAC etc.
 manifests: controller-gen
 	$(CONTROLLER_GEN) $(CRD_OPTIONS) rbac:roleName=manager-role webhook paths="./..." output:crd:artifacts:config=config/crd/bases
+	cp config/crd/bases/*.yaml helm/cluster-api-cleaner-cloud-director/crds/
 
 # Run go fmt against code
 fmt:
@@ -54,7 +55,7 @@ docker-build: test
 docker-push:
 	docker push ${IMG}
 
-CONTROLLER_TOOLS_VERSION ?= v0.10.0
+CONTROLLER_TOOLS_VERSION ?= v0.18.0
 CONTROLLER_GEN ?= $(GOBIN)/controller-gen
 
 .PHONY: controller-gen
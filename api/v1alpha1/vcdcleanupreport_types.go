@@ -0,0 +1,133 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Outcomes of a VCD object in a report.
+const (
+	OutcomeDeleted         = "Deleted"
+	OutcomeFailed          = "Failed"
+	OutcomeRetained        = "Retained"
+	OutcomeRetainedRenamed = "RetainedRenamed"
+)
+
+// VCDCleanupReportSpec identifies the cluster a report belongs to.
+type VCDCleanupReportSpec struct {
+	// ClusterName is the name of the capi Cluster.
+	ClusterName string `json:"clusterName"`
+	// VCDClusterName is the name of the deleted VCDCluster.
+	VCDClusterName string `json:"vcdClusterName"`
+	// VCDClusterNamespace is the namespace of the deleted VCDCluster. The
+	// report is written to the namespace of the controller, which outlives
+	// the one of the cluster.
+	// +optional
+	VCDClusterNamespace string `json:"vcdClusterNamespace,omitempty"`
+	// VCDClusterUID is the uid of the deleted VCDCluster. A cluster that is
+	// created again with the same name gets a new report.
+	VCDClusterUID string `json:"vcdClusterUID"`
+	// InfraID is the VCDCluster Status.InfraId the cleaners matched on.
+	InfraID string `json:"infraId"`
+	// ManagementCluster is the name of the management cluster that ran the
+	// clean-up.
+	// +optional
+	ManagementCluster string `json:"managementCluster,omitempty"`
+}
+
+// CleanedObject is a VCD object a cleaner deleted, failed to delete, retained
+// under a new name instead of deleting it, or retained because the VCDCluster
+// asked to keep it.
+type CleanedObject struct {
+	// Cleaner is the name of the cleaner that handled the object.
+	Cleaner string `json:"cleaner"`
+	// Kind is the kind of VCD object, e.g. disk or dnatRule.
+	Kind string `json:"kind"`
+	// +optional
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	// Outcome is Deleted, Failed, RetainedRenamed or Retained.
+	Outcome string `json:"outcome"`
+	// RenamedTo references the object under its new name, when it was
+	// retained renamed instead of deleted.
+	// +optional
+	RenamedTo string `json:"renamedTo,omitempty"`
+	// Error is set when the deletion failed.
+	// +optional
+	Error string `json:"error,omitempty"`
+	// Time is when the object was deleted, retained, or last failed to be deleted.
+	Time metav1.Time `json:"time"`
+	// Zone is the zone of the cluster the object was in, for a cluster that
+	// spans several OVDCs.
+	// +optional
+	Zone string `json:"zone,omitempty"`
+}
+
+// CleanerError is an error a cleaner returned that is not tied to an object,
+// e.g. a failed listing.
+type CleanerError struct {
+	Cleaner string      `json:"cleaner"`
+	Message string      `json:"message"`
+	Time    metav1.Time `json:"time"`
+}
+
+// VCDCleanupReportStatus lists what the clean-up removed from VCD.
+type VCDCleanupReportStatus struct {
+	// StartTime is when the clean-up started.
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+	// CompletionTime is when the finalizer was removed from the VCDCluster.
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Objects are the VCD objects the cleaners handled.
+	// +optional
+	Objects []CleanedObject `json:"objects,omitempty"`
+	// Errors are the most recent cleaner errors.
+	// +optional
+	Errors []CleanerError `json:"errors,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Cluster",type="string",JSONPath=".spec.clusterName"
+// +kubebuilder:printcolumn:name="InfraID",type="string",JSONPath=".spec.infraId"
+// +kubebuilder:printcolumn:name="Completed",type="date",JSONPath=".status.completionTime"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// VCDCleanupReport records what was removed from VCD when a VCDCluster was
+// deleted.
+type VCDCleanupReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   VCDCleanupReportSpec   `json:"spec,omitempty"`
+	Status VCDCleanupReportStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// VCDCleanupReportList contains a list of VCDCleanupReport
+type VCDCleanupReportList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []VCDCleanupReport `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&VCDCleanupReport{}, &VCDCleanupReportList{})
+}
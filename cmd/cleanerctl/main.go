@@ -0,0 +1,137 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// cleanerctl deletes the VCD objects of a cluster whose VCDCluster is already
+// gone, with the cleaners of the manager. It needs no Kubernetes api server.
+//
+//	VCD_PASSWORD=... cleanerctl --site https://vcd.example.com --org acme \
+//	    --ovdc acme-ovdc --network acme-network --vapp mycluster \
+//	    --username admin --infra-id urn:vcloud:entity:vmware:capvcdCluster:...
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleanerctl"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := mainE(ctx)
+	if cleanerctl.IsAborted(err) {
+		fmt.Fprintln(os.Stderr, "Aborted, nothing was deleted.")
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", microerror.Pretty(err, false))
+		os.Exit(2)
+	}
+}
+
+func mainE(ctx context.Context) error {
+	var (
+		opts         cleanerctl.Options
+		caBundle     string
+		insecure     bool
+		logLevel     int
+		rateLimit    vcd.RateLimit
+		pollInterval time.Duration
+		outputJSON   bool
+		outputFormat string
+	)
+
+	flag.StringVar(&opts.Site, "site", "", "Url of the VCD site.")
+	flag.StringVar(&opts.Org, "org", "", "VCD org of the cluster.")
+	flag.StringVar(&opts.Ovdc, "ovdc", "", "OVDC of the cluster.")
+	flag.StringVar(&opts.Network, "network", "", "OVDC network of the cluster.")
+	flag.StringVar(&opts.Zones, "zones", "",
+		fmt.Sprintf("Zones of a cluster that spans several OVDCs, as the json of the %s annotation.", key.ZonesAnnotation))
+	flag.StringVar(&opts.VApp, "vapp", "",
+		"vApp of the cluster, usually the cluster name. Disks attached to its vms are detached first, without it they fail to delete.")
+	flag.StringVar(&opts.InfraId, "infra-id", "", "Infra id of the cluster, the VCD objects are matched on it.")
+
+	flag.StringVar(&opts.Username, "username", os.Getenv("VCD_USERNAME"),
+		"VCD user, as user or org/user. The password is read from $VCD_PASSWORD. Defaults to $VCD_USERNAME.")
+	flag.StringVar(&opts.DiskBackup, "disk-backup", cleaner.DiskBackupNone,
+		fmt.Sprintf("What to do with disks: %q deletes them, %q detaches and renames them instead, without copying them.", cleaner.DiskBackupNone, cleaner.DiskBackupRetainRenamed))
+
+	flag.BoolVar(&opts.DryRun, "dry-run", false, "Only list the VCD objects that would be deleted.")
+	flag.BoolVar(&opts.Yes, "yes", false, "Delete without asking first.")
+	flag.StringVar(&outputFormat, "output", "table", `Output format, "table" or "json".`)
+
+	flag.StringVar(&caBundle, "ca-bundle", "", "PEM file of CA certificates trusted for the site, on top of the system roots.")
+	flag.BoolVar(&insecure, "insecure", false, "Do not verify the certificate of the site.")
+	flag.Float64Var(&rateLimit.RequestsPerSecond, "rate-limit", 10, "How many requests per second are sent to the site. Zero means no limit.")
+	flag.IntVar(&rateLimit.Burst, "rate-limit-burst", 20, "How many requests are sent at once before --rate-limit applies.")
+	flag.DurationVar(&pollInterval, "poll-interval", 5*time.Second, "How long to wait for running VCD tasks between runs of a cleaner.")
+
+	flag.IntVar(&logLevel, "v", 0, "Number for the log level verbosity")
+
+	flag.Parse()
+
+	switch outputFormat {
+	case "table":
+	case "json":
+		outputJSON = true
+	default:
+		return fmt.Errorf("invalid --output [%s]", outputFormat)
+	}
+	if rateLimit.RequestsPerSecond < 0 {
+		return fmt.Errorf("invalid --rate-limit [%v]", rateLimit.RequestsPerSecond)
+	}
+	if rateLimit.Burst < 1 {
+		return fmt.Errorf("invalid --rate-limit-burst [%d]", rateLimit.Burst)
+	}
+
+	opts.Password = os.Getenv("VCD_PASSWORD")
+	opts.APIToken = os.Getenv("VCD_API_TOKEN")
+	opts.JSON = outputJSON
+	opts.PollInterval = pollInterval
+
+	// Logs go to stderr, stdout only gets the result.
+	level := int8(-logLevel) //nolint:gosec
+	opts.Log = zap.New(zap.Level(zapcore.Level(level)), zap.WriteTo(os.Stderr))
+
+	ca, err := vcd.LoadCABundle(caBundle)
+	if err != nil {
+		return fmt.Errorf("invalid --ca-bundle [%s]: [%v]", caBundle, err)
+	}
+	// Without proxies the transport uses the proxy of the environment.
+	opts.Transport = &vcd.Transport{
+		CABundle:  ca,
+		RateLimit: rateLimit,
+	}
+	if insecure {
+		opts.Transport.InsecureSites = []string{opts.Site}
+	}
+
+	return cleanerctl.Run(ctx, opts, os.Stdin, os.Stdout, os.Stderr)
+}
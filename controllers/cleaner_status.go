@@ -0,0 +1,163 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+)
+
+// States a cleaner goes through while its VCDCluster is deleted.
+const (
+	CleanerPending    = "Pending"
+	CleanerInProgress = "InProgress"
+	CleanerSucceeded  = "Succeeded"
+	CleanerFailed     = "Failed"
+)
+
+// CleanerStatus is the outcome of one cleaner. It is stored as json in the
+// key.CleanerStatusAnnotation of the VCDCluster, so `kubectl describe` shows
+// which kind of VCD object holds the finalizer back.
+type CleanerStatus struct {
+	State string `json:"state"`
+	// Reason is the error of the last failed run.
+	Reason string `json:"reason,omitempty"`
+	// Deleted counts the objects deleted over every run.
+	Deleted int `json:"deleted"`
+	// Retained counts the objects the last run left alone because the
+	// VCDCluster asks to retain them.
+	Retained int `json:"retained,omitempty"`
+	// LastTransitionTime is when State last changed.
+	LastTransitionTime metav1.Time `json:"lastTransitionTime"`
+}
+
+// GetCleanerStatus reads the status a cleaner left on the VCDCluster. A cleaner
+// that never ran, or an unreadable annotation, is pending.
+func GetCleanerStatus(vcdCluster metav1.Object, cleanerName string) CleanerStatus {
+	status := CleanerStatus{State: CleanerPending}
+
+	raw, ok := vcdCluster.GetAnnotations()[key.CleanerStatusAnnotation(cleanerName)]
+	if !ok {
+		return status
+	}
+	if err := json.Unmarshal([]byte(raw), &status); err != nil {
+		return CleanerStatus{State: CleanerPending}
+	}
+
+	return status
+}
+
+// setCleanerStatus records the outcome of a run. deleted is added to the count
+// of the earlier runs, retained replaces it.
+func setCleanerStatus(vcdCluster metav1.Object, cleanerName, state, reason string, deleted, retained int) {
+	status := GetCleanerStatus(vcdCluster, cleanerName)
+	if status.State != state || status.LastTransitionTime.IsZero() {
+		status.LastTransitionTime = metav1.Now()
+	}
+	status.State = state
+	status.Reason = reason
+	status.Deleted += deleted
+	status.Retained = retained
+
+	encoded, err := json.Marshal(status)
+	if err != nil {
+		// A struct of plain fields always encodes.
+		return
+	}
+
+	setAnnotation(vcdCluster, key.CleanerStatusAnnotation(cleanerName), string(encoded))
+}
+
+// getCleanerTasks reads the VCD tasks a cleaner left pending on the
+// VCDCluster. An unreadable annotation holds no task, the cleaner then starts
+// over from what it finds in VCD.
+func getCleanerTasks(vcdCluster metav1.Object, cleanerName string) []cleaner.Task {
+	raw, ok := vcdCluster.GetAnnotations()[key.CleanerTasksAnnotation(cleanerName)]
+	if !ok {
+		return nil
+	}
+
+	var tasks []cleaner.Task
+	if err := json.Unmarshal([]byte(raw), &tasks); err != nil {
+		return nil
+	}
+
+	return tasks
+}
+
+// setCleanerTasks records the VCD tasks a cleaner waits for. The annotation is
+// removed once there are none.
+func setCleanerTasks(vcdCluster metav1.Object, cleanerName string, tasks []cleaner.Task) {
+	if len(tasks) == 0 {
+		delete(vcdCluster.GetAnnotations(), key.CleanerTasksAnnotation(cleanerName))
+		return
+	}
+
+	encoded, err := json.Marshal(tasks)
+	if err != nil {
+		// A struct of plain fields always encodes.
+		return
+	}
+
+	setAnnotation(vcdCluster, key.CleanerTasksAnnotation(cleanerName), string(encoded))
+}
+
+// setAnnotation sets one annotation of obj.
+func setAnnotation(obj metav1.Object, name, value string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[name] = value
+	obj.SetAnnotations(annotations)
+}
+
+// deleteCounter counts the objects a cleaner deletes, and retains, in one run.
+type deleteCounter struct {
+	mu       sync.Mutex
+	deleted  int
+	retained int
+}
+
+func (d *deleteCounter) ObjectDeleted(ctx context.Context, o cleaner.Object) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.deleted++
+}
+
+func (d *deleteCounter) ObjectFailed(ctx context.Context, o cleaner.Object, err error) {}
+
+func (d *deleteCounter) ObjectRetained(ctx context.Context, o cleaner.Object) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.retained++
+}
+
+func (d *deleteCounter) count() (deleted, retained int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.deleted, d.retained
+}
@@ -0,0 +1,220 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/microerror"
+
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// maxReportErrors caps the cleaner errors a report keeps. A cluster that fails
+// on every reconcile would otherwise grow its report without bound.
+const maxReportErrors = 10
+
+// CleanupReportName is the name of the VCDCleanupReport written while a
+// VCDCluster is deleted. The uid keeps a recreated cluster of the same name
+// from reusing the report of the previous one.
+func CleanupReportName(vcdCluster metav1.Object) string {
+	uid := string(vcdCluster.GetUID())
+	if len(uid) > 8 {
+		uid = uid[:8]
+	}
+
+	return vcdCluster.GetName() + "-" + uid
+}
+
+// cleanupReportKey returns where the report of the VCDCluster is written.
+func (r *VCDClusterReconciler) cleanupReportKey(vcdCluster *vcdcluster.Cluster) types.NamespacedName {
+	namespace := r.ReportNamespace
+	if namespace == "" {
+		namespace = vcdCluster.GetNamespace()
+	}
+
+	return types.NamespacedName{Name: CleanupReportName(vcdCluster), Namespace: namespace}
+}
+
+// ensureCleanupReport returns the report of the clean-up, creating it on the
+// first run.
+func (r *VCDClusterReconciler) ensureCleanupReport(ctx context.Context, vcdCluster *vcdcluster.Cluster, clusterName string) (*cleanerv1alpha1.VCDCleanupReport, error) {
+	reportKey := r.cleanupReportKey(vcdCluster)
+
+	report := &cleanerv1alpha1.VCDCleanupReport{}
+	err := r.Get(ctx, reportKey, report)
+	if apierrors.IsNotFound(err) {
+		report = &cleanerv1alpha1.VCDCleanupReport{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      reportKey.Name,
+				Namespace: reportKey.Namespace,
+				Labels: map[string]string{
+					key.CapiClusterLabelKey: clusterName,
+				},
+			},
+			Spec: cleanerv1alpha1.VCDCleanupReportSpec{
+				ClusterName:         clusterName,
+				VCDClusterName:      vcdCluster.GetName(),
+				VCDClusterNamespace: vcdCluster.GetNamespace(),
+				VCDClusterUID:       string(vcdCluster.GetUID()),
+				InfraID:             vcdCluster.Status.InfraId,
+				ManagementCluster:   r.ManagementCluster,
+			},
+		}
+		err = r.Create(ctx, report)
+	}
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	// The status is written apart from the report, a failed write is made up
+	// for on the next run.
+	if report.Status.StartTime == nil {
+		patch := client.MergeFrom(report.DeepCopy())
+		now := metav1.Now()
+		report.Status.StartTime = &now
+		if err := r.Status().Patch(ctx, report, patch); err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	return report, nil
+}
+
+// updateCleanupReport adds what the cleaners did in this run to the report.
+// completed marks the end of the clean-up.
+func (r *VCDClusterReconciler) updateCleanupReport(ctx context.Context, report *cleanerv1alpha1.VCDCleanupReport, collector *reportCollector, completed bool) error {
+	patch := client.MergeFrom(report.DeepCopy())
+
+	objects, errs := collector.result()
+	for _, o := range objects {
+		report.Status.Objects = mergeCleanedObject(report.Status.Objects, o)
+	}
+
+	report.Status.Errors = append(report.Status.Errors, errs...)
+	if len(report.Status.Errors) > maxReportErrors {
+		report.Status.Errors = report.Status.Errors[len(report.Status.Errors)-maxReportErrors:]
+	}
+
+	if completed {
+		now := metav1.Now()
+		report.Status.CompletionTime = &now
+	}
+
+	if err := r.Status().Patch(ctx, report, patch); err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// mergeCleanedObject adds o to objects. An object that failed again, or was
+// deleted after failing, replaces its earlier entry so a retried object is
+// listed once. The same goes for an object retained on every run, or deleted
+// once the annotation that retained it is gone.
+func mergeCleanedObject(objects []cleanerv1alpha1.CleanedObject, o cleanerv1alpha1.CleanedObject) []cleanerv1alpha1.CleanedObject {
+	for i, existing := range objects {
+		if existing.Outcome != cleanerv1alpha1.OutcomeDeleted && existing.Kind == o.Kind && existing.ID == o.ID && existing.Name == o.Name && existing.Zone == o.Zone {
+			objects[i] = o
+			return objects
+		}
+	}
+
+	return append(objects, o)
+}
+
+// reportCollector gathers what the cleaners did during one reconcile.
+type reportCollector struct {
+	mu      sync.Mutex
+	objects []cleanerv1alpha1.CleanedObject
+	errors  []cleanerv1alpha1.CleanerError
+}
+
+// forCleaner returns the observer one cleaner reports to.
+func (rc *reportCollector) forCleaner(name string) cleaner.Observer {
+	return &reportObserver{collector: rc, cleaner: name}
+}
+
+// addError records an error of a cleaner that is not tied to an object.
+func (rc *reportCollector) addError(cleanerName string, err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	rc.errors = append(rc.errors, cleanerv1alpha1.CleanerError{
+		Cleaner: cleanerName,
+		Message: err.Error(),
+		Time:    metav1.Now(),
+	})
+}
+
+func (rc *reportCollector) add(cleanerName string, o cleaner.Object, outcome, renamed string, err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	cleaned := cleanerv1alpha1.CleanedObject{
+		Cleaner:   cleanerName,
+		Kind:      o.Kind,
+		ID:        o.ID,
+		Name:      o.Name,
+		Outcome:   outcome,
+		RenamedTo: renamed,
+		Time:      metav1.Now(),
+		Zone:      o.Zone,
+	}
+	if err != nil {
+		cleaned.Error = err.Error()
+	}
+
+	rc.objects = append(rc.objects, cleaned)
+}
+
+func (rc *reportCollector) result() ([]cleanerv1alpha1.CleanedObject, []cleanerv1alpha1.CleanerError) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.objects, rc.errors
+}
+
+type reportObserver struct {
+	collector *reportCollector
+	cleaner   string
+}
+
+func (o *reportObserver) ObjectDeleted(ctx context.Context, obj cleaner.Object) {
+	o.collector.add(o.cleaner, obj, cleanerv1alpha1.OutcomeDeleted, "", nil)
+}
+
+func (o *reportObserver) ObjectFailed(ctx context.Context, obj cleaner.Object, err error) {
+	o.collector.add(o.cleaner, obj, cleanerv1alpha1.OutcomeFailed, "", err)
+}
+
+func (o *reportObserver) ObjectRetained(ctx context.Context, obj cleaner.Object) {
+	o.collector.add(o.cleaner, obj, cleanerv1alpha1.OutcomeRetained, "", nil)
+}
+
+func (o *reportObserver) ObjectRetainedRenamed(ctx context.Context, obj cleaner.Object, renamed string) {
+	o.collector.add(o.cleaner, obj, cleanerv1alpha1.OutcomeRetainedRenamed, renamed, nil)
+}
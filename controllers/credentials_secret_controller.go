@@ -0,0 +1,108 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// CredentialsSecretReconciler removes key.SecretFinalizerName from credentials
+// secrets no VCDCluster needs any more. The VCDClusterReconciler releases the
+// secret once the clean-up is done, this catches the secrets it never gets
+// to: those a VCDCluster stopped referencing, and those of VCDClusters whose
+// finalizer was removed by hand or that are never cleaned up.
+type CredentialsSecretReconciler struct {
+	client.Client
+	Log logr.Logger
+
+	// Version is the api version VCDClusters are read and watched in. It
+	// defaults to v1beta1.
+	Version *vcdcluster.Version
+
+	// SecretRefsIndexed tells the VCDClusters are indexed with
+	// IndexSecretRefs.
+	SecretRefsIndexed bool
+}
+
+// +kubebuilder:rbac:groups=,resources=secrets,verbs=get;list;watch;update;patch
+
+func (r *CredentialsSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("secret", req.NamespacedName)
+	log.V(1).Info("Reconciling")
+
+	if err := releaseUnusedSecret(ctx, r.Client, r.Version, r.SecretRefsIndexed, req.NamespacedName, ""); err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func (r *CredentialsSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("credentialssecret").
+		For(&corev1.Secret{}, builder.OnlyMetadata, builder.WithPredicates(predicate.NewPredicateFuncs(func(o client.Object) bool {
+			return controllerutil.ContainsFinalizer(o, key.SecretFinalizerName)
+		}))).
+		Watches(r.Version.NewObject(), handler.Funcs{
+			UpdateFunc: func(ctx context.Context, e event.UpdateEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+				oldName, oldOk := secretOf(e.ObjectOld)
+				newName, newOk := secretOf(e.ObjectNew)
+				if oldOk && (!newOk || oldName != newName) {
+					q.Add(reconcile.Request{NamespacedName: oldName})
+				}
+				if newOk {
+					if vcdCluster, err := vcdcluster.New(e.ObjectNew); err == nil && !needsSecret(vcdCluster) {
+						q.Add(reconcile.Request{NamespacedName: newName})
+					}
+				}
+			},
+			DeleteFunc: func(ctx context.Context, e event.DeleteEvent, q workqueue.TypedRateLimitingInterface[reconcile.Request]) {
+				if name, ok := secretOf(e.Object); ok {
+					q.Add(reconcile.Request{NamespacedName: name})
+				}
+			},
+		}).
+		Complete(r)
+}
+
+// secretOf returns the credentials secret of a watched VCDCluster.
+func secretOf(obj client.Object) (types.NamespacedName, bool) {
+	vcdCluster, err := vcdcluster.New(obj)
+	if err != nil {
+		return types.NamespacedName{}, false
+	}
+
+	return credentialsSecret(vcdCluster)
+}
@@ -0,0 +1,108 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta2"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// Reasons of the events the reconciler emits.
+const (
+	ReasonVCDObjectDeleted         = "VCDObjectDeleted"
+	ReasonVCDObjectDetached        = "VCDObjectDetached"
+	ReasonVCDObjectRetained        = "VCDObjectRetained"
+	ReasonVCDObjectRetainedRenamed = "VCDObjectRetainedRenamed"
+	ReasonVCDObjectDeleteFailed    = "VCDObjectDeleteFailed"
+	ReasonCleanerFailed            = "CleanerFailed"
+	ReasonCleanupCompleted         = "CleanupCompleted"
+	ReasonInvalidInfraId           = "InvalidInfraId"
+)
+
+// Actions of the events the reconciler emits.
+const (
+	actionDelete        = "Delete"
+	actionDetach        = "Detach"
+	actionRetain        = "Retain"
+	actionRetainRenamed = "RetainRenamed"
+	actionCleanup       = "Cleanup"
+)
+
+// recordEvent emits the event on the VCDCluster and on its capi Cluster, so it
+// shows up in `kubectl describe` of either. It is a no-op without a Recorder.
+func (r *VCDClusterReconciler) recordEvent(coreCluster *capi.Cluster, vcdCluster *vcdcluster.Cluster, eventtype, reason, action, note string, args ...any) {
+	if r.Recorder == nil {
+		return
+	}
+
+	r.Recorder.Eventf(vcdCluster.ClientObject(), coreCluster, eventtype, reason, action, note, args...)
+	if coreCluster != nil {
+		r.Recorder.Eventf(coreCluster, vcdCluster.ClientObject(), eventtype, reason, action, note, args...)
+	}
+}
+
+// eventObserver turns what a cleaner does into events.
+type eventObserver struct {
+	reconciler  *VCDClusterReconciler
+	coreCluster *capi.Cluster
+	vcdCluster  *vcdcluster.Cluster
+	cleaner     string
+}
+
+func (e *eventObserver) ObjectDeleted(ctx context.Context, o cleaner.Object) {
+	e.reconciler.recordEvent(e.coreCluster, e.vcdCluster, corev1.EventTypeNormal, ReasonVCDObjectDeleted, actionDelete,
+		"%s deleted %s %s", e.cleaner, o.Kind, describeObject(o))
+}
+
+func (e *eventObserver) ObjectFailed(ctx context.Context, o cleaner.Object, err error) {
+	e.reconciler.recordEvent(e.coreCluster, e.vcdCluster, corev1.EventTypeWarning, ReasonVCDObjectDeleteFailed, actionDelete,
+		"%s failed to delete %s %s: %v", e.cleaner, o.Kind, describeObject(o), err)
+}
+
+func (e *eventObserver) ObjectDetached(ctx context.Context, o cleaner.Object, vmName string) {
+	e.reconciler.recordEvent(e.coreCluster, e.vcdCluster, corev1.EventTypeNormal, ReasonVCDObjectDetached, actionDetach,
+		"%s detached %s %s from vm %s", e.cleaner, o.Kind, describeObject(o), vmName)
+}
+
+func (e *eventObserver) ObjectRetained(ctx context.Context, o cleaner.Object) {
+	e.reconciler.recordEvent(e.coreCluster, e.vcdCluster, corev1.EventTypeNormal, ReasonVCDObjectRetained, actionRetain,
+		"%s retained %s %s as the VCDCluster asks", e.cleaner, o.Kind, describeObject(o))
+}
+
+func (e *eventObserver) ObjectRetainedRenamed(ctx context.Context, o cleaner.Object, renamed string) {
+	e.reconciler.recordEvent(e.coreCluster, e.vcdCluster, corev1.EventTypeNormal, ReasonVCDObjectRetainedRenamed, actionRetainRenamed,
+		"%s retained %s %s renamed to %s", e.cleaner, o.Kind, describeObject(o), renamed)
+}
+
+// describeObject names an object the way an operator finds it in the VCD ui,
+// and the zone it is in.
+func describeObject(o cleaner.Object) string {
+	description := o.Name
+	if o.ID != "" {
+		description += " (" + o.ID + ")"
+	}
+	if o.Zone != "" {
+		description += " in zone " + o.Zone
+	}
+
+	return description
+}
@@ -0,0 +1,61 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/sweeper"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// ownInfraId writes the infra id and the locations of the VCDCluster to the
+// ledger, so that the orphan sweeper may delete what is left of the cluster
+// should its finalizer go before the clean-up is done.
+func (r *VCDClusterReconciler) ownInfraId(ctx context.Context, vcdCluster *vcdcluster.Cluster) error {
+	if r.Ledger == nil || vcdCluster.Status.InfraId == "" {
+		return nil
+	}
+
+	// Zones that can't be read are not recorded, the clean-up itself fails on
+	// them.
+	_ = vcdcluster.LoadZones(ctx, r.Client, vcdCluster)
+	if err := r.Ledger.Add(ctx, vcdCluster.Status.InfraId, sweeper.EntryOf(vcdCluster)); err != nil {
+		r.countError(ErrorClassKubernetes)
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// releaseInfraId drops the infra id of a cleaned up VCDCluster from the
+// ledger. What the clean-up left behind, e.g. retained objects, is then never
+// swept.
+func (r *VCDClusterReconciler) releaseInfraId(ctx context.Context, vcdCluster *vcdcluster.Cluster) error {
+	if r.Ledger == nil || vcdCluster.Status.InfraId == "" {
+		return nil
+	}
+
+	if err := r.Ledger.Remove(ctx, vcdCluster.Status.InfraId); err != nil {
+		r.countError(ErrorClassKubernetes)
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
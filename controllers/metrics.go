@@ -0,0 +1,152 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+const metricsNamespace = "cluster_api_cleaner_cloud_director"
+
+// Classes of the errors counted in the errors metric.
+const (
+	ErrorClassVCDClient  = "vcdClient"
+	ErrorClassCleaner    = "cleaner"
+	ErrorClassObject     = "object"
+	ErrorClassKubernetes = "kubernetes"
+)
+
+// totalCleaner is the cleaner label of the duration of a whole clean-up.
+const totalCleaner = "total"
+
+var (
+	objectsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_deleted_total",
+		Help:      "Number of VCD objects deleted, by cleaner and kind.",
+	}, []string{"management_cluster", "cleaner", "kind"})
+
+	objectsRetained = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "objects_retained_total",
+		Help:      "Number of times a VCD object was left alone because its VCDCluster asks to retain it, by cleaner and kind.",
+	}, []string{"management_cluster", "cleaner", "kind"})
+
+	cleanupErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "errors_total",
+		Help:      "Number of errors hit while cleaning up, by class.",
+	}, []string{"management_cluster", "class"})
+
+	cleanupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "cleanup_duration_seconds",
+		Help: "Duration of a cleaner run, by cleaner. The cleaner \"total\" is the time from " +
+			"the deletion of a VCDCluster until its finalizer is removed.",
+		Buckets: []float64{0.5, 1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+	}, []string{"management_cluster", "cleaner"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(objectsDeleted, objectsRetained, cleanupErrors, cleanupDuration)
+}
+
+func (r *VCDClusterReconciler) countError(class string) {
+	cleanupErrors.WithLabelValues(r.ManagementCluster, class).Inc()
+}
+
+// metricsObserver counts the objects a cleaner deletes, fails to delete or
+// retains.
+type metricsObserver struct {
+	managementCluster string
+	cleaner           string
+}
+
+func (m *metricsObserver) ObjectDeleted(ctx context.Context, o cleaner.Object) {
+	objectsDeleted.WithLabelValues(m.managementCluster, m.cleaner, o.Kind).Inc()
+}
+
+func (m *metricsObserver) ObjectFailed(ctx context.Context, o cleaner.Object, err error) {
+	cleanupErrors.WithLabelValues(m.managementCluster, ErrorClassObject).Inc()
+}
+
+func (m *metricsObserver) ObjectRetained(ctx context.Context, o cleaner.Object) {
+	objectsRetained.WithLabelValues(m.managementCluster, m.cleaner, o.Kind).Inc()
+}
+
+var (
+	deletingClustersDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "deleting_clusters"),
+		"Number of VCDClusters being deleted that still have the cleaner finalizer.",
+		[]string{"management_cluster"}, nil)
+
+	oldestFinalizerDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "", "oldest_pending_finalizer_age_seconds"),
+		"Time since the oldest VCDCluster that still has the cleaner finalizer was deleted.",
+		[]string{"management_cluster"}, nil)
+)
+
+// DeletionCollector reports the VCDClusters stuck in deletion. It lists them
+// on every scrape, so it should be given the cached manager client.
+type DeletionCollector struct {
+	Client            client.Reader
+	ManagementCluster string
+	// Version is the api version VCDClusters are listed in.
+	Version *vcdcluster.Version
+}
+
+func (d *DeletionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- deletingClustersDesc
+	ch <- oldestFinalizerDesc
+}
+
+func (d *DeletionCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	vcdClusters, err := d.Version.List(ctx, d.Client)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(deletingClustersDesc, err)
+		ch <- prometheus.NewInvalidMetric(oldestFinalizerDesc, err)
+		return
+	}
+
+	deleting := 0
+	oldest := time.Duration(0)
+	for _, vcdCluster := range vcdClusters {
+		if vcdCluster.GetDeletionTimestamp().IsZero() || !controllerutil.ContainsFinalizer(vcdCluster.ClientObject(), key.CleanerFinalizerName) {
+			continue
+		}
+		deleting++
+		if age := time.Since(vcdCluster.GetDeletionTimestamp().Time); age > oldest {
+			oldest = age
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(deletingClustersDesc, prometheus.GaugeValue, float64(deleting), d.ManagementCluster)
+	ch <- prometheus.MustNewConstMetric(oldestFinalizerDesc, prometheus.GaugeValue, oldest.Seconds(), d.ManagementCluster)
+}
@@ -0,0 +1,208 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/notify"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// notificationState is stored as json in the key.NotificationsAnnotation of a
+// VCDCluster being deleted.
+type notificationState struct {
+	// Failures counts the runs that failed since the last one that did not.
+	Failures int `json:"failures,omitempty"`
+	// Sent lists the events notified so far.
+	Sent []string `json:"sent,omitempty"`
+}
+
+// getNotificationState reads the state left on the VCDCluster. An unreadable
+// annotation starts over.
+func getNotificationState(vcdCluster metav1.Object) notificationState {
+	var state notificationState
+
+	raw, ok := vcdCluster.GetAnnotations()[key.NotificationsAnnotation]
+	if !ok {
+		return state
+	}
+	if err := json.Unmarshal([]byte(raw), &state); err != nil {
+		return notificationState{}
+	}
+
+	return state
+}
+
+func setNotificationState(vcdCluster metav1.Object, state notificationState) {
+	encoded, err := json.Marshal(state)
+	if err != nil {
+		// A struct of plain fields always encodes.
+		return
+	}
+
+	setAnnotation(vcdCluster, key.NotificationsAnnotation, string(encoded))
+}
+
+// notifyFailure counts a failed run of the clean-up and notifies once it
+// failed NotifyAfterFailures times in a row. The caller saves the annotation
+// of vcdCluster. report may be nil, it is then looked up.
+func (r *VCDClusterReconciler) notifyFailure(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster, report *cleanerv1alpha1.VCDCleanupReport, err error) {
+	r.countFailure(ctx, log, vcdCluster, report, err, r.NotifyAfterFailures)
+}
+
+// notifyStuck counts a failed run after which the clean-up is not retried,
+// e.g. of a cluster with an invalid infra id or whose VCD client was given up
+// on. No further failures would be counted, so it notifies right away. The
+// caller saves the annotation of vcdCluster and requeues it after
+// slowNotificationDue.
+func (r *VCDClusterReconciler) notifyStuck(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster, err error) {
+	r.countFailure(ctx, log, vcdCluster, nil, err, min(r.NotifyAfterFailures, 1))
+}
+
+// countFailure counts a failed run and notifies once there were after of
+// them in a row, zero never notifies.
+func (r *VCDClusterReconciler) countFailure(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster, report *cleanerv1alpha1.VCDCleanupReport, err error, after int) {
+	if r.Notifier == nil {
+		return
+	}
+
+	state := getNotificationState(vcdCluster)
+	state.Failures++
+	if after > 0 && state.Failures >= after && !slices.Contains(state.Sent, notify.EventFailed) {
+		n := r.notification(ctx, notify.EventFailed, vcdCluster, report)
+		n.Failures = state.Failures
+		if !slices.Contains(n.Errors, err.Error()) {
+			n.Errors = append(n.Errors, err.Error())
+		}
+		r.notify(ctx, log, n)
+		state.Sent = append(state.Sent, notify.EventFailed)
+	}
+	r.notifySlow(ctx, log, vcdCluster, report, &state)
+
+	setNotificationState(vcdCluster, state)
+}
+
+// notifyProgress records a run that did not fail but left the clean-up
+// unfinished. It ends a series of failures, the next one is notified again.
+// The caller saves the annotation of vcdCluster.
+func (r *VCDClusterReconciler) notifyProgress(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster, report *cleanerv1alpha1.VCDCleanupReport) {
+	if r.Notifier == nil {
+		return
+	}
+
+	state := getNotificationState(vcdCluster)
+	state.Failures = 0
+	state.Sent = slices.DeleteFunc(state.Sent, func(event string) bool { return event == notify.EventFailed })
+	r.notifySlow(ctx, log, vcdCluster, report, &state)
+
+	setNotificationState(vcdCluster, state)
+}
+
+// notifySlow notifies once the clean-up still runs NotifyAfter after the
+// deletion of vcdCluster.
+func (r *VCDClusterReconciler) notifySlow(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster, report *cleanerv1alpha1.VCDCleanupReport, state *notificationState) {
+	deleted := vcdCluster.GetDeletionTimestamp()
+	if r.NotifyAfter <= 0 || deleted.IsZero() || time.Since(deleted.Time) < r.NotifyAfter || slices.Contains(state.Sent, notify.EventSlow) {
+		return
+	}
+
+	n := r.notification(ctx, notify.EventSlow, vcdCluster, report)
+	n.Failures = state.Failures
+	r.notify(ctx, log, n)
+	state.Sent = append(state.Sent, notify.EventSlow)
+}
+
+// slowNotificationDue returns how long until the slow notification of
+// vcdCluster is due, zero when none is. A clean-up that is not retried is
+// requeued then, so it is still notified.
+func (r *VCDClusterReconciler) slowNotificationDue(vcdCluster *vcdcluster.Cluster) time.Duration {
+	deleted := vcdCluster.GetDeletionTimestamp()
+	if r.Notifier == nil || r.NotifyAfter <= 0 || deleted.IsZero() || slices.Contains(getNotificationState(vcdCluster).Sent, notify.EventSlow) {
+		return 0
+	}
+
+	return max(r.NotifyAfter-time.Since(deleted.Time), time.Second)
+}
+
+// notifyCompleted notifies that the finalizer of vcdCluster was removed.
+func (r *VCDClusterReconciler) notifyCompleted(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster, report *cleanerv1alpha1.VCDCleanupReport) {
+	if r.Notifier == nil {
+		return
+	}
+
+	r.notify(ctx, log, r.notification(ctx, notify.EventCompleted, vcdCluster, report))
+}
+
+// notification describes the clean-up of vcdCluster from its report. Without
+// a report it only names the cluster.
+func (r *VCDClusterReconciler) notification(ctx context.Context, event string, vcdCluster *vcdcluster.Cluster, report *cleanerv1alpha1.VCDCleanupReport) notify.Notification {
+	n := notify.Notification{
+		Event:             event,
+		Time:              time.Now().UTC(),
+		ManagementCluster: r.ManagementCluster,
+		Namespace:         vcdCluster.GetNamespace(),
+		Cluster:           vcdCluster.GetLabels()[key.CapiClusterLabelKey],
+		VCDCluster:        vcdCluster.GetName(),
+		InfraId:           vcdCluster.Status.InfraId,
+	}
+	if deleted := vcdCluster.GetDeletionTimestamp(); !deleted.IsZero() {
+		n.DurationSeconds = time.Since(deleted.Time).Round(time.Second).Seconds()
+	}
+
+	if report == nil {
+		report = &cleanerv1alpha1.VCDCleanupReport{}
+		if err := r.Get(ctx, r.cleanupReportKey(vcdCluster), report); err != nil {
+			return n
+		}
+	}
+
+	for _, o := range report.Status.Objects {
+		if n.Objects == nil {
+			n.Objects = map[string]map[string]int{}
+		}
+		if n.Objects[o.Kind] == nil {
+			n.Objects[o.Kind] = map[string]int{}
+		}
+		n.Objects[o.Kind][o.Outcome]++
+	}
+	for _, e := range report.Status.Errors {
+		n.Errors = append(n.Errors, e.Message)
+	}
+
+	return n
+}
+
+// notify hands n to the Notifier. A notification that does not get through
+// is logged and counted, it does not hold the clean-up back and is not sent
+// again.
+func (r *VCDClusterReconciler) notify(ctx context.Context, log logr.Logger, n notify.Notification) {
+	if err := r.Notifier.Notify(ctx, n); err != nil {
+		log.Error(err, "Failed to send the notification", "event", n.Event)
+		return
+	}
+
+	log.V(1).Info("Notified", "event", n.Event)
+}
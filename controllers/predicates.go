@@ -0,0 +1,76 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"maps"
+	"slices"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// VCDClusterChanged lets through the updates of a VCDCluster that can change
+// what the reconciler does. The reconciler keeps the state of a clean-up in
+// annotations of the VCDCluster. An update that only changes those is left
+// out, otherwise every failed run would be queued again right away instead of
+// after its backoff.
+func VCDClusterChanged() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return true
+			}
+			return vcdClusterChanged(e.ObjectOld, e.ObjectNew)
+		},
+	}
+}
+
+func vcdClusterChanged(oldObj, newObj client.Object) bool {
+	if oldObj.GetGeneration() != newObj.GetGeneration() ||
+		!oldObj.GetDeletionTimestamp().Equal(newObj.GetDeletionTimestamp()) ||
+		!slices.Equal(oldObj.GetFinalizers(), newObj.GetFinalizers()) ||
+		!maps.Equal(oldObj.GetLabels(), newObj.GetLabels()) ||
+		!maps.Equal(userAnnotations(oldObj), userAnnotations(newObj)) ||
+		!slices.EqualFunc(oldObj.GetOwnerReferences(), newObj.GetOwnerReferences(), func(a, b metav1.OwnerReference) bool { return a.UID == b.UID && a.Name == b.Name }) {
+		return true
+	}
+
+	// The infra id is in the status, which leaves the generation alone.
+	oldCluster, err := vcdcluster.New(oldObj)
+	if err != nil {
+		return true
+	}
+	newCluster, err := vcdcluster.New(newObj)
+	if err != nil {
+		return true
+	}
+	return oldCluster.Status.InfraId != newCluster.Status.InfraId
+}
+
+// userAnnotations are the annotations of obj but those the manager keeps its
+// state in.
+func userAnnotations(obj client.Object) map[string]string {
+	annotations := maps.Clone(obj.GetAnnotations())
+	maps.DeleteFunc(annotations, func(name, _ string) bool { return key.StateAnnotation(name) })
+	return annotations
+}
@@ -0,0 +1,153 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// credentialsSecret returns the name of the secret the VCDCluster logs in
+// with, false when its credentials are inline.
+func credentialsSecret(vcdCluster *vcdcluster.Cluster) (types.NamespacedName, bool) {
+	ref := vcdCluster.Credentials.SecretRef
+	if ref == nil {
+		return types.NamespacedName{}, false
+	}
+
+	return types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, true
+}
+
+// secretMetadata returns an empty Secret of which only the metadata is read,
+// from the metadata-only informer the credentials secrets are watched with.
+func secretMetadata() *metav1.PartialObjectMetadata {
+	secret := &metav1.PartialObjectMetadata{}
+	secret.SetGroupVersionKind(corev1.SchemeGroupVersion.WithKind("Secret"))
+
+	return secret
+}
+
+// protectSecret adds key.SecretFinalizerName to the credentials secret of the
+// VCDCluster, so deleting the namespace of the cluster does not take the
+// credentials away before the clean-up ran. A missing secret is left to the
+// clean-up to report.
+func (r *VCDClusterReconciler) protectSecret(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster) error {
+	name, ok := credentialsSecret(vcdCluster)
+	if !ok {
+		return nil
+	}
+
+	secret := secretMetadata()
+	if err := r.Get(ctx, name, secret); apierrors.IsNotFound(err) {
+		log.V(1).Info("Credentials secret not found, not protecting it", "secret", name)
+		return nil
+	} else if err != nil {
+		r.countError(ErrorClassKubernetes)
+		return microerror.Mask(err)
+	}
+
+	// No finalizer can be added to an object that is being deleted.
+	if !secret.DeletionTimestamp.IsZero() || controllerutil.ContainsFinalizer(secret, key.SecretFinalizerName) {
+		return nil
+	}
+
+	patch := client.MergeFrom(secret.DeepCopy())
+	controllerutil.AddFinalizer(secret, key.SecretFinalizerName)
+	if err := r.Patch(ctx, secret, patch); err != nil {
+		r.countError(ErrorClassKubernetes)
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// releaseSecret removes key.SecretFinalizerName from the credentials secret of
+// the VCDCluster, unless another VCDCluster still needs the secret.
+func (r *VCDClusterReconciler) releaseSecret(ctx context.Context, vcdCluster *vcdcluster.Cluster) error {
+	name, ok := credentialsSecret(vcdCluster)
+	if !ok {
+		return nil
+	}
+
+	if err := releaseUnusedSecret(ctx, r.Client, r.Version, r.SecretRefsIndexed, name, vcdCluster.GetUID()); err != nil {
+		r.countError(ErrorClassKubernetes)
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// needsSecret tells whether the VCDCluster still needs its credentials secret:
+// until it is deleted, and then until its own clean-up is done. The clean-up
+// never runs for a VCDCluster without the cluster name label.
+func needsSecret(vcdCluster *vcdcluster.Cluster) bool {
+	if vcdCluster.GetDeletionTimestamp().IsZero() {
+		return true
+	}
+	if !controllerutil.ContainsFinalizer(vcdCluster.ClientObject(), key.CleanerFinalizerName) {
+		return false
+	}
+	_, ok := vcdCluster.GetLabels()[key.CapiClusterLabelKey]
+
+	return ok
+}
+
+// releaseUnusedSecret removes key.SecretFinalizerName from the secret, unless
+// a VCDCluster other than the one with the ignored uid still needs it.
+func releaseUnusedSecret(ctx context.Context, c client.Client, version *vcdcluster.Version, indexed bool, name types.NamespacedName, ignored types.UID) error {
+	vcdClusters, err := vcdClustersWithSecret(ctx, c, version, indexed, name)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	for _, vcdCluster := range vcdClusters {
+		if ignored != "" && vcdCluster.GetUID() == ignored {
+			continue
+		}
+		if needsSecret(vcdCluster) {
+			return nil
+		}
+	}
+
+	secret := secretMetadata()
+	if err := c.Get(ctx, name, secret); apierrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return microerror.Mask(err)
+	}
+	if !controllerutil.ContainsFinalizer(secret, key.SecretFinalizerName) {
+		return nil
+	}
+
+	patch := client.MergeFrom(secret.DeepCopy())
+	controllerutil.RemoveFinalizer(secret, key.SecretFinalizerName)
+	if err := c.Patch(ctx, secret, patch); err != nil && !apierrors.IsNotFound(err) {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/giantswarm/microerror"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// secretRefField indexes VCDClusters on the namespace/name of their
+// credentials secret.
+const secretRefField = "vcdcluster.secretRef"
+
+// IndexSecretRefs registers the index the reconcilers with SecretRefsIndexed
+// look up the VCDClusters of a credentials secret in. It is registered once
+// per manager, before the reconcilers are set up.
+func IndexSecretRefs(ctx context.Context, indexer client.FieldIndexer, version *vcdcluster.Version) error {
+	err := indexer.IndexField(ctx, version.NewObject(), secretRefField, func(obj client.Object) []string {
+		name, ok := secretOf(obj)
+		if !ok {
+			return nil
+		}
+
+		return []string{name.String()}
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// vcdClustersWithSecret returns the VCDClusters that log in with the secret.
+// Without the index, as with a client that does not read from the cache, it
+// lists every VCDCluster.
+func vcdClustersWithSecret(ctx context.Context, c client.Reader, version *vcdcluster.Version, indexed bool, name types.NamespacedName) ([]*vcdcluster.Cluster, error) {
+	var opts []client.ListOption
+	if indexed {
+		opts = append(opts, client.MatchingFields{secretRefField: name.String()})
+	}
+
+	all, err := version.List(ctx, c, opts...)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var vcdClusters []*vcdcluster.Cluster
+	for _, vcdCluster := range all {
+		if otherName, ok := credentialsSecret(vcdCluster); ok && otherName == name {
+			vcdClusters = append(vcdClusters, vcdCluster)
+		}
+	}
+
+	return vcdClusters, nil
+}
@@ -0,0 +1,198 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// VCDClientReadyCondition is the type of the condition stored in
+// key.VCDClientConditionAnnotation.
+const VCDClientReadyCondition = "VCDClientReady"
+
+// What the reconciler does once it gave up on building a VCD client.
+const (
+	// GiveUpKeepFinalizer stops requeueing. The VCDCluster stays until the
+	// credentials are fixed and it is reconciled again.
+	GiveUpKeepFinalizer = "keep-finalizer"
+	// GiveUpRemoveFinalizer removes the finalizer and leaves whatever is left
+	// in VCD behind.
+	GiveUpRemoveFinalizer = "remove-finalizer"
+)
+
+const (
+	defaultVCDClientBackoffBase = 10 * time.Second
+	defaultVCDClientBackoffMax  = 10 * time.Minute
+)
+
+// Reasons of the events emitted when the VCD client can't be built.
+const (
+	ReasonVCDClientFailed = "VCDClientFailed"
+	ReasonVCDClientGaveUp = "VCDClientGaveUp"
+)
+
+// VCDClientBackoff controls the retries of a clean-up whose VCD client can't
+// be built. Zero durations take the defaults, a zero GiveUpAfter never gives up.
+type VCDClientBackoff struct {
+	Base         time.Duration
+	Max          time.Duration
+	GiveUpAfter  time.Duration
+	GiveUpPolicy string
+}
+
+// delay is the backoff after the given number of consecutive failures.
+func (b VCDClientBackoff) delay(failures int) time.Duration {
+	base, ceiling := b.Base, b.Max
+	if base <= 0 {
+		base = defaultVCDClientBackoffBase
+	}
+	if ceiling <= 0 {
+		ceiling = defaultVCDClientBackoffMax
+	}
+
+	delay := base
+	for i := 1; i < failures && delay < ceiling; i++ {
+		delay *= 2
+	}
+	if delay > ceiling {
+		delay = ceiling
+	}
+
+	return delay
+}
+
+// VCDClientCondition is stored as json in key.VCDClientConditionAnnotation
+// while the VCD client of a VCDCluster being deleted can't be built. Its
+// LastTransitionTime is the first failure.
+type VCDClientCondition struct {
+	metav1.Condition
+	// Failures counts the consecutive failures.
+	Failures int `json:"failures"`
+	// GaveUp is set once the give-up policy was applied.
+	GaveUp bool `json:"gaveUp,omitempty"`
+}
+
+// GetVCDClientCondition reads the condition from the VCDCluster. It returns
+// false when the client did not fail or the annotation is unreadable.
+func GetVCDClientCondition(vcdCluster metav1.Object) (VCDClientCondition, bool) {
+	raw, ok := vcdCluster.GetAnnotations()[key.VCDClientConditionAnnotation]
+	if !ok {
+		return VCDClientCondition{}, false
+	}
+
+	var condition VCDClientCondition
+	if err := json.Unmarshal([]byte(raw), &condition); err != nil {
+		return VCDClientCondition{}, false
+	}
+
+	return condition, true
+}
+
+// clearVCDClientCondition drops the condition once a VCD client was built.
+func (r *VCDClusterReconciler) clearVCDClientCondition(ctx context.Context, vcdCluster *vcdcluster.Cluster) error {
+	if _, ok := vcdCluster.GetAnnotations()[key.VCDClientConditionAnnotation]; !ok {
+		return nil
+	}
+
+	patch := client.MergeFrom(vcdCluster.DeepCopy().ClientObject())
+	delete(vcdCluster.GetAnnotations(), key.VCDClientConditionAnnotation)
+	if err := r.Patch(ctx, vcdCluster.ClientObject(), patch); err != nil {
+		r.countError(ErrorClassKubernetes)
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// reconcileVCDClientError records why the VCD client could not be built and
+// requeues with an exponential backoff, until the give-up policy applies.
+func (r *VCDClusterReconciler) reconcileVCDClientError(ctx context.Context, log logr.Logger, coreCluster *capi.Cluster, vcdCluster *vcdcluster.Cluster, clientErr error) (reconcile.Result, error) {
+	r.countError(ErrorClassVCDClient)
+	reason := vcd.ClassifyClientError(clientErr)
+
+	condition, _ := GetVCDClientCondition(vcdCluster)
+	if condition.Reason != reason || condition.LastTransitionTime.IsZero() {
+		condition.LastTransitionTime = metav1.Now()
+	}
+	condition.Type = VCDClientReadyCondition
+	condition.Status = metav1.ConditionFalse
+	condition.Reason = reason
+	condition.Message = clientErr.Error()
+	condition.Failures++
+
+	giveUp := r.VCDClientBackoff.GiveUpAfter > 0 &&
+		time.Since(condition.LastTransitionTime.Time) >= r.VCDClientBackoff.GiveUpAfter
+	if giveUp && r.VCDClientBackoff.GiveUpPolicy == GiveUpRemoveFinalizer {
+		log.Error(clientErr, "Giving up on the VCD client. Removing finalizer, VCD objects of the cluster may be left behind",
+			"reason", reason, "failures", condition.Failures)
+		r.recordEvent(coreCluster, vcdCluster, corev1.EventTypeWarning, ReasonVCDClientGaveUp, actionCleanup,
+			"Gave up building a VCD client after %d failures (%s), removed finalizer %s without cleaning up",
+			condition.Failures, reason, key.CleanerFinalizerName)
+		if err := r.removeFinalizer(ctx, vcdCluster); err != nil {
+			return reconcile.Result{}, microerror.Mask(err)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(vcdCluster.DeepCopy().ClientObject())
+	if giveUp {
+		r.notifyStuck(ctx, log, vcdCluster, clientErr)
+	} else {
+		r.notifyFailure(ctx, log, vcdCluster, nil, clientErr)
+	}
+	condition.GaveUp = giveUp
+	encoded, err := json.Marshal(condition)
+	if err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+	setAnnotation(vcdCluster, key.VCDClientConditionAnnotation, string(encoded))
+	if err := r.Patch(ctx, vcdCluster.ClientObject(), patch); err != nil {
+		r.countError(ErrorClassKubernetes)
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+
+	if giveUp {
+		log.Error(clientErr, "Giving up on the VCD client. Keeping finalizer until the cluster is reconciled again",
+			"reason", reason, "failures", condition.Failures)
+		r.recordEvent(coreCluster, vcdCluster, corev1.EventTypeWarning, ReasonVCDClientGaveUp, actionCleanup,
+			"Gave up building a VCD client after %d failures (%s), keeping finalizer %s",
+			condition.Failures, reason, key.CleanerFinalizerName)
+		return ctrl.Result{RequeueAfter: r.slowNotificationDue(vcdCluster)}, nil
+	}
+
+	delay := r.VCDClientBackoff.delay(condition.Failures)
+	log.Error(clientErr, "Failed to build the VCD client", "reason", reason, "failures", condition.Failures, "requeueAfter", delay)
+	r.recordEvent(coreCluster, vcdCluster, corev1.EventTypeWarning, ReasonVCDClientFailed, actionCleanup,
+		"Failed to build a VCD client (%s), retrying in %s: %v", reason, delay, clientErr)
+
+	return ctrl.Result{RequeueAfter: delay}, nil
+}
@@ -0,0 +1,97 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/giantswarm/microerror"
+
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
+)
+
+// VCDCleanupReportReconciler garbage collects VCDCleanupReports. A report is
+// deleted once its clean-up completed more than TTL ago, unless it lists
+// objects left in VCD on purpose: retained ones, renamed or not.
+type VCDCleanupReportReconciler struct {
+	client.Client
+	Log logr.Logger
+
+	TTL time.Duration
+}
+
+// +kubebuilder:rbac:groups=cleaner.giantswarm.io,resources=vcdcleanupreports,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cleaner.giantswarm.io,resources=vcdcleanupreports/status,verbs=get;update;patch
+
+func (r *VCDCleanupReportReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("vcdcleanupreport", req.NamespacedName)
+
+	var report cleanerv1alpha1.VCDCleanupReport
+	err := r.Get(ctx, req.NamespacedName, &report)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+
+	// A clean-up that is still running keeps its report.
+	if report.Status.CompletionTime == nil {
+		return reconcile.Result{}, nil
+	}
+
+	// The report is what tracks the objects left in VCD on purpose, so it is
+	// kept until someone deletes it by hand.
+	if tracksKeptObjects(&report) {
+		return reconcile.Result{}, nil
+	}
+
+	expiry := report.Status.CompletionTime.Add(r.TTL)
+	if remaining := time.Until(expiry); remaining > 0 {
+		return reconcile.Result{RequeueAfter: remaining}, nil
+	}
+
+	log.Info("Deleting expired clean-up report")
+	if err := r.Delete(ctx, &report); err != nil && !apierrors.IsNotFound(err) {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+
+	return reconcile.Result{}, nil
+}
+
+func tracksKeptObjects(report *cleanerv1alpha1.VCDCleanupReport) bool {
+	for _, o := range report.Status.Objects {
+		if o.Outcome == cleanerv1alpha1.OutcomeRetained || o.Outcome == cleanerv1alpha1.OutcomeRetainedRenamed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *VCDCleanupReportReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&cleanerv1alpha1.VCDCleanupReport{}).
+		Complete(r)
+}
@@ -18,28 +18,48 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta2"
 	"sigs.k8s.io/cluster-api/util"
 	"sigs.k8s.io/cluster-api/util/annotations"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/giantswarm/microerror"
 
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/audit"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/notify"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/sweeper"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 )
 
+// maxDryRunPlanBytes caps the dry-run plan annotation. The annotations of an
+// object share 256KiB, the plan of a large cluster would not fit in them.
+const maxDryRunPlanBytes = 64 << 10
+
 // VCDClientFactory builds the VCD api client used to clean up a vcdCluster.
-type VCDClientFactory func(ctx context.Context, c client.Client, vcdCluster *capvcd.VCDCluster, log logr.Logger) (*vcdsdk.Client, error)
+type VCDClientFactory func(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error)
 
 // VCDClusterReconciler reconciles a vcdCluster object
 type VCDClusterReconciler struct {
@@ -49,20 +69,75 @@ type VCDClusterReconciler struct {
 	ManagementCluster string
 	Cleaners          []cleaner.Cleaner
 
+	// DryRun makes reconcileDelete only plan the clean-up. The plan is logged and
+	// recorded on the VCDCluster, nothing is deleted and the finalizer stays.
+	DryRun bool
+
+	// Recorder emits an event for every VCD object the cleaners touch. No events
+	// are emitted when it is nil.
+	Recorder events.EventRecorder
+
+	// Workers is how many cleaners run at the same time. Cleaners only start
+	// once the cleaners they depend on are done. It defaults to 1.
+	Workers int
+
+	// VCDClientBackoff controls the retries when NewVCDClient fails.
+	VCDClientBackoff VCDClientBackoff
+
 	// NewVCDClient builds the VCD api client. It defaults to vcd.GetVCDClient.
 	NewVCDClient VCDClientFactory
+
+	// InvalidateVCDClient is called with a client VCD answered 401 to, so that
+	// a cached session is not reused. It may be nil.
+	InvalidateVCDClient func(vcdClient *vcdsdk.Client)
+
+	// Version is the api version VCDClusters are read and watched in, usually
+	// their storage version. It defaults to v1beta1.
+	Version *vcdcluster.Version
+
+	// Audit, when set, gets a record of every object the cleaners delete,
+	// detach or retain renamed. Dry runs are not recorded.
+	Audit audit.Sink
+
+	// Notifier posts a notification when the clean-up completes, failed
+	// NotifyAfterFailures times in a row, or still runs NotifyAfter after the
+	// deletion (zero turns either off). No notifications are sent when it is
+	// nil.
+	Notifier            notify.Sender
+	NotifyAfterFailures int
+	NotifyAfter         time.Duration
+
+	// Ledger, when set, holds the infra ids of the VCDClusters until their
+	// clean-up is done, which lets the orphan sweeper delete what is left of
+	// them.
+	Ledger *sweeper.Ledger
+
+	// ReportNamespace is the namespace cleanup reports are written to,
+	// usually the one of the controller, so deleting the namespace of a
+	// cluster does not take its report along. It defaults to the namespace
+	// of the VCDCluster.
+	ReportNamespace string
+
+	// SecretRefsIndexed tells the VCDClusters are indexed with
+	// IndexSecretRefs, so those using a credentials secret are looked up
+	// instead of listing all of them.
+	SecretRefsIndexed bool
 }
 
-// +kubebuilder:rbac:groups=,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=,resources=configmaps,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=,resources=secrets,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=vcdclusters,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=vcdclusters/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=cleaner.giantswarm.io,resources=vcdcleanupreports,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cleaner.giantswarm.io,resources=vcdcleanupreports/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get
 
 func (r *VCDClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	log := r.Log.WithValues("vcdcluster", req.NamespacedName)
 	log.V(1).Info("Reconciling")
 
-	var infraCluster capvcd.VCDCluster
-	err := r.Get(ctx, req.NamespacedName, &infraCluster)
+	infraCluster, err := r.Version.Get(ctx, r.Client, req.NamespacedName)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
 			return reconcile.Result{}, nil
@@ -71,7 +146,10 @@ func (r *VCDClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 
 	// Fetch the owner cluster.
-	coreCluster, err := util.GetOwnerCluster(ctx, r.Client, infraCluster.ObjectMeta)
+	coreCluster, err := util.GetOwnerCluster(ctx, r.Client, metav1.ObjectMeta{
+		Namespace:       infraCluster.GetNamespace(),
+		OwnerReferences: infraCluster.GetOwnerReferences(),
+	})
 	if err != nil {
 		return reconcile.Result{}, microerror.Mask(err)
 	}
@@ -83,55 +161,118 @@ func (r *VCDClusterReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	log = log.WithValues("cluster", coreCluster.Name)
 
 	// Return early if the core or infrastructure cluster is paused.
-	if annotations.IsPaused(coreCluster, &infraCluster) {
+	if annotations.IsPaused(coreCluster, infraCluster) {
 		log.Info("infrastructure or core cluster is marked as paused. Won't reconcile")
 		return ctrl.Result{}, nil
 	}
 
 	// Handle deleted clusters
-	if !infraCluster.DeletionTimestamp.IsZero() {
-		return r.reconcileDelete(ctx, log, &infraCluster)
+	if !infraCluster.GetDeletionTimestamp().IsZero() {
+		return r.reconcileDelete(ctx, log, coreCluster, infraCluster)
 	}
 
 	// Handle non-deleted clusters
-	return r.reconcileNormal(ctx, log, &infraCluster)
+	return r.reconcileNormal(ctx, log, infraCluster)
 }
 
 func (r *VCDClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&capvcd.VCDCluster{}).
+		For(r.Version.NewObject(), builder.WithPredicates(VCDClusterChanged())).
+		// Only the metadata of secrets is cached, their data is read from the
+		// api server when a clean-up logs in. Deleting a secret never
+		// resumes a clean-up.
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.VCDClustersForSecret),
+			builder.OnlyMetadata,
+			builder.WithPredicates(predicate.Funcs{
+				DeleteFunc: func(event.DeleteEvent) bool { return false },
+			})).
 		Complete(r)
 }
 
-func (r *VCDClusterReconciler) reconcileNormal(ctx context.Context, log logr.Logger, vcdCluster *capvcd.VCDCluster) (reconcile.Result, error) {
+// VCDClustersForSecret maps a credentials secret to the VCDClusters being
+// deleted that log in with it, so that fixing the secret resumes their
+// clean-up right away instead of after the backoff or the next resync.
+func (r *VCDClusterReconciler) VCDClustersForSecret(ctx context.Context, secret client.Object) []reconcile.Request {
+	vcdClusters, err := vcdClustersWithSecret(ctx, r.Client, r.Version, r.SecretRefsIndexed, client.ObjectKeyFromObject(secret))
+	if err != nil {
+		r.Log.Error(err, "Failed to list the VCDClusters that use a secret", "secret", client.ObjectKeyFromObject(secret))
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, vcdCluster := range vcdClusters {
+		if vcdCluster.GetDeletionTimestamp().IsZero() || !controllerutil.ContainsFinalizer(vcdCluster.ClientObject(), key.CleanerFinalizerName) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(vcdCluster.ClientObject())})
+	}
+
+	return requests
+}
+
+func (r *VCDClusterReconciler) reconcileNormal(ctx context.Context, log logr.Logger, vcdCluster *vcdcluster.Cluster) (reconcile.Result, error) {
 	// If the vcdCluster doesn't have the finalizer, add it.
-	if !controllerutil.ContainsFinalizer(vcdCluster, key.CleanerFinalizerName) {
-		controllerutil.AddFinalizer(vcdCluster, key.CleanerFinalizerName)
+	if !controllerutil.ContainsFinalizer(vcdCluster.ClientObject(), key.CleanerFinalizerName) {
+		controllerutil.AddFinalizer(vcdCluster.ClientObject(), key.CleanerFinalizerName)
 		// Register the finalizer immediately to avoid orphaning VCD resources on delete
-		if err := r.Update(ctx, vcdCluster); err != nil {
+		if err := r.Update(ctx, vcdCluster.ClientObject()); err != nil {
 			return reconcile.Result{}, microerror.Mask(err)
 		}
 	}
 
+	if err := r.protectSecret(ctx, log, vcdCluster); err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+
+	if err := r.ownInfraId(ctx, vcdCluster); err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+
 	// Cleaner doesn't do anything for normal
 	return ctrl.Result{}, nil
 }
 
-func (r *VCDClusterReconciler) reconcileDelete(ctx context.Context, log logr.Logger, vcdCluster *capvcd.VCDCluster) (reconcile.Result, error) {
-	if !controllerutil.ContainsFinalizer(vcdCluster, key.CleanerFinalizerName) {
+func (r *VCDClusterReconciler) reconcileDelete(ctx context.Context, log logr.Logger, coreCluster *capi.Cluster, vcdCluster *vcdcluster.Cluster) (reconcile.Result, error) {
+	if !controllerutil.ContainsFinalizer(vcdCluster.ClientObject(), key.CleanerFinalizerName) {
 		// no-op in case the finalizer is not there (it could have been deleted manually)
 		return ctrl.Result{}, nil
 	}
 
-	clusterName, ok := vcdCluster.Labels[key.CapiClusterLabelKey]
+	clusterName, ok := vcdCluster.GetLabels()[key.CapiClusterLabelKey]
 	if !ok {
 		log.V(1).Info("VCDcluster doesn't have necessary label",
 			"expectedLabelKey", key.CapiClusterLabelKey,
-			"existingLabels", vcdCluster.Labels)
+			"existingLabels", vcdCluster.GetLabels())
 		return ctrl.Result{}, nil
 	}
 
+	var report *cleanerv1alpha1.VCDCleanupReport
 	if len(vcdCluster.Status.InfraId) > 0 {
+		if err := vcd.ValidateInfraId(vcdCluster.Status.InfraId); err != nil {
+			// Objects are matched on the infra id, so cleaning with one that
+			// could match other clusters is not safe. The finalizer stays
+			// until someone looks into it.
+			log.Error(err, "Refusing to clean up the VCD resources of the cluster", "infraId", vcdCluster.Status.InfraId)
+			r.recordEvent(coreCluster, vcdCluster, corev1.EventTypeWarning, ReasonInvalidInfraId, actionCleanup,
+				"Refusing to clean up, infra id %q does not look like one CAPVCD assigns", vcdCluster.Status.InfraId)
+			if r.Notifier == nil {
+				return reconcile.Result{}, nil
+			}
+
+			patch := client.MergeFrom(vcdCluster.DeepCopy().ClientObject())
+			r.notifyStuck(ctx, log, vcdCluster, err)
+			if err := r.Patch(ctx, vcdCluster.ClientObject(), patch); err != nil {
+				r.countError(ErrorClassKubernetes)
+				return reconcile.Result{}, microerror.Mask(err)
+			}
+			return reconcile.Result{RequeueAfter: r.slowNotificationDue(vcdCluster)}, nil
+		}
+
+		// The cluster may be deleted before it was ever reconciled.
+		if err := r.ownInfraId(ctx, vcdCluster); err != nil {
+			return reconcile.Result{}, microerror.Mask(err)
+		}
+
 		newVCDClient := r.NewVCDClient
 		if newVCDClient == nil {
 			newVCDClient = vcd.GetVCDClient
@@ -139,34 +280,315 @@ func (r *VCDClusterReconciler) reconcileDelete(ctx context.Context, log logr.Log
 
 		vcdClient, err := newVCDClient(ctx, r.Client, vcdCluster, log)
 		if err != nil {
-			return ctrl.Result{}, nil
+			return r.reconcileVCDClientError(ctx, log, coreCluster, vcdCluster, err)
+		}
+		if err := r.clearVCDClientCondition(ctx, vcdCluster); err != nil {
+			return reconcile.Result{}, microerror.Mask(err)
+		}
+
+		if err := vcdcluster.LoadZones(ctx, r.Client, vcdCluster); err != nil {
+			log.Error(err, "Failed to read the zones of the cluster")
+			r.countError(ErrorClassKubernetes)
+			return reconcile.Result{}, microerror.Mask(err)
+		}
+
+		if r.DryRun {
+			return r.reconcileDryRun(ctx, log, vcdClient, vcdCluster)
+		}
+
+		report, err = r.ensureCleanupReport(ctx, vcdCluster, clusterName)
+		if err != nil {
+			r.countError(ErrorClassKubernetes)
+			return reconcile.Result{}, microerror.Mask(err)
 		}
 
 		log.V(1).Info("Cleaning VCD resources belonging to cluster", "cluster", clusterName)
-		requeueForDeletion := false
-		for _, c := range r.Cleaners {
-			requeue, err := c.Clean(ctx, log, vcdClient, vcdCluster)
-			if err != nil {
-				return reconcile.Result{}, microerror.Mask(err)
+		patch := client.MergeFrom(vcdCluster.DeepCopy().ClientObject())
+		collector := &reportCollector{}
+		requeueForDeletion, err := r.runCleaners(ctx, log, vcdClient, coreCluster, vcdCluster, collector)
+		r.invalidateVCDClient(log, vcdClient, err)
+		if reportErr := r.updateCleanupReport(ctx, report, collector, err == nil && !requeueForDeletion); reportErr != nil {
+			log.Error(reportErr, "Failed to update the clean-up report", "report", report.Name)
+			r.countError(ErrorClassKubernetes)
+			if err == nil {
+				err = reportErr
 			}
-			requeueForDeletion = requeueForDeletion || requeue
+		}
+		if err != nil {
+			r.notifyFailure(ctx, log, vcdCluster, report, err)
+			if patchErr := r.Patch(ctx, vcdCluster.ClientObject(), patch); patchErr != nil {
+				log.Error(patchErr, "Failed to record the cleaner status")
+				r.countError(ErrorClassKubernetes)
+			}
+			return reconcile.Result{}, microerror.Mask(err)
 		}
 
 		if requeueForDeletion {
 			log.V(1).Info("There is an ongoing clean-up process. Adding cluster into queue again")
+			r.notifyProgress(ctx, log, vcdCluster, report)
+			if err := r.Patch(ctx, vcdCluster.ClientObject(), patch); err != nil {
+				r.countError(ErrorClassKubernetes)
+				return reconcile.Result{}, microerror.Mask(err)
+			}
 			return ctrl.Result{Requeue: true, RequeueAfter: time.Second * 10}, nil
 		}
+	} else if r.DryRun {
+		log.Info("Status.InfraId is empty. Nothing would be cleaned up, keeping the finalizer in dry-run mode.")
+		return ctrl.Result{}, nil
 	} else {
 		log.Info("Status.InfraId is empty. Assuming the cluster creation failed and there is nothing to clean up.")
 	}
 
 	log.Info("Clean-up is done. Removing finalizer")
+	if err := r.releaseInfraId(ctx, vcdCluster); err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+	if err := r.removeFinalizer(ctx, vcdCluster); err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+	if !vcdCluster.GetDeletionTimestamp().IsZero() {
+		cleanupDuration.WithLabelValues(r.ManagementCluster, totalCleaner).Observe(time.Since(vcdCluster.GetDeletionTimestamp().Time).Seconds())
+	}
+	r.recordEvent(coreCluster, vcdCluster, corev1.EventTypeNormal, ReasonCleanupCompleted, actionCleanup,
+		"Clean-up of VCD resources is done, removed finalizer %s", key.CleanerFinalizerName)
+	r.notifyCompleted(ctx, log, vcdCluster, report)
+
+	return ctrl.Result{}, nil
+}
+
+// invalidateVCDClient drops vcdClient when err says its session is no longer
+// valid, so the next reconcile logs in again.
+func (r *VCDClusterReconciler) invalidateVCDClient(log logr.Logger, vcdClient *vcdsdk.Client, err error) {
+	if r.InvalidateVCDClient == nil || !vcd.IsUnauthorized(err) {
+		return
+	}
+
+	log.Info("VCD refused the session, logging in again on the next reconcile")
+	r.InvalidateVCDClient(vcdClient)
+}
+
+// removeFinalizer lets the deletion of vcdCluster go on.
+func (r *VCDClusterReconciler) removeFinalizer(ctx context.Context, vcdCluster *vcdcluster.Cluster) error {
 	// vcdCluster is deleted so remove the finalizer.
-	controllerutil.RemoveFinalizer(vcdCluster, key.CleanerFinalizerName)
+	controllerutil.RemoveFinalizer(vcdCluster.ClientObject(), key.CleanerFinalizerName)
 	// Finally remove the finalizer
-	if err := r.Update(ctx, vcdCluster); err != nil {
+	if err := r.Update(ctx, vcdCluster.ClientObject()); err != nil {
+		r.countError(ErrorClassKubernetes)
+		return microerror.Mask(err)
+	}
+
+	// The credentials are no longer needed once the VCDCluster goes.
+	if err := r.releaseSecret(ctx, vcdCluster); err != nil {
+		return microerror.Mask(err)
+	}
+
+	return nil
+}
+
+// cleanerRun is the outcome of one cleaner in runCleaners.
+type cleanerRun struct {
+	ran      bool
+	requeue  bool
+	err      error
+	deleted  int
+	retained int
+	// tasks are the VCD tasks the cleaner still waits for.
+	tasks []cleaner.Task
+}
+
+// runCleaners runs the cleaners once the cleaners they depend on succeeded,
+// up to Workers of them at a time, and records the outcome of each one on
+// vcdCluster and in the collector. After an error no further cleaner is
+// started, the ones that did not run stay pending.
+func (r *VCDClusterReconciler) runCleaners(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, coreCluster *capi.Cluster, vcdCluster *vcdcluster.Cluster, collector *reportCollector) (bool, error) {
+	deps, err := cleaner.Dependencies(r.Cleaners)
+	if err != nil {
+		return false, microerror.Mask(err)
+	}
+
+	workers := r.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	runs := make([]cleanerRun, len(r.Cleaners))
+	started := make([]bool, len(r.Cleaners))
+	finished := make([]bool, len(r.Cleaners))
+	done := make(chan int)
+	running := 0
+	failed := false
+
+	ready := func(i int) bool {
+		for _, j := range deps[i] {
+			if !finished[j] || runs[j].err != nil || runs[j].requeue {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		// Cleaners are started in list order, so a single worker runs them
+		// the way they are listed.
+		for i, c := range r.Cleaners {
+			if failed || running >= workers {
+				break
+			}
+			if started[i] || !ready(i) {
+				continue
+			}
+			started[i] = true
+			running++
+			go func(i int, c cleaner.Cleaner) {
+				runs[i] = r.runCleaner(ctx, log, vcdClient, coreCluster, vcdCluster, collector, c)
+				done <- i
+			}(i, c)
+		}
+		if running == 0 {
+			break
+		}
+
+		i := <-done
+		running--
+		finished[i] = true
+		failed = failed || runs[i].err != nil
+	}
+
+	requeueForDeletion := false
+	var firstErr error
+	for i, c := range r.Cleaners {
+		run := runs[i]
+		if run.ran {
+			setCleanerTasks(vcdCluster, c.Name(), run.tasks)
+		}
+		switch {
+		case !run.ran:
+			setCleanerStatus(vcdCluster, c.Name(), CleanerPending, "", 0, GetCleanerStatus(vcdCluster, c.Name()).Retained)
+			// It waits for a cleaner that is still in progress.
+			requeueForDeletion = true
+		case run.err != nil:
+			r.countError(ErrorClassCleaner)
+			collector.addError(c.Name(), run.err)
+			r.recordEvent(coreCluster, vcdCluster, corev1.EventTypeWarning, ReasonCleanerFailed, actionCleanup,
+				"%s failed: %v", c.Name(), run.err)
+			setCleanerStatus(vcdCluster, c.Name(), CleanerFailed, run.err.Error(), run.deleted, run.retained)
+			if firstErr == nil {
+				firstErr = run.err
+			}
+		case run.requeue:
+			setCleanerStatus(vcdCluster, c.Name(), CleanerInProgress, "", run.deleted, run.retained)
+			requeueForDeletion = true
+		default:
+			setCleanerStatus(vcdCluster, c.Name(), CleanerSucceeded, "", run.deleted, run.retained)
+		}
+	}
+	if firstErr != nil {
+		return false, firstErr
+	}
+
+	return requeueForDeletion, nil
+}
+
+// runCleaner runs one cleaner with the observers that count, report, and
+// emit events and metrics for what it deletes or retains. The cleaner starts
+// VCD tasks without waiting for them, it gets back the ones the previous run
+// left pending.
+func (r *VCDClusterReconciler) runCleaner(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, coreCluster *capi.Cluster, vcdCluster *vcdcluster.Cluster, collector *reportCollector, c cleaner.Cleaner) cleanerRun {
+	counter := &deleteCounter{}
+	cleanerCtx := cleaner.WithObserver(cleaner.WithObserver(ctx, counter), collector.forCleaner(c.Name()))
+	cleanerCtx = cleaner.WithObserver(cleanerCtx, &eventObserver{reconciler: r, coreCluster: coreCluster, vcdCluster: vcdCluster, cleaner: c.Name()})
+	cleanerCtx = cleaner.WithObserver(cleanerCtx, &metricsObserver{managementCluster: r.ManagementCluster, cleaner: c.Name()})
+	if r.Audit != nil {
+		cleanerCtx = cleaner.WithObserver(cleanerCtx, audit.NewObserver(r.Audit, log, r.ManagementCluster, vcdClient, vcdCluster, c.Name()))
+	}
+	tasks := cleaner.NewTasks(getCleanerTasks(vcdCluster, c.Name()))
+	cleanerCtx = cleaner.WithTasks(cleanerCtx, tasks)
+
+	start := time.Now()
+	requeue, err := c.Clean(cleanerCtx, log, vcdClient, vcdCluster)
+	cleanupDuration.WithLabelValues(r.ManagementCluster, c.Name()).Observe(time.Since(start).Seconds())
+
+	// A cleaner that reports a retained object once per clean-up keeps it in
+	// its tasks, it is retained by every run.
+	deleted, retained := counter.count()
+	retained = max(retained, tasks.Retained())
+	return cleanerRun{ran: true, requeue: requeue, err: err, deleted: deleted, retained: retained, tasks: tasks.List()}
+}
+
+// reconcileDryRun asks every cleaner what it would delete, logs the result and
+// records it in the plan annotation. The finalizer is kept, so the VCDCluster
+// stays until the manager runs without --dry-run.
+func (r *VCDClusterReconciler) reconcileDryRun(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, vcdCluster *vcdcluster.Cluster) (reconcile.Result, error) {
+	plan := []cleaner.Object{}
+	for _, c := range r.Cleaners {
+		objects, err := c.Plan(ctx, log, vcdClient, vcdCluster)
+		if err != nil {
+			r.invalidateVCDClient(log, vcdClient, err)
+			return reconcile.Result{}, microerror.Mask(err)
+		}
+		plan = append(plan, objects...)
+	}
+
+	renamed := 0
+	for _, o := range plan {
+		if o.Action == cleaner.OperationRetainRenamed {
+			renamed++
+			log.Info("Dry-run: would retain VCD object renamed", "kind", o.Kind, "name", o.Name, "id", o.ID)
+			continue
+		}
+		log.Info("Dry-run: would delete VCD object", "kind", o.Kind, "name", o.Name, "id", o.ID)
+	}
+	log.Info(fmt.Sprintf("Dry-run: %d VCD objects would be deleted and %d retained renamed. Keeping finalizer", len(plan)-renamed, renamed))
+
+	encoded, omitted, err := encodePlan(plan)
+	if err != nil {
+		return reconcile.Result{}, microerror.Mask(err)
+	}
+	omittedValue := ""
+	if omitted > 0 {
+		omittedValue = strconv.Itoa(omitted)
+		log.Info(fmt.Sprintf("Dry-run: the recorded plan leaves out %d VCD objects", omitted))
+	}
+	annotations := vcdCluster.GetAnnotations()
+	if annotations[key.DryRunPlanAnnotation] == encoded && annotations[key.DryRunPlanOmittedAnnotation] == omittedValue {
+		return ctrl.Result{}, nil
+	}
+
+	patch := client.MergeFrom(vcdCluster.DeepCopy().ClientObject())
+	setAnnotation(vcdCluster, key.DryRunPlanAnnotation, encoded)
+	if omitted > 0 {
+		setAnnotation(vcdCluster, key.DryRunPlanOmittedAnnotation, omittedValue)
+	} else {
+		delete(vcdCluster.GetAnnotations(), key.DryRunPlanOmittedAnnotation)
+	}
+	if err := r.Patch(ctx, vcdCluster.ClientObject(), patch); err != nil {
 		return reconcile.Result{}, microerror.Mask(err)
 	}
 
 	return ctrl.Result{}, nil
 }
+
+// encodePlan encodes the objects of plan, in order, as long as they fit in
+// maxDryRunPlanBytes. It returns how many objects were left out.
+func encodePlan(plan []cleaner.Object) (string, int, error) {
+	var b strings.Builder
+	b.WriteString("[")
+	for i, o := range plan {
+		encoded, err := json.Marshal(o)
+		if err != nil {
+			return "", 0, microerror.Mask(err)
+		}
+		// Room is left for the comma and the closing bracket.
+		if b.Len()+len(encoded)+2 > maxDryRunPlanBytes {
+			b.WriteString("]")
+			return b.String(), len(plan) - i, nil
+		}
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.Write(encoded)
+	}
+	b.WriteString("]")
+
+	return b.String(), 0, nil
+}
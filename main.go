@@ -21,24 +21,46 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	capvcdv1beta1 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	capvcdv1beta2 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta2"
 	"go.uber.org/zap/zapcore"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	capi "sigs.k8s.io/cluster-api/api/core/v1beta2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 
 	"github.com/giantswarm/microerror"
 
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/controllers"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/audit"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/notify"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/sweeper"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 	// +kubebuilder:scaffold:imports
 )
 
+// auditWebhookBuffer is the number of audit records waiting for the webhook
+// before the destructive calls stop.
+const auditWebhookBuffer = 1000
+
+// notificationBuffer is the number of notifications waiting to be sent before
+// new ones are dropped.
+const notificationBuffer = 100
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -47,8 +69,11 @@ var (
 func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
-	_ = capvcd.AddToScheme(scheme)
+	_ = apiextensionsv1.AddToScheme(scheme)
+	_ = capvcdv1beta1.AddToScheme(scheme)
+	_ = capvcdv1beta2.AddToScheme(scheme)
 	_ = capi.AddToScheme(scheme)
+	_ = cleanerv1alpha1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
@@ -62,10 +87,30 @@ func main() {
 
 func mainE(ctx context.Context) error {
 	var (
+		auditLogFile         string
+		auditWebhookURL      string
+		cleanupReportTTL     time.Duration
+		cleanerWorkers       int
+		deleteConcurrency    cleaner.Concurrency
+		diskBackup           string
+		dryRun               bool
 		enableLeaderElection bool
 		managementCluster    string
 		metricsAddr          string
+		namespace            string
+		notificationsFile    string
+		notifyAfterFailures  int
+		notifyAfter          time.Duration
+		orphanSweepDelete    bool
+		orphanSweepInterval  time.Duration
 		logLevel             int
+		vcdClientBackoff     controllers.VCDClientBackoff
+		vcdSessionMaxAge     time.Duration
+		vcdSessionIdle       time.Duration
+		vcdCABundle          string
+		vcdInsecureSites     string
+		vcdProxies           string
+		vcdRateLimit         vcd.RateLimit
 	)
 
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
@@ -74,6 +119,74 @@ func mainE(ctx context.Context) error {
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 
 	flag.StringVar(&managementCluster, "management-cluster", "", "Name of the management cluster.")
+	flag.StringVar(&namespace, "namespace", "",
+		"Namespace the manager runs in. It writes the cleanup reports there, defaulting to the namespace of each VCDCluster, and keeps the infra ids of the clusters it manages there, the orphan sweeper only deletes the orphans of those.")
+
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Only log and record what the cleaners would delete from VCD. "+
+			"Nothing is deleted and the finalizer is kept on deleted clusters.")
+
+	flag.DurationVar(&cleanupReportTTL, "cleanup-report-ttl", 30*24*time.Hour,
+		"How long a VCDCleanupReport is kept after its clean-up completed. Zero keeps reports forever. "+
+			"Reports that list retained objects are always kept.")
+
+	flag.IntVar(&cleanerWorkers, "cleaner-workers", 1,
+		"How many cleaners run at the same time for one cluster. Cleaners still wait for the ones they depend on. One runs them one after the other.")
+
+	flag.IntVar(&deleteConcurrency.Workers, "delete-workers", 1,
+		"How many VCD objects one cleaner deletes at the same time. A failed object does not stop the others.")
+	flag.IntVar(&deleteConcurrency.PerGateway, "gateway-delete-limit", 1,
+		"How many VCD objects are deleted at the same time on one edge gateway, over every cleaner and cluster. Zero means no limit.")
+
+	flag.StringVar(&diskBackup, "disk-backup", cleaner.DiskBackupNone,
+		fmt.Sprintf("What to do with the disks of a deleted cluster: %q deletes them, %q detaches and renames them instead, without copying them. "+
+			"The %s annotation overrides it per VCDCluster.", cleaner.DiskBackupNone, cleaner.DiskBackupRetainRenamed, key.DiskBackupAnnotation))
+
+	flag.DurationVar(&orphanSweepInterval, "orphan-sweep-interval", 0,
+		"How often to look for VCD objects whose infra id matches no VCDCluster. Zero disables the sweeper.")
+	flag.BoolVar(&orphanSweepDelete, "orphan-sweep-delete", false,
+		"Delete the orphaned VCD objects the sweeper finds twice in a row, instead of only reporting them, when their infra id is one of a cluster this manager managed. "+
+			"Needs --namespace. Ignored with --dry-run.")
+
+	flag.DurationVar(&vcdClientBackoff.Base, "vcd-client-backoff-base", 10*time.Second,
+		"First retry delay when the VCD client of a deleted cluster can't be built. It doubles on every failure.")
+	flag.DurationVar(&vcdClientBackoff.Max, "vcd-client-backoff-max", 10*time.Minute,
+		"Longest retry delay when the VCD client of a deleted cluster can't be built.")
+	flag.DurationVar(&vcdClientBackoff.GiveUpAfter, "vcd-client-give-up-after", 24*time.Hour,
+		"How long to retry building the VCD client of a deleted cluster before applying --vcd-client-give-up-policy. Zero retries forever.")
+	flag.StringVar(&vcdClientBackoff.GiveUpPolicy, "vcd-client-give-up-policy", controllers.GiveUpKeepFinalizer,
+		fmt.Sprintf("What to do once --vcd-client-give-up-after is hit: %q stops retrying, %q removes the finalizer without cleaning up.",
+			controllers.GiveUpKeepFinalizer, controllers.GiveUpRemoveFinalizer))
+
+	flag.DurationVar(&vcdSessionMaxAge, "vcd-session-max-age", 20*time.Minute,
+		"How long a VCD session is reused between reconciles of clusters with the same site, org, vdc and credentials. Zero logs in on every reconcile.")
+	flag.DurationVar(&vcdSessionIdle, "vcd-session-idle-timeout", 10*time.Minute,
+		"Drop a cached VCD session that was not used for that long. It should be shorter than the session timeout of VCD. Zero only applies --vcd-session-max-age.")
+
+	flag.StringVar(&vcdCABundle, "vcd-ca-bundle", "",
+		"PEM file of CA certificates trusted for every VCD site, on top of the system roots. "+
+			fmt.Sprintf("The %s annotation names a ConfigMap with more for one VCDCluster.", key.CABundleAnnotation))
+	flag.StringVar(&vcdInsecureSites, "vcd-insecure-sites", "",
+		"Comma separated VCD sites, as urls or host names, whose certificates are not verified.")
+	flag.StringVar(&vcdProxies, "vcd-proxies", "",
+		"Yaml file listing the egress proxies of the VCD sites that are not reached directly. Other sites use the proxy of the environment.")
+
+	flag.Float64Var(&vcdRateLimit.RequestsPerSecond, "vcd-rate-limit", 10,
+		"How many requests per second are sent to one VCD site, over every cleaner, cluster and the sweeper. Zero means no limit.")
+	flag.IntVar(&vcdRateLimit.Burst, "vcd-rate-limit-burst", 20,
+		"How many requests are sent to one VCD site at once after a quiet time, before --vcd-rate-limit applies.")
+
+	flag.StringVar(&auditLogFile, "audit-log-file", "",
+		"File the audit records of every VCD object deleted, detached or renamed are appended to, as json lines.")
+	flag.StringVar(&auditWebhookURL, "audit-webhook-url", "",
+		"Url every audit record is posted to as json.")
+
+	flag.StringVar(&notificationsFile, "notification-endpoints", "",
+		"Yaml file listing the http endpoints notified when the clean-up of a cluster completes, keeps failing or takes too long.")
+	flag.IntVar(&notifyAfterFailures, "notify-after-failures", 3,
+		"Notify once the clean-up of a cluster failed that many times in a row. Zero turns it off.")
+	flag.DurationVar(&notifyAfter, "notify-after", time.Hour,
+		"Notify once the clean-up of a cluster still runs that long after its deletion. Zero turns it off.")
 
 	flag.IntVar(&logLevel, "v", 0, "Number for the log level verbosity")
 
@@ -84,9 +197,93 @@ func mainE(ctx context.Context) error {
 
 	flag.Parse()
 
+	if vcdClientBackoff.GiveUpPolicy != controllers.GiveUpKeepFinalizer && vcdClientBackoff.GiveUpPolicy != controllers.GiveUpRemoveFinalizer {
+		return fmt.Errorf("invalid --vcd-client-give-up-policy [%s]", vcdClientBackoff.GiveUpPolicy)
+	}
+	if deleteConcurrency.Workers < 1 {
+		return fmt.Errorf("invalid --delete-workers [%d]", deleteConcurrency.Workers)
+	}
+	if deleteConcurrency.PerGateway < 0 {
+		return fmt.Errorf("invalid --gateway-delete-limit [%d]", deleteConcurrency.PerGateway)
+	}
+	if vcdRateLimit.RequestsPerSecond < 0 {
+		return fmt.Errorf("invalid --vcd-rate-limit [%v]", vcdRateLimit.RequestsPerSecond)
+	}
+	if orphanSweepDelete && namespace == "" {
+		return fmt.Errorf("--orphan-sweep-delete needs --namespace")
+	}
+	if notifyAfterFailures < 0 {
+		return fmt.Errorf("invalid --notify-after-failures [%d]", notifyAfterFailures)
+	}
+	if vcdRateLimit.Burst < 1 {
+		return fmt.Errorf("invalid --vcd-rate-limit-burst [%d]", vcdRateLimit.Burst)
+	}
+	if err := cleaner.ValidateDiskBackup(diskBackup); err != nil {
+		return microerror.Mask(err)
+	}
+
 	level := int8(-logLevel) //nolint:gosec
 	ctrl.SetLogger(zap.New(zap.Level(zapcore.Level(level))))
 
+	var insecureSites []string
+	for _, site := range strings.Split(vcdInsecureSites, ",") {
+		if site = strings.TrimSpace(site); site != "" {
+			insecureSites = append(insecureSites, site)
+		}
+	}
+	caBundle, err := vcd.LoadCABundle(vcdCABundle)
+	if err != nil {
+		return fmt.Errorf("invalid --vcd-ca-bundle [%s]: [%v]", vcdCABundle, err)
+	}
+	proxies, err := vcd.LoadProxies(vcdProxies)
+	if err != nil {
+		return fmt.Errorf("invalid --vcd-proxies [%s]: [%v]", vcdProxies, err)
+	}
+	vcdTransport := &vcd.Transport{
+		CABundle:      caBundle,
+		InsecureSites: insecureSites,
+		Proxies:       proxies,
+
+		RateLimit:         vcdRateLimit,
+		ManagementCluster: managementCluster,
+	}
+	if len(insecureSites) > 0 {
+		setupLog.Info("not verifying the certificates of VCD sites", "sites", insecureSites)
+	}
+
+	var auditSinks audit.Sinks
+	if auditLogFile != "" {
+		fileSink, err := audit.NewFileSink(auditLogFile)
+		if err != nil {
+			return fmt.Errorf("invalid --audit-log-file [%s]: [%v]", auditLogFile, err)
+		}
+		defer fileSink.Close()
+		auditSinks = append(auditSinks, fileSink)
+	}
+	// The webhook is written to in the background, the manager runs it.
+	var auditWebhook *audit.BufferedSink
+	if auditWebhookURL != "" {
+		auditWebhook = audit.NewBufferedSink(&audit.WebhookSink{URL: auditWebhookURL}, auditWebhookBuffer, ctrl.Log.WithName("audit"))
+		auditSinks = append(auditSinks, auditWebhook)
+	}
+	var auditSink audit.Sink
+	if len(auditSinks) > 0 {
+		auditSink = auditSinks
+	}
+
+	endpoints, err := notify.LoadEndpoints(notificationsFile)
+	if err != nil {
+		return fmt.Errorf("invalid --notification-endpoints [%s]: [%v]", notificationsFile, err)
+	}
+	// Notifications are sent in the background, the manager runs the
+	// notifier.
+	var notifyBuffer *notify.BufferedNotifier
+	var notifier notify.Sender
+	if len(endpoints) > 0 {
+		notifyBuffer = notify.NewBufferedNotifier(&notify.Notifier{Endpoints: endpoints}, notificationBuffer, ctrl.Log.WithName("notify"))
+		notifier = notifyBuffer
+	}
+
 	config, err := ctrl.GetConfig()
 	if err != nil {
 		return err
@@ -99,18 +296,87 @@ func mainE(ctx context.Context) error {
 		},
 		LeaderElection:   enableLeaderElection,
 		LeaderElectionID: "cluster-api-cleaner-cloud-director.giantswarm.io",
+		// Secrets are read from the api server, so the data of every secret
+		// in the cluster is not held in memory. The reconcilers watch the
+		// metadata of secrets only.
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				DisableFor: []client.Object{&corev1.Secret{}},
+			},
+		},
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		return err
 	}
 
+	if auditWebhook != nil {
+		if err := mgr.Add(auditWebhook); err != nil {
+			setupLog.Error(err, "unable to add the audit webhook")
+			return err
+		}
+	}
+	if notifyBuffer != nil {
+		if err := mgr.Add(notifyBuffer); err != nil {
+			setupLog.Error(err, "unable to add the notifier")
+			return err
+		}
+	}
+
+	// Reading VCDClusters in their storage version needs no conversion, so the
+	// clean-up goes on while the CAPVCD webhook is gone.
+	version, err := vcdcluster.StorageVersion(ctx, mgr.GetAPIReader())
+	if err != nil {
+		setupLog.Error(err, "unable to find the VCDCluster storage version")
+		return err
+	}
+	setupLog.Info("reading VCDClusters", "version", version.GroupVersion.String())
+
+	if err := controllers.IndexSecretRefs(ctx, mgr.GetFieldIndexer(), version); err != nil {
+		setupLog.Error(err, "unable to index VCDClusters by credentials secret")
+		return err
+	}
+
+	// The cleaners share the limit of every gateway.
+	concurrency := &deleteConcurrency
+
+	volumeCleaner := cleaner.NewVolumeCleaner(mgr.GetClient())
+	volumeCleaner.DiskBackup = diskBackup
+	volumeCleaner.Concurrency = concurrency
+	virtualServiceCleaner := cleaner.NewVirtualServiceCleaner(mgr.GetClient())
+	virtualServiceCleaner.Concurrency = concurrency
+	lbPoolCleaner := cleaner.NewLBPoolCleaner(mgr.GetClient())
+	lbPoolCleaner.Concurrency = concurrency
+	dnatCleaner := cleaner.NewDNATCleaner(mgr.GetClient())
+	dnatCleaner.Concurrency = concurrency
+	appPortProfileCleaner := cleaner.NewAppPortProfileCleaner(mgr.GetClient())
+	appPortProfileCleaner.Concurrency = concurrency
+
 	cleaners := []cleaner.Cleaner{
-		cleaner.NewVolumeCleaner(mgr.GetClient()),
-		cleaner.NewVirtualServiceCleaner(mgr.GetClient()),
-		cleaner.NewLBPoolCleaner(mgr.GetClient()),
-		cleaner.NewDNATCleaner(mgr.GetClient()),
-		cleaner.NewAppPortProfileCleaner(mgr.GetClient()),
+		volumeCleaner,
+		virtualServiceCleaner,
+		lbPoolCleaner,
+		dnatCleaner,
+		appPortProfileCleaner,
+	}
+
+	if _, err := cleaner.Dependencies(cleaners); err != nil {
+		setupLog.Error(err, "invalid cleaner dependencies")
+		return err
+	}
+
+	// Sessions are shared by the reconciler and the sweeper.
+	clientCache := vcd.NewClientCache(vcdSessionMaxAge, vcdSessionIdle)
+	clientCache.Transport = vcdTransport
+
+	var ledger *sweeper.Ledger
+	if namespace != "" {
+		ledger = &sweeper.Ledger{
+			Client:            mgr.GetClient(),
+			Namespace:         namespace,
+			Name:              sweeper.LedgerName,
+			ManagementCluster: managementCluster,
+		}
 	}
 
 	if err = (&controllers.VCDClusterReconciler{
@@ -118,12 +384,80 @@ func mainE(ctx context.Context) error {
 		Log:    ctrl.Log.WithName("controllers").WithName("VCDCluster"),
 
 		ManagementCluster: managementCluster,
+		ReportNamespace:   namespace,
 		Cleaners:          cleaners,
+		DryRun:            dryRun,
+		Workers:           cleanerWorkers,
+		Recorder:          mgr.GetEventRecorder("cluster-api-cleaner-cloud-director"),
+		VCDClientBackoff:  vcdClientBackoff,
+
+		NewVCDClient:        clientCache.GetVCDClient,
+		InvalidateVCDClient: clientCache.Invalidate,
+		Version:             version,
+		Audit:               auditSink,
+
+		Notifier:            notifier,
+		NotifyAfterFailures: notifyAfterFailures,
+		NotifyAfter:         notifyAfter,
+
+		Ledger: ledger,
+
+		SecretRefsIndexed: true,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "VCDCluster")
 		return err
 	}
 
+	if err = (&controllers.CredentialsSecretReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("CredentialsSecret"),
+
+		Version:           version,
+		SecretRefsIndexed: true,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CredentialsSecret")
+		return err
+	}
+
+	if cleanupReportTTL > 0 {
+		if err = (&controllers.VCDCleanupReportReconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("VCDCleanupReport"),
+
+			TTL: cleanupReportTTL,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "VCDCleanupReport")
+			return err
+		}
+	}
+
+	if orphanSweepInterval > 0 {
+		if err := mgr.Add(&sweeper.OrphanSweeper{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("sweeper").WithName("Orphans"),
+
+			ManagementCluster: managementCluster,
+			Cleaners:          cleaners,
+			Interval:          orphanSweepInterval,
+			Delete:            orphanSweepDelete && !dryRun,
+
+			NewVCDClient:        clientCache.GetVCDClient,
+			InvalidateVCDClient: clientCache.Invalidate,
+			Version:             version,
+			Audit:               auditSink,
+			Ledger:              ledger,
+		}); err != nil {
+			setupLog.Error(err, "unable to add the orphan sweeper")
+			return err
+		}
+	}
+
+	metrics.Registry.MustRegister(&controllers.DeletionCollector{
+		Client:            mgr.GetClient(),
+		ManagementCluster: managementCluster,
+		Version:           version,
+	})
+
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -0,0 +1,78 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit records every destructive call the cleaners make to VCD. The
+// records are written to a Sink whatever the log level, for compliance.
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// Actions of the records.
+const (
+	ActionDelete        = "delete"
+	ActionDetach        = "detach"
+	ActionRetainRenamed = "retain-renamed"
+)
+
+// Outcomes of the records. A started record is written before the call, which
+// is not made when the record can't be written.
+const (
+	OutcomeStarted   = "started"
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+)
+
+// Record is one destructive VCD call.
+type Record struct {
+	Time time.Time `json:"time"`
+
+	// ManagementCluster names the manager that made the call, User the VCD
+	// user it was logged in as. User is empty for token logins.
+	ManagementCluster string `json:"managementCluster,omitempty"`
+	User              string `json:"user,omitempty"`
+
+	// Namespace and Cluster name the VCDCluster being cleaned up. Orphan is
+	// set, and Cluster empty, for the objects the orphan sweeper deletes.
+	Namespace string `json:"namespace"`
+	Cluster   string `json:"cluster,omitempty"`
+	Orphan    bool   `json:"orphan,omitempty"`
+	InfraId   string `json:"infraId"`
+	Site      string `json:"site"`
+	Org       string `json:"org"`
+	Cleaner   string `json:"cleaner"`
+
+	Action  string `json:"action"`
+	Kind    string `json:"kind"`
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name"`
+	Gateway string `json:"gateway,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	// VM is the vm a disk was detached from, RenamedTo the name a retained
+	// disk was renamed to.
+	VM        string `json:"vm,omitempty"`
+	RenamedTo string `json:"renamedTo,omitempty"`
+
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Sink stores records. Write is called from several goroutines.
+type Sink interface {
+	Write(ctx context.Context, r Record) error
+}
@@ -0,0 +1,130 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+var recordsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cluster_api_cleaner_cloud_director",
+	Name:      "audit_records_failed_total",
+	Help:      "Number of audit records that could not be written to every sink.",
+}, []string{"management_cluster"})
+
+func init() {
+	metrics.Registry.MustRegister(recordsFailed)
+}
+
+// Observer writes a record for every object a cleaner deletes, fails to
+// delete, detaches or backs up.
+type Observer struct {
+	Sink Sink
+	Log  logr.Logger
+
+	// Record holds the fields shared by the records of the cleaner.
+	Record Record
+}
+
+// NewObserver returns the observer of cleanerName cleaning up c with
+// vcdClient.
+func NewObserver(sink Sink, log logr.Logger, managementCluster string, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, cleanerName string) *Observer {
+	record := Record{
+		ManagementCluster: managementCluster,
+		Namespace:         c.GetNamespace(),
+		Cluster:           c.GetName(),
+		Orphan:            c.GetName() == "",
+		InfraId:           c.Status.InfraId,
+		Site:              c.Site,
+		Org:               c.Org,
+		Cleaner:           cleanerName,
+	}
+	if auth := vcdClient.VCDAuthConfig; auth != nil && auth.User != "" {
+		record.User = fmt.Sprintf("%s/%s", auth.UserOrg, auth.User)
+	}
+
+	return &Observer{Sink: sink, Log: log, Record: record}
+}
+
+// ObjectIntended writes the record of the call before it is made. The
+// operations of the cleaners are named as the actions of the records.
+func (o *Observer) ObjectIntended(ctx context.Context, obj cleaner.Object, operation string) error {
+	r := o.record(obj, operation, nil)
+	r.Outcome = OutcomeStarted
+	if err := o.Sink.Write(ctx, r); err != nil {
+		recordsFailed.WithLabelValues(r.ManagementCluster).Inc()
+		return fmt.Errorf("failed to write the audit record of the %s of %s [%s]: [%v]", operation, obj.Kind, obj.Name, err)
+	}
+
+	return nil
+}
+
+func (o *Observer) ObjectDeleted(ctx context.Context, obj cleaner.Object) {
+	o.write(ctx, o.record(obj, ActionDelete, nil))
+}
+
+func (o *Observer) ObjectFailed(ctx context.Context, obj cleaner.Object, err error) {
+	o.write(ctx, o.record(obj, ActionDelete, err))
+}
+
+func (o *Observer) ObjectDetached(ctx context.Context, obj cleaner.Object, vmName string) {
+	r := o.record(obj, ActionDetach, nil)
+	r.VM = vmName
+	o.write(ctx, r)
+}
+
+func (o *Observer) ObjectRetainedRenamed(ctx context.Context, obj cleaner.Object, renamed string) {
+	r := o.record(obj, ActionRetainRenamed, nil)
+	r.RenamedTo = renamed
+	o.write(ctx, r)
+}
+
+func (o *Observer) record(obj cleaner.Object, action string, err error) Record {
+	r := o.Record
+	r.Time = time.Now().UTC()
+	r.Action = action
+	r.Kind = obj.Kind
+	r.ID = obj.ID
+	r.Name = obj.Name
+	r.Gateway = obj.Gateway
+	r.Zone = obj.Zone
+	r.Outcome = OutcomeSucceeded
+	if err != nil {
+		r.Outcome = OutcomeFailed
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// write does not give up on the clean-up when the record of a call that was
+// made can't be written, it logs the record instead so it is not lost.
+func (o *Observer) write(ctx context.Context, r Record) {
+	if err := o.Sink.Write(ctx, r); err != nil {
+		recordsFailed.WithLabelValues(r.ManagementCluster).Inc()
+		o.Log.Error(err, "Failed to write the audit record", "record", r)
+	}
+}
@@ -0,0 +1,237 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// FileSink appends records to a file as json lines. The file is only ever
+// appended to, and synced after every record.
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink opens, or creates, the file at path.
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Write(ctx context.Context, r Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.file.Write(line); err != nil {
+		return microerror.Mask(err)
+	}
+	return microerror.Mask(s.file.Sync())
+}
+
+// Close closes the file.
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// drainTimeout bounds writing the buffered records when the manager stops.
+const drainTimeout = 10 * time.Second
+
+// WebhookSink posts every record as json to URL.
+type WebhookSink struct {
+	URL string
+	// Client defaults to a client with a 10 seconds timeout.
+	Client *http.Client
+}
+
+var defaultWebhookClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *WebhookSink) Write(ctx context.Context, r Record) error {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := s.Client
+	if client == nil {
+		client = defaultWebhookClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook [%s] answered [%s]", s.URL, resp.Status)
+	}
+	return nil
+}
+
+// BufferedSink writes records to Sink in the background, so a slow or
+// unreachable endpoint does not hold up the clean-up. A record is retried
+// with Backoff, one that still can't be written is logged and counted in
+// audit_records_failed_total. Write fails when the buffer is full.
+type BufferedSink struct {
+	Sink Sink
+	Log  logr.Logger
+	// Backoff spaces the attempts to write a record. Steps is the number of
+	// retries.
+	Backoff wait.Backoff
+
+	records chan Record
+}
+
+// NewBufferedSink returns a sink buffering size records for sink. It writes
+// them once started.
+func NewBufferedSink(sink Sink, size int, log logr.Logger) *BufferedSink {
+	return &BufferedSink{
+		Sink:    sink,
+		Log:     log,
+		Backoff: wait.Backoff{Duration: time.Second, Factor: 2, Steps: 5},
+		records: make(chan Record, size),
+	}
+}
+
+func (s *BufferedSink) Write(ctx context.Context, r Record) error {
+	select {
+	case s.records <- r:
+		return nil
+	default:
+		return microerror.Maskf(bufferFullError, "%d audit records are waiting to be written", cap(s.records))
+	}
+}
+
+// Start writes the buffered records until ctx is done, then tries the ones
+// left once more. It runs as a runnable of the manager.
+func (s *BufferedSink) Start(ctx context.Context) error {
+	for {
+		select {
+		case r := <-s.records:
+			if !s.write(ctx, r) {
+				s.drain(r)
+				return nil
+			}
+		case <-ctx.Done():
+			s.drain()
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection is false, records are written on every replica.
+func (s *BufferedSink) NeedLeaderElection() bool {
+	return false
+}
+
+// write writes r, retrying with Backoff. It returns false when ctx is done
+// before r was written or given up on.
+func (s *BufferedSink) write(ctx context.Context, r Record) bool {
+	backoff := s.Backoff
+	for {
+		err := s.Sink.Write(ctx, r)
+		switch {
+		case err == nil:
+			return true
+		case ctx.Err() != nil:
+			return false
+		case backoff.Steps < 1:
+			s.failed(r, err)
+			return true
+		}
+
+		select {
+		case <-time.After(backoff.Step()):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// drain writes the records left, and the ones in the buffer, without
+// retrying them.
+func (s *BufferedSink) drain(left ...Record) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	for {
+		var r Record
+		if len(left) > 0 {
+			r, left = left[0], left[1:]
+		} else {
+			select {
+			case r = <-s.records:
+			default:
+				return
+			}
+		}
+		if err := s.Sink.Write(ctx, r); err != nil {
+			s.failed(r, err)
+		}
+	}
+}
+
+// failed logs the record that could not be written, so it is not lost.
+func (s *BufferedSink) failed(r Record, err error) {
+	recordsFailed.WithLabelValues(r.ManagementCluster).Inc()
+	s.Log.Error(err, "Failed to write the audit record", "record", r)
+}
+
+// Sinks writes every record to each of its sinks. A failing sink does not
+// keep the record from the others.
+type Sinks []Sink
+
+func (s Sinks) Write(ctx context.Context, r Record) error {
+	var errs []error
+	for _, sink := range s {
+		if err := sink.Write(ctx, r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -18,62 +18,67 @@ package cleaner
 
 import (
 	"context"
-	"fmt"
-	"strings"
 
-	"github.com/vmware/go-vcloud-director/v2/types/v56"
-
-	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
-
-	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	"github.com/vmware/go-vcloud-director/v2/types/v56"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 )
 
 type AppPortProfileCleaner struct {
 	cli client.Client
+
+	gatewayObjectCleaner
 }
 
 func NewAppPortProfileCleaner(cli client.Client) *AppPortProfileCleaner {
-	return &AppPortProfileCleaner{cli: cli}
+	return &AppPortProfileCleaner{
+		cli: cli,
+		gatewayObjectCleaner: gatewayObjectCleaner{
+			name:        AppPortProfileCleanerName,
+			description: "app port profile",
+			// App port profiles belong to the org, not to a zone.
+			perOrg: true,
+			list:   listAppPortProfiles,
+			delete: func(ctx context.Context, gateway *vcdsdk.GatewayManager, o Object) error {
+				return gateway.DeleteAppPortProfile(o.Name, false)
+			},
+		},
+	}
 }
 
 // force implementing Cleaner interface
 var _ Cleaner = &AppPortProfileCleaner{}
+var _ Dependent = &AppPortProfileCleaner{}
+var _ Lister = &AppPortProfileCleaner{}
 
-func (lbc *AppPortProfileCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *capvcd.VCDCluster) (bool, error) {
-	log = log.WithName("AppPortProfileCleaner")
-	gateway, err := vcd.GetGateway(ctx, vcdClient, c)
-	if err != nil {
-		return false, err
-	}
+func (lbc *AppPortProfileCleaner) Name() string {
+	return AppPortProfileCleanerName
+}
+
+// DependsOn makes the pools and DNAT rules go first, as they may still use the
+// profiles.
+func (lbc *AppPortProfileCleaner) DependsOn() []string {
+	return []string{LBPoolCleanerName, DNATCleanerName}
+}
+
+// listAppPortProfiles lists every tenant app port profile of the org.
+func listAppPortProfiles(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, gateway *vcdsdk.GatewayManager) ([]Owned, error) {
 	org, err := vcdClient.VCDClient.GetOrgByName(c.Status.Org)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	aports, err := org.GetAllNsxtAppPortProfiles(nil, types.ApplicationPortProfileScopeTenant)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	infraId := c.Status.InfraId
-	deleted := 0
+	all := make([]Owned, 0, len(aports))
 	for _, aport := range aports {
 		aportName := aport.NsxtAppPortProfile.Name
-		// if the name of the app port profile contains the cluster's infraId, delete it
-		if strings.Contains(aportName, infraId) {
-			log.Info(fmt.Sprintf("deleting app port profile: %s", aportName))
-			err = gateway.DeleteAppPortProfile(aportName, false)
-			if err != nil {
-				return false, err
-			}
-			deleted++
-		}
-	}
-	if deleted > 0 {
-		log.Info(fmt.Sprintf("%d app port profiles were deleted", deleted))
+		all = append(all, Owned{Object: Object{Kind: KindAppPortProfile, ID: aport.NsxtAppPortProfile.ID, Name: aportName}, Owner: aportName})
 	}
 
-	return false, nil
+	return all, nil
 }
@@ -0,0 +1,79 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/giantswarm/microerror"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// What VolumeCleaner does with a disk when it is removed from its cluster.
+//
+// DiskBackupRetainRenamed does not take a backup, nothing is copied: the disk
+// itself is detached and renamed, and its description no longer holds the
+// infra id, so neither the cleaners nor the orphan sweeper match it again.
+// Deleting the renamed disk loses its data.
+const (
+	DiskBackupNone          = "none"
+	DiskBackupRetainRenamed = "retain-renamed"
+)
+
+// retainedDiskPrefix is put in front of the name of a disk retained renamed.
+const retainedDiskPrefix = "retained-"
+
+// ValidateDiskBackup checks mode is a known way to handle disks.
+func ValidateDiskBackup(mode string) error {
+	switch mode {
+	case DiskBackupNone, DiskBackupRetainRenamed:
+		return nil
+	}
+
+	return microerror.Maskf(invalidDiskBackupError, "disk backup [%s] is neither %q nor %q", mode, DiskBackupNone, DiskBackupRetainRenamed)
+}
+
+// diskBackup is what is done with the disks of c: the
+// key.DiskBackupAnnotation of c, or defaultMode without one.
+func diskBackup(c *vcdcluster.Cluster, defaultMode string) (string, error) {
+	mode, ok := c.GetAnnotations()[key.DiskBackupAnnotation]
+	if !ok {
+		mode = defaultMode
+	}
+	if mode == "" {
+		return DiskBackupNone, nil
+	}
+	if err := ValidateDiskBackup(mode); err != nil {
+		return "", err
+	}
+
+	return mode, nil
+}
+
+// retainedDescription is the description of a disk retained renamed. It names
+// the cluster the disk came from without its infra id.
+func retainedDescription(c *vcdcluster.Cluster, diskName string, now time.Time) string {
+	cluster := "a deleted cluster"
+	if c.GetName() != "" {
+		cluster = "cluster " + c.GetNamespace() + "/" + c.GetName()
+	}
+
+	return fmt.Sprintf("Disk %s of %s, retained %s", diskName, cluster, now.UTC().Format(time.RFC3339))
+}
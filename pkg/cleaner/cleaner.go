@@ -21,9 +21,54 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// Kinds of the VCD objects the cleaners delete.
+const (
+	KindDisk           = "disk"
+	KindDNATRule       = "dnatRule"
+	KindVirtualService = "virtualService"
+	KindLBPool         = "lbPool"
+	KindAppPortProfile = "appPortProfile"
 )
 
+// Names of the cleaners, as returned by their Name method.
+const (
+	VolumeCleanerName         = "VolumeCleaner"
+	VirtualServiceCleanerName = "VirtualServiceCleaner"
+	LBPoolCleanerName         = "LBPoolCleaner"
+	DNATCleanerName           = "DNATCleaner"
+	AppPortProfileCleanerName = "AppPortProfileCleaner"
+)
+
+// Object is a VCD object that belongs to the cluster being cleaned.
+type Object struct {
+	Kind string `json:"kind"`
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	// Zone is the zone of the cluster the object was found in. It is empty for
+	// a cluster with a single zone and for objects of the org.
+	Zone string `json:"zone,omitempty"`
+	// Gateway is the edge gateway a gateway object was found on.
+	Gateway string `json:"gateway,omitempty"`
+	// Action is set by Plan on the objects Clean does not delete: it is
+	// OperationRetainRenamed for a disk detached and renamed instead.
+	Action string `json:"action,omitempty"`
+}
+
+// Cleaner removes one kind of VCD object left behind by a deleted cluster.
+// Clean reports every object it deletes, or fails to delete, to the observers
+// in ctx.
 type Cleaner interface {
-	Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *capvcd.VCDCluster) (requeue bool, err error)
+	// Name identifies the cleaner in logs and in the status it leaves on the
+	// VCDCluster.
+	Name() string
+	// Plan lists the objects Clean would delete, or change otherwise as told
+	// by their Action. It does not change anything in VCD.
+	Plan(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) ([]Object, error)
+	// Clean asks to be requeued while VCD tasks it started, see WithTasks, are
+	// still running.
+	Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) (requeue bool, err error)
 }
@@ -0,0 +1,133 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Concurrency bounds how many objects the cleaners delete at the same time.
+// The cleaners share one Concurrency, so the limit of a gateway holds over
+// every cleaner and cluster deleting objects on it.
+type Concurrency struct {
+	// Workers is how many objects one cleaner deletes at the same time. It
+	// defaults to 1.
+	Workers int
+	// PerGateway is how many objects are deleted at the same time on one edge
+	// gateway. Zero means no limit but Workers.
+	PerGateway int
+
+	mu       sync.Mutex
+	gateways map[string]chan struct{}
+}
+
+func (c *Concurrency) workers() int {
+	if c == nil || c.Workers < 1 {
+		return 1
+	}
+	return c.Workers
+}
+
+// gateway returns the slots of the gateway, nil when it has no limit.
+func (c *Concurrency) gateway(gatewayId string) chan struct{} {
+	if c == nil || c.PerGateway < 1 || gatewayId == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.gateways == nil {
+		c.gateways = map[string]chan struct{}{}
+	}
+	slots, ok := c.gateways[gatewayId]
+	if !ok {
+		slots = make(chan struct{}, c.PerGateway)
+		c.gateways[gatewayId] = slots
+	}
+
+	return slots
+}
+
+// forEach calls fn for every item, up to c.Workers at a time and, on a
+// gateway, up to c.PerGateway at a time on it. An empty gatewayId is not
+// limited per gateway. A failing item does not stop the others, forEach
+// returns the errors of every item joined.
+func forEach[T any](ctx context.Context, c *Concurrency, gatewayId string, items []T, fn func(item T) error) error {
+	workers := make(chan struct{}, c.workers())
+	gateway := c.gateway(gatewayId)
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		errs = append(errs, err)
+	}
+
+	for _, item := range items {
+		if err := acquire(ctx, workers); err != nil {
+			fail(err)
+			break
+		}
+		if err := acquire(ctx, gateway); err != nil {
+			release(workers)
+			fail(err)
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer release(workers)
+			defer release(gateway)
+
+			if err := fn(item); err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// acquire takes a slot, a nil channel has as many as needed.
+func acquire(ctx context.Context, slots chan struct{}) error {
+	if slots == nil {
+		return nil
+	}
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func release(slots chan struct{}) {
+	if slots != nil {
+		<-slots
+	}
+}
@@ -19,45 +19,55 @@ package cleaner
 import (
 	"context"
 	"fmt"
-	"strings"
-
-	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
 
 	"github.com/antihax/optional"
 	"github.com/giantswarm/microerror"
-	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
 	swaggerClient "github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdswaggerclient"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 )
 
 type DNATCleaner struct {
 	cli client.Client
+
+	gatewayObjectCleaner
 }
 
 func NewDNATCleaner(cli client.Client) *DNATCleaner {
-	return &DNATCleaner{cli: cli}
+	return &DNATCleaner{
+		cli: cli,
+		gatewayObjectCleaner: gatewayObjectCleaner{
+			name:        DNATCleanerName,
+			description: "DNAT",
+			list:        listDNATRules,
+			delete: func(ctx context.Context, gateway *vcdsdk.GatewayManager, o Object) error {
+				return gateway.DeleteDNATRule(ctx, o.Name, false)
+			},
+		},
+	}
 }
 
 // force implementing Cleaner interface
 var _ Cleaner = &DNATCleaner{}
+var _ Lister = &DNATCleaner{}
 
-func (lbc *DNATCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *capvcd.VCDCluster) (bool, error) {
-	log = log.WithName("DNATCleaner")
-	gateway, err := vcd.GetGateway(ctx, vcdClient, c)
-	if err != nil {
-		return false, err
-	}
+func (lbc *DNATCleaner) Name() string {
+	return DNATCleanerName
+}
+
+// listDNATRules walks every page of the gateway nat rules.
+func listDNATRules(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, gateway *vcdsdk.GatewayManager) ([]Owned, error) {
 	org, err := vcdClient.VCDClient.GetOrgByName(vcdClient.ClusterOrgName)
 	if err != nil {
-		return false, microerror.Mask(err)
+		return nil, microerror.Mask(err)
 	}
 	if org == nil || org.Org == nil {
-		return false, microerror.Mask(fmt.Errorf("obtained nil org when getting org by name [%s]", vcdClient.ClusterOrgName))
+		return nil, microerror.Mask(fmt.Errorf("obtained nil org when getting org by name [%s]", vcdClient.ClusterOrgName))
 	}
-	var toDelete []string
-	infraId := c.Status.InfraId
+	var all []Owned
 	cursor := optional.EmptyString()
 
 	// in each iteration we will be fetching 128 nat rules
@@ -71,19 +81,15 @@ func (lbc *DNATCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *v
 				Cursor: cursor,
 			})
 		if err != nil {
-			return false, err
+			return nil, err
 		}
 
 		for _, enr := range edgeNatRules.Values {
-			enrName := enr.Name
-			// if the name of the DNAT rule contains the cluster's infraId, delete this item
-			if strings.Contains(enrName, infraId) {
-				toDelete = append(toDelete, enrName)
-			}
+			all = append(all, Owned{Object: Object{Kind: KindDNATRule, ID: enr.Id, Name: enr.Name}, Owner: enr.Name})
 		}
 		cursorStr, err := vcd.GetCursor(resp)
 		if err != nil {
-			return false, microerror.Mask(fmt.Errorf("error while parsing response [%+v]: [%v]", resp, err))
+			return nil, microerror.Mask(fmt.Errorf("error while parsing response [%+v]: [%v]", resp, err))
 		}
 		if cursorStr == "" {
 			break
@@ -91,17 +97,5 @@ func (lbc *DNATCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *v
 		cursor = optional.NewString(cursorStr)
 	}
 
-	// do the actual deletion, it needs to be done in a separate step, otherwise the paging&cursor is not behaving correctly
-	if len(toDelete) > 0 {
-		for _, enr := range toDelete {
-			log.Info(fmt.Sprintf("deleting DNAT: %s", enr))
-			err = gateway.DeleteDNATRule(ctx, enr, false)
-			if err != nil {
-				return false, err
-			}
-		}
-		log.Info(fmt.Sprintf("%d DNATs were deleted", len(toDelete)))
-	}
-
-	return false, nil
+	return all, nil
 }
@@ -0,0 +1,50 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var invalidDependencyError = &microerror.Error{
+	Kind: "invalidDependencyError",
+}
+
+// IsInvalidDependency asserts invalidDependencyError.
+func IsInvalidDependency(err error) bool {
+	return errors.Is(err, invalidDependencyError)
+}
+
+var invalidRetainPatternError = &microerror.Error{
+	Kind: "invalidRetainPatternError",
+}
+
+// IsInvalidRetainPattern asserts invalidRetainPatternError.
+func IsInvalidRetainPattern(err error) bool {
+	return errors.Is(err, invalidRetainPatternError)
+}
+
+var invalidDiskBackupError = &microerror.Error{
+	Kind: "invalidDiskBackupError",
+}
+
+// IsInvalidDiskBackup asserts invalidDiskBackupError.
+func IsInvalidDiskBackup(err error) bool {
+	return errors.Is(err, invalidDiskBackupError)
+}
@@ -0,0 +1,163 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// gatewayObjectCleaner deletes the objects of one kind a cluster left on its
+// edge gateways. The cleaners of nat rules, virtual services, load balancer
+// pools and app port profiles embed it, and only tell how their objects are
+// listed and deleted.
+type gatewayObjectCleaner struct {
+	// Concurrency bounds how many objects are deleted at the same time. Nil
+	// deletes them one by one.
+	Concurrency *Concurrency
+
+	// name is the name of the cleaner, description names an object in the
+	// logs, e.g. "virtual service".
+	name        string
+	description string
+	// perOrg is set for objects that belong to the org rather than to a
+	// gateway. They are listed once, and deleted through the gateway of the
+	// default zone.
+	perOrg bool
+	// list lists every object of the kind on the gateway, or in the org when
+	// perOrg is set and gateway is nil. The name of an object holds the
+	// infraId of its cluster.
+	list func(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, gateway *vcdsdk.GatewayManager) ([]Owned, error)
+	// delete deletes one object through the gateway.
+	delete func(ctx context.Context, gateway *vcdsdk.GatewayManager, o Object) error
+}
+
+func (goc *gatewayObjectCleaner) Plan(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) ([]Object, error) {
+	if goc.perOrg {
+		objects, err := goc.find(ctx, vcdClient, c, nil)
+		if err != nil {
+			return nil, err
+		}
+		toDelete, _, err := withoutRetained(c, objects)
+
+		return toDelete, err
+	}
+
+	var toDelete []Object
+	err := forEachGateway(ctx, vcdClient, c, func(zoneClient *vcdsdk.Client, zone vcdcluster.Zone, gateway *vcdsdk.GatewayManager) error {
+		objects, err := goc.find(ctx, zoneClient, c, gateway)
+		if err != nil {
+			return err
+		}
+		objects, _, err = withoutRetained(c, onGateway(objects, zone, gateway))
+		toDelete = append(toDelete, objects...)
+		return err
+	})
+
+	return toDelete, err
+}
+
+func (goc *gatewayObjectCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) (bool, error) {
+	log = log.WithName(goc.name)
+	if goc.perOrg {
+		// Any gateway of the org deletes the objects.
+		gateway, err := vcd.GetGateway(ctx, vcdClient, c.DefaultZone())
+		if err != nil {
+			return false, err
+		}
+		objects, err := goc.find(ctx, vcdClient, c, nil)
+		if err != nil {
+			return false, err
+		}
+
+		return false, goc.clean(ctx, log, c, gateway, objects)
+	}
+
+	err := forEachGateway(ctx, vcdClient, c, func(zoneClient *vcdsdk.Client, zone vcdcluster.Zone, gateway *vcdsdk.GatewayManager) error {
+		objects, err := goc.find(ctx, zoneClient, c, gateway)
+		if err != nil {
+			return err
+		}
+
+		return goc.clean(ctx, log, c, gateway, onGateway(objects, zone, gateway))
+	})
+
+	return false, err
+}
+
+// clean deletes the objects of the cluster through the gateway, but the ones
+// it retains. The objects are deleted once they are all listed, deleting
+// while paging through them upsets the cursor.
+func (goc *gatewayObjectCleaner) clean(ctx context.Context, log logr.Logger, c *vcdcluster.Cluster, gateway *vcdsdk.GatewayManager, objects []Object) error {
+	toDelete, err := retain(ctx, log, c, objects)
+	if err != nil {
+		return err
+	}
+
+	err = forEach(ctx, goc.Concurrency, gateway.GatewayRef.Id, toDelete, func(o Object) error {
+		log.Info(fmt.Sprintf("deleting %s: %s", goc.description, o.Name))
+		if err := ReportIntent(ctx, o, OperationDelete); err != nil {
+			ReportFailed(ctx, o, err)
+			return err
+		}
+		if err := goc.delete(ctx, gateway, o); err != nil {
+			ReportFailed(ctx, o, err)
+			return err
+		}
+		ReportDeleted(ctx, o)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(toDelete) > 0 {
+		log.Info(fmt.Sprintf("%d %ss were deleted", len(toDelete), goc.description))
+	}
+
+	return nil
+}
+
+// find lists the objects of the cluster on the gateway, or in the org.
+func (goc *gatewayObjectCleaner) find(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, gateway *vcdsdk.GatewayManager) ([]Object, error) {
+	all, err := goc.list(ctx, vcdClient, c, gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	return ownedBy(all, c)
+}
+
+func (goc *gatewayObjectCleaner) List(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) ([]Owned, error) {
+	if goc.perOrg {
+		return goc.list(ctx, vcdClient, c, nil)
+	}
+
+	var all []Owned
+	err := forEachGateway(ctx, vcdClient, c, func(zoneClient *vcdsdk.Client, zone vcdcluster.Zone, gateway *vcdsdk.GatewayManager) error {
+		owned, err := goc.list(ctx, zoneClient, c, gateway)
+		all = append(all, ownedOnGateway(owned, zone, gateway)...)
+		return err
+	})
+
+	return all, err
+}
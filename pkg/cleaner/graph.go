@@ -0,0 +1,117 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"github.com/giantswarm/microerror"
+)
+
+// Dependent is implemented by cleaners that may only run once other cleaners
+// are done. DependsOn returns the names of those cleaners.
+type Dependent interface {
+	DependsOn() []string
+}
+
+// Dependencies returns, for every cleaner, the indexes of the cleaners it
+// depends on. It fails when a cleaner depends on one that is not in the list,
+// or when the dependencies form a cycle.
+func Dependencies(cleaners []Cleaner) ([][]int, error) {
+	index := map[string]int{}
+	for i, c := range cleaners {
+		if _, ok := index[c.Name()]; ok {
+			return nil, microerror.Maskf(invalidDependencyError, "cleaner [%s] is listed twice", c.Name())
+		}
+		index[c.Name()] = i
+	}
+
+	deps := make([][]int, len(cleaners))
+	for i, c := range cleaners {
+		dependent, ok := c.(Dependent)
+		if !ok {
+			continue
+		}
+		for _, name := range dependent.DependsOn() {
+			j, ok := index[name]
+			if !ok {
+				return nil, microerror.Maskf(invalidDependencyError, "cleaner [%s] depends on unknown cleaner [%s]", c.Name(), name)
+			}
+			deps[i] = append(deps[i], j)
+		}
+	}
+
+	// Depth first search, a cleaner met again while it is on the stack closes a cycle.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(cleaners))
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visiting:
+			return microerror.Maskf(invalidDependencyError, "the dependencies of cleaner [%s] form a cycle", cleaners[i].Name())
+		case visited:
+			return nil
+		}
+		state[i] = visiting
+		for _, j := range deps[i] {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		state[i] = visited
+		return nil
+	}
+	for i := range cleaners {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return deps, nil
+}
+
+// Sort orders the cleaners so every cleaner comes after the ones it depends
+// on. Cleaners keep their relative order otherwise.
+func Sort(cleaners []Cleaner) ([]Cleaner, error) {
+	deps, err := Dependencies(cleaners)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	sorted := make([]Cleaner, 0, len(cleaners))
+	added := make([]bool, len(cleaners))
+	for len(sorted) < len(cleaners) {
+		for i, c := range cleaners {
+			if added[i] {
+				continue
+			}
+			ready := true
+			for _, j := range deps[i] {
+				ready = ready && added[j]
+			}
+			if ready {
+				added[i] = true
+				sorted = append(sorted, c)
+				break
+			}
+		}
+	}
+
+	return sorted, nil
+}
@@ -18,55 +18,60 @@ package cleaner
 
 import (
 	"context"
-	"fmt"
-	"strings"
 
-	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
-
-	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 )
 
 type LBPoolCleaner struct {
 	cli client.Client
+
+	gatewayObjectCleaner
 }
 
 func NewLBPoolCleaner(cli client.Client) *LBPoolCleaner {
-	return &LBPoolCleaner{cli: cli}
+	return &LBPoolCleaner{
+		cli: cli,
+		gatewayObjectCleaner: gatewayObjectCleaner{
+			name:        LBPoolCleanerName,
+			description: "load balancer pool",
+			list:        listLBPools,
+			delete: func(ctx context.Context, gateway *vcdsdk.GatewayManager, o Object) error {
+				return gateway.DeleteLoadBalancerPool(ctx, o.Name, false)
+			},
+		},
+	}
 }
 
 // force implementing Cleaner interface
 var _ Cleaner = &LBPoolCleaner{}
+var _ Lister = &LBPoolCleaner{}
+var _ Dependent = &LBPoolCleaner{}
 
-func (lbc *LBPoolCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *capvcd.VCDCluster) (bool, error) {
-	log = log.WithName("LBPoolCleaner")
-	gateway, err := vcd.GetGateway(ctx, vcdClient, c)
-	if err != nil {
-		return false, err
-	}
+func (lbc *LBPoolCleaner) Name() string {
+	return LBPoolCleanerName
+}
+
+// DependsOn makes the virtual services go first, VCD refuses to delete a pool
+// a virtual service still points at.
+func (lbc *LBPoolCleaner) DependsOn() []string {
+	return []string{VirtualServiceCleanerName}
+}
+
+// listLBPools lists every load balancer pool on the gateway.
+func listLBPools(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, gateway *vcdsdk.GatewayManager) ([]Owned, error) {
 	lbps, err := vcdClient.VCDClient.GetAllAlbPools(gateway.GatewayRef.Id, nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	infraId := c.Status.InfraId
-	deleted := 0
+
+	all := make([]Owned, 0, len(lbps))
 	for _, lbp := range lbps {
 		lbName := lbp.NsxtAlbPool.Name
-		// if the name of the load balancer pool contains the cluster's infraId, delete this lb pool
-		if strings.Contains(lbName, infraId) {
-			log.Info(fmt.Sprintf("deleting load balancer pool: %s", lbName))
-			err = gateway.DeleteLoadBalancerPool(ctx, lbName, false)
-			if err != nil {
-				return false, err
-			}
-			deleted++
-		}
-	}
-	if deleted > 0 {
-		log.Info(fmt.Sprintf("%d load balancer pool were deleted", deleted))
+		all = append(all, Owned{Object: Object{Kind: KindLBPool, ID: lbp.NsxtAlbPool.ID, Name: lbName}, Owner: lbName})
 	}
 
-	return false, nil
+	return all, nil
 }
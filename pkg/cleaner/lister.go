@@ -0,0 +1,57 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"context"
+
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// Owned is an object listed together with the text that ties it to its
+// cluster: the name of gateway objects, the description of disks.
+type Owned struct {
+	Object
+	Owner string
+}
+
+// Lister is implemented by cleaners that can list every object of their kind
+// at the site and org of c, in the vdc and network of every zone of c,
+// whatever cluster it belongs to.
+type Lister interface {
+	List(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) ([]Owned, error)
+}
+
+// ownedBy keeps the objects of cluster c. It refuses to match anything when
+// the infra id of c is not a valid one.
+func ownedBy(all []Owned, c *vcdcluster.Cluster) ([]Object, error) {
+	if err := vcd.ValidateInfraId(c.Status.InfraId); err != nil {
+		return nil, err
+	}
+
+	var found []Object
+	for _, o := range all {
+		if namedAfter(o, c.GetName(), c.Status.InfraId) {
+			found = append(found, o.Object)
+		}
+	}
+
+	return found, nil
+}
@@ -0,0 +1,100 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"strings"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+)
+
+// Prefixes of the names the cloud provider and CAPVCD give to gateway objects.
+//
+// CAPVCD names the control plane load balancer objects after the cluster:
+// "<cluster name>-<infra id>-tcp", with the DNAT rule "dnat-<virtual service>"
+// and the application port profile "appPort_<DNAT rule>".
+// The cloud provider names the objects of a LoadBalancer service
+// "ingress-vs-<service>-<trimmed infra id>-<port>" and
+// "ingress-pool-<service>-<trimmed infra id>-<port>", with the same DNAT rule
+// and application port profile names.
+const (
+	dnatRulePrefix       = "dnat-"
+	appPortProfilePrefix = "appPort_"
+	ingressVSPrefix      = "ingress-vs-"
+	ingressPoolPrefix    = "ingress-pool-"
+)
+
+// namedAfter tells whether o was named after the cluster with the given name
+// and infraId by CAPVCD or the cloud provider. An empty clusterName matches
+// any cluster name, for when only the infra id is known.
+func namedAfter(o Owned, clusterName, infraId string) bool {
+	name := o.Owner
+	switch o.Kind {
+	case KindDisk:
+		return name == infraId
+	case KindAppPortProfile:
+		if !strings.HasPrefix(name, appPortProfilePrefix) {
+			return false
+		}
+		name = strings.TrimPrefix(name, appPortProfilePrefix)
+		fallthrough
+	case KindDNATRule:
+		if !strings.HasPrefix(name, dnatRulePrefix) {
+			return false
+		}
+		name = strings.TrimPrefix(name, dnatRulePrefix)
+	}
+
+	return capvcdName(name, clusterName, infraId) || cloudProviderName(name, infraId)
+}
+
+// capvcdName matches "<cluster name>-<infra id>" with an optional suffix.
+func capvcdName(name, clusterName, infraId string) bool {
+	if clusterName == "" {
+		return hasToken(name, infraId)
+	}
+
+	rest, ok := strings.CutPrefix(name, clusterName+"-"+infraId)
+	return ok && (rest == "" || strings.HasPrefix(rest, "-"))
+}
+
+// cloudProviderName matches "ingress-vs-" and "ingress-pool-" names that hold
+// the trimmed infra id.
+func cloudProviderName(name, infraId string) bool {
+	if !strings.HasPrefix(name, ingressVSPrefix) && !strings.HasPrefix(name, ingressPoolPrefix) {
+		return false
+	}
+
+	return hasToken(name, vcd.TrimInfraId(infraId))
+}
+
+// hasToken tells whether token appears in name between dashes, or at either
+// end of it, so that it is not part of a longer id.
+func hasToken(name, token string) bool {
+	for offset := 0; ; {
+		i := strings.Index(name[offset:], token)
+		if i < 0 {
+			return false
+		}
+		start := offset + i
+		end := start + len(token)
+		if (start == 0 || name[start-1] == '-') && (end == len(name) || name[end] == '-') {
+			return true
+		}
+		offset = start + 1
+	}
+}
@@ -0,0 +1,136 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"context"
+	"errors"
+)
+
+// Observer is told about every VCD object a cleaner deletes or fails to delete.
+// A cleaner deleting objects concurrently calls it from several goroutines.
+type Observer interface {
+	ObjectDeleted(ctx context.Context, o Object)
+	ObjectFailed(ctx context.Context, o Object, err error)
+}
+
+// DetachObserver is implemented by observers that also want to hear about
+// disks released from a vm before they are deleted.
+type DetachObserver interface {
+	ObjectDetached(ctx context.Context, o Object, vmName string)
+}
+
+// RetainObserver is implemented by observers that also want to hear about
+// objects a cleaner left alone because the VCDCluster asks to retain them.
+type RetainObserver interface {
+	ObjectRetained(ctx context.Context, o Object)
+}
+
+// RetainRenamedObserver is implemented by observers that also want to hear
+// about disks detached and renamed instead of deleted. renamed references the
+// disk under its new name.
+type RetainRenamedObserver interface {
+	ObjectRetainedRenamed(ctx context.Context, o Object, renamed string)
+}
+
+// IntentObserver is implemented by observers that also want to hear about a
+// destructive call before it is made, operation being one of the task
+// operations. The call is not made when one of them fails.
+type IntentObserver interface {
+	ObjectIntended(ctx context.Context, o Object, operation string) error
+}
+
+type observersKey struct{}
+
+// WithObserver returns a context in which the cleaners report to o, on top of
+// the observers ctx already carries.
+func WithObserver(ctx context.Context, o Observer) context.Context {
+	existing, _ := ctx.Value(observersKey{}).([]Observer)
+
+	observers := make([]Observer, 0, len(existing)+1)
+	observers = append(observers, existing...)
+	observers = append(observers, o)
+
+	return context.WithValue(ctx, observersKey{}, observers)
+}
+
+// ReportIntent tells the observers in ctx that implement IntentObserver that
+// operation is about to be done to o. The caller does not go on when it
+// returns an error.
+func ReportIntent(ctx context.Context, o Object, operation string) error {
+	observers, _ := ctx.Value(observersKey{}).([]Observer)
+
+	var errs []error
+	for _, observer := range observers {
+		if i, ok := observer.(IntentObserver); ok {
+			if err := i.ObjectIntended(ctx, o, operation); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// ReportDeleted tells the observers in ctx that o was deleted.
+func ReportDeleted(ctx context.Context, o Object) {
+	observers, _ := ctx.Value(observersKey{}).([]Observer)
+	for _, observer := range observers {
+		observer.ObjectDeleted(ctx, o)
+	}
+}
+
+// ReportFailed tells the observers in ctx that deleting o failed.
+func ReportFailed(ctx context.Context, o Object, err error) {
+	observers, _ := ctx.Value(observersKey{}).([]Observer)
+	for _, observer := range observers {
+		observer.ObjectFailed(ctx, o, err)
+	}
+}
+
+// ReportDetached tells the observers in ctx that implement DetachObserver that
+// o was detached from a vm.
+func ReportDetached(ctx context.Context, o Object, vmName string) {
+	observers, _ := ctx.Value(observersKey{}).([]Observer)
+	for _, observer := range observers {
+		if d, ok := observer.(DetachObserver); ok {
+			d.ObjectDetached(ctx, o, vmName)
+		}
+	}
+}
+
+// ReportRetained tells the observers in ctx that implement RetainObserver that
+// o was retained.
+func ReportRetained(ctx context.Context, o Object) {
+	observers, _ := ctx.Value(observersKey{}).([]Observer)
+	for _, observer := range observers {
+		if r, ok := observer.(RetainObserver); ok {
+			r.ObjectRetained(ctx, o)
+		}
+	}
+}
+
+// ReportRetainedRenamed tells the observers in ctx that implement
+// RetainRenamedObserver that o was retained, renamed to renamed.
+func ReportRetainedRenamed(ctx context.Context, o Object, renamed string) {
+	observers, _ := ctx.Value(observersKey{}).([]Observer)
+	for _, observer := range observers {
+		if b, ok := observer.(RetainRenamedObserver); ok {
+			b.ObjectRetainedRenamed(ctx, o, renamed)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// retainAll is the key.RetainAnnotation value that retains every object of a
+// kind.
+const retainAll = "true"
+
+// Retained tells whether c asks to keep o. An annotation holding a malformed
+// pattern is an error rather than no match, so that a typo does not get the
+// object deleted.
+func Retained(c *vcdcluster.Cluster, o Object) (bool, error) {
+	value, ok := c.GetAnnotations()[key.RetainAnnotation(o.Kind)]
+	if !ok {
+		return false, nil
+	}
+	if strings.TrimSpace(value) == retainAll {
+		return true, nil
+	}
+
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matched, err := path.Match(pattern, o.Name)
+		if err != nil {
+			return false, microerror.Maskf(invalidRetainPatternError, "annotation [%s] holds the malformed pattern [%s]", key.RetainAnnotation(o.Kind), pattern)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// withoutRetained drops the objects c asks to keep.
+func withoutRetained(c *vcdcluster.Cluster, objects []Object) ([]Object, []Object, error) {
+	var toDelete, retained []Object
+	for _, o := range objects {
+		keep, err := Retained(c, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		if keep {
+			retained = append(retained, o)
+			continue
+		}
+		toDelete = append(toDelete, o)
+	}
+
+	return toDelete, retained, nil
+}
+
+// retain drops the objects c asks to keep, and reports them to the observers
+// in ctx so they are tracked rather than forgotten.
+func retain(ctx context.Context, log logr.Logger, c *vcdcluster.Cluster, objects []Object) ([]Object, error) {
+	toDelete, retained, err := withoutRetained(c, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range retained {
+		log.Info(fmt.Sprintf("retaining %s: %s", o.Kind, o.Name))
+		ReportRetained(ctx, o)
+	}
+
+	return toDelete, nil
+}
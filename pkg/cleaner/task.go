@@ -0,0 +1,112 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"context"
+	"sync"
+)
+
+// Operations of the VCD tasks a cleaner waits for.
+const (
+	OperationDetach        = "detach"
+	OperationRetainRenamed = "retain-renamed"
+	OperationDelete        = "delete"
+	// OperationRetain is no VCD task. It records an object the cluster
+	// retains, so that it is reported on the first run only.
+	OperationRetain = "retain"
+)
+
+// Task is a VCD task a cleaner started and did not see finish yet, or an
+// object it retains. The latter has no HREF.
+type Task struct {
+	HREF      string `json:"href"`
+	Object    Object `json:"object"`
+	Operation string `json:"operation"`
+	// Detail is the vm a disk is detached from, or the reference of a disk
+	// retained under a new name.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Tasks holds the VCD tasks of one cleaner between two runs.
+type Tasks struct {
+	mu    sync.Mutex
+	tasks []Task
+}
+
+// NewTasks returns the holder of the tasks an earlier run left pending.
+func NewTasks(tasks []Task) *Tasks {
+	return &Tasks{tasks: tasks}
+}
+
+// List returns the tasks still pending.
+func (t *Tasks) List() []Task {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Task(nil), t.tasks...)
+}
+
+// Retained counts the objects the tasks record as retained. They were
+// reported on an earlier run.
+func (t *Tasks) Retained() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	retained := 0
+	for _, task := range t.tasks {
+		if task.Operation == OperationRetain {
+			retained++
+		}
+	}
+
+	return retained
+}
+
+func (t *Tasks) set(tasks []Task) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.tasks = tasks
+}
+
+type tasksKey struct{}
+
+// WithTasks returns a context in which the cleaners start VCD tasks without
+// waiting for them. The tasks are kept in t, the cleaner asks to be requeued
+// and polls them on its next run. Without it the cleaners wait for every task
+// they start.
+func WithTasks(ctx context.Context, t *Tasks) context.Context {
+	return context.WithValue(ctx, tasksKey{}, t)
+}
+
+func tasksFrom(ctx context.Context) *Tasks {
+	t, _ := ctx.Value(tasksKey{}).(*Tasks)
+	return t
+}
+
+// reportTask tells the observers in ctx about a task that succeeded.
+func reportTask(ctx context.Context, task Task) {
+	switch task.Operation {
+	case OperationDetach:
+		ReportDetached(ctx, task.Object, task.Detail)
+	case OperationRetainRenamed:
+		ReportRetainedRenamed(ctx, task.Object, task.Detail)
+	case OperationDelete:
+		ReportDeleted(ctx, task.Object)
+	}
+}
@@ -18,55 +18,53 @@ package cleaner
 
 import (
 	"context"
-	"fmt"
-	"strings"
 
-	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
-
-	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 )
 
 type VirtualServiceCleaner struct {
 	cli client.Client
+
+	gatewayObjectCleaner
 }
 
 func NewVirtualServiceCleaner(cli client.Client) *VirtualServiceCleaner {
-	return &VirtualServiceCleaner{cli: cli}
+	return &VirtualServiceCleaner{
+		cli: cli,
+		gatewayObjectCleaner: gatewayObjectCleaner{
+			name:        VirtualServiceCleanerName,
+			description: "virtual service",
+			list:        listVirtualServices,
+			delete: func(ctx context.Context, gateway *vcdsdk.GatewayManager, o Object) error {
+				return gateway.DeleteVirtualService(ctx, o.Name, false)
+			},
+		},
+	}
 }
 
 // force implementing Cleaner interface
 var _ Cleaner = &VirtualServiceCleaner{}
+var _ Lister = &VirtualServiceCleaner{}
 
-func (lbc *VirtualServiceCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *capvcd.VCDCluster) (bool, error) {
-	log = log.WithName("VirtualServiceCleaner")
-	gateway, err := vcd.GetGateway(ctx, vcdClient, c)
-	if err != nil {
-		return false, err
-	}
+func (lbc *VirtualServiceCleaner) Name() string {
+	return VirtualServiceCleanerName
+}
+
+// listVirtualServices lists every virtual service on the gateway.
+func listVirtualServices(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, gateway *vcdsdk.GatewayManager) ([]Owned, error) {
 	vSvcs, err := vcdClient.VCDClient.GetAllAlbVirtualServices(gateway.GatewayRef.Id, nil)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	infraId := c.Status.InfraId
-	deleted := 0
+
+	all := make([]Owned, 0, len(vSvcs))
 	for _, vSvc := range vSvcs {
 		svcName := vSvc.NsxtAlbVirtualService.Name
-		// if the name of the virtual service contains the cluster's infraId, delete this virtual service
-		if strings.Contains(svcName, infraId) {
-			log.Info(fmt.Sprintf("deleting virtual service: %s", svcName))
-			err = gateway.DeleteVirtualService(ctx, svcName, false)
-			if err != nil {
-				return false, err
-			}
-			deleted++
-		}
-	}
-	if deleted > 0 {
-		log.Info(fmt.Sprintf("%d virtual services were deleted", deleted))
+		all = append(all, Owned{Object: Object{Kind: KindVirtualService, ID: vSvc.NsxtAlbVirtualService.ID, Name: svcName}, Owner: svcName})
 	}
 
-	return false, nil
+	return all, nil
 }
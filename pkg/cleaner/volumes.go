@@ -19,17 +19,29 @@ package cleaner
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	"github.com/vmware/go-vcloud-director/v2/types/v56"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 )
 
 type VolumeCleaner struct {
 	cli client.Client
+
+	// DiskBackup is what is done with disks instead of deleting them, unless
+	// the VCDCluster says otherwise in key.DiskBackupAnnotation. Empty means
+	// DiskBackupNone.
+	DiskBackup string
+
+	// Concurrency bounds how many disks are deleted, or kept, at the same
+	// time. Nil handles them one by one.
+	Concurrency *Concurrency
 }
 
 func NewVolumeCleaner(cli client.Client) *VolumeCleaner {
@@ -38,35 +50,461 @@ func NewVolumeCleaner(cli client.Client) *VolumeCleaner {
 
 // force implementing Cleaner interface
 var _ Cleaner = &VolumeCleaner{}
+var _ Lister = &VolumeCleaner{}
+
+func (vc *VolumeCleaner) Name() string {
+	return VolumeCleanerName
+}
 
-func (vc *VolumeCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, cluster *capvcd.VCDCluster) (bool, error) {
-	log = log.WithName("VolumeCleaner")
+func (vc *VolumeCleaner) Plan(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, cluster *vcdcluster.Cluster) ([]Object, error) {
+	if err := vcd.ValidateInfraId(cluster.Status.InfraId); err != nil {
+		return nil, err
+	}
 
 	diskRecords, err := vcd.GetDiskRecordsOfClusterByDescription(vcdClient, cluster.Status.InfraId)
 	if err != nil {
-		return false, fmt.Errorf("failed to get disk records of cluster:[%s] [%v]", cluster.Status.InfraId, err)
+		return nil, fmt.Errorf("failed to get disk records of cluster:[%s] [%v]", cluster.Status.InfraId, err)
+	}
+
+	backup, err := diskBackup(cluster, vc.DiskBackup)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := newDiskZones(vcdClient, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]Object, 0, len(diskRecords))
+	for _, diskRecord := range diskRecords {
+		objects = append(objects, zones.object(diskRecord))
+	}
+	toDelete, _, err := withoutRetained(cluster, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	// Disks retained renamed are detached and renamed instead of deleted.
+	if backup == DiskBackupRetainRenamed {
+		for i := range toDelete {
+			toDelete[i].Action = OperationRetainRenamed
+		}
+	}
+
+	return toDelete, nil
+}
+
+// List lists every named disk of the org. The description of a disk holds the
+// infraId of its cluster.
+func (vc *VolumeCleaner) List(ctx context.Context, vcdClient *vcdsdk.Client, cluster *vcdcluster.Cluster) ([]Owned, error) {
+	diskRecords, err := vcd.GetAllDiskRecords(vcdClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk records: [%v]", err)
 	}
 
-	log.Info(fmt.Sprintf("%d disks will be deleted", len(diskRecords)))
+	zones, err := newDiskZones(vcdClient, cluster)
+	if err != nil {
+		return nil, err
+	}
 
+	all := make([]Owned, 0, len(diskRecords))
 	for _, diskRecord := range diskRecords {
+		all = append(all, Owned{Object: zones.object(diskRecord), Owner: diskRecord.Description})
+	}
+
+	return all, nil
+}
+
+func (vc *VolumeCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, cluster *vcdcluster.Cluster) (bool, error) {
+	log = log.WithName(vc.Name())
+
+	if err := vcd.ValidateInfraId(cluster.Status.InfraId); err != nil {
+		return false, err
+	}
+
+	backup, err := diskBackup(cluster, vc.DiskBackup)
+	if err != nil {
+		return false, err
+	}
+
+	zones, err := newDiskZones(vcdClient, cluster)
+	if err != nil {
+		return false, err
+	}
+
+	if tasks := tasksFrom(ctx); tasks != nil {
+		return vc.cleanAsync(ctx, log, vcdClient, zones, cluster, backup, tasks)
+	}
+
+	toDelete, retained, err := vc.disksToDelete(ctx, vcdClient, zones, cluster)
+	if err != nil {
+		return false, err
+	}
+	for _, o := range retained {
+		log.Info(fmt.Sprintf("Disk [%s] will be retained", o.Name))
+		ReportRetained(ctx, o)
+	}
+
+	if backup == DiskBackupRetainRenamed {
+		log.Info(fmt.Sprintf("%d disks will be retained renamed", len(toDelete)))
+
+		err = forEach(ctx, vc.Concurrency, "", toDelete, func(diskRecord *types.DiskRecordType) error {
+			reference, err := vc.keepDisk(ctx, zones, cluster, diskRecord, log)
+			if err != nil {
+				ReportFailed(ctx, zones.object(diskRecord), err)
+				return err
+			}
+			ReportRetainedRenamed(ctx, zones.object(diskRecord), reference)
+			return nil
+		})
+
+		return false, err
+	}
+
+	log.Info(fmt.Sprintf("%d disks will be deleted", len(toDelete)))
+
+	err = forEach(ctx, vc.Concurrency, "", toDelete, func(diskRecord *types.DiskRecordType) error {
 		log.Info(fmt.Sprintf("Disk [%s] will be deleted", diskRecord.Name))
 
-		disk, err := vcd.GetDiskByHref(vcdClient, diskRecord.HREF)
+		err := vc.deleteDisk(ctx, zones, cluster, diskRecord, log)
 		if err != nil {
-			return false, fmt.Errorf("failed to get disk:[%s] [%v]", diskRecord.Name, err)
+			ReportFailed(ctx, zones.object(diskRecord), err)
+			return err
 		}
+		ReportDeleted(ctx, zones.object(diskRecord))
+		return nil
+	})
 
-		err = vcd.DetachFromAllVms(vcdClient, cluster.Name, disk, log)
+	return false, err
+}
+
+// disksToDelete lists the disks of the cluster, without the ones it retains,
+// which it returns apart.
+func (vc *VolumeCleaner) disksToDelete(ctx context.Context, vcdClient *vcdsdk.Client, zones *diskZones, cluster *vcdcluster.Cluster) ([]*types.DiskRecordType, []Object, error) {
+	diskRecords, err := vcd.GetDiskRecordsOfClusterByDescription(vcdClient, cluster.Status.InfraId)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get disk records of cluster:[%s] [%v]", cluster.Status.InfraId, err)
+	}
+
+	toDelete := make([]*types.DiskRecordType, 0, len(diskRecords))
+	var retained []Object
+	for _, diskRecord := range diskRecords {
+		ok, err := Retained(cluster, zones.object(diskRecord))
 		if err != nil {
-			return false, fmt.Errorf("failed to detach VMs from disk:[%s] [%v]", diskRecord.Name, err)
+			return nil, nil, err
+		}
+		if ok {
+			retained = append(retained, zones.object(diskRecord))
+			continue
+		}
+		toDelete = append(toDelete, diskRecord)
+	}
+
+	return toDelete, retained, nil
+}
+
+// cleanAsync moves every disk one step further without waiting for VCD. It
+// polls the tasks the previous run started, then starts the next task of every
+// disk that has none running: a disk is first detached from its vms, then
+// retained renamed or deleted. It asks to be requeued while any task runs.
+// A retained disk is reported on the first run that finds it, and kept in the
+// tasks so the runs after it do not report it again.
+func (vc *VolumeCleaner) cleanAsync(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, zones *diskZones, cluster *vcdcluster.Cluster, backup string, tasks *Tasks) (bool, error) {
+	pending := []Task{}
+	busy := map[string]bool{}
+	var reported []Task
+	var firstErr error
+	for _, task := range tasks.List() {
+		if task.Operation == OperationRetain {
+			reported = append(reported, task)
+			continue
 		}
 
-		err = vcd.DeleteDisk(vcdClient, disk)
+		finished, err := vcd.PollTask(vcdClient, task.HREF)
+		switch {
+		case vcd.IsTaskFailed(err):
+			// The next run starts the task over.
+			err = fmt.Errorf("failed to %s disk:[%s] [%v]", task.Operation, task.Object.Name, err)
+			ReportFailed(ctx, task.Object, err)
+		case vcd.IsTaskGone(err):
+			// VCD purges old tasks. What comes next is told by the disk
+			// itself below.
+			log.Info(fmt.Sprintf("The %s task of disk [%s] is gone, dropping it", task.Operation, task.Object.Name), "task", task.HREF)
+			err = nil
+		case err != nil:
+			pending = append(pending, task)
+			busy[task.Object.ID] = true
+		case !finished:
+			log.V(1).Info(fmt.Sprintf("Waiting for the %s task of disk [%s]", task.Operation, task.Object.Name))
+			pending = append(pending, task)
+			busy[task.Object.ID] = true
+		default:
+			reportTask(ctx, task)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	tasks.set(append(pending, reported...))
+	if firstErr != nil {
+		return false, firstErr
+	}
+
+	toDelete, retained, err := vc.disksToDelete(ctx, vcdClient, zones, cluster)
+	if err != nil {
+		return false, err
+	}
+
+	// A disk no longer retained is deleted like the others, and reported
+	// again should it be retained once more.
+	wasReported := map[string]bool{}
+	for _, task := range reported {
+		wasReported[task.Object.ID] = true
+	}
+	reported = make([]Task, 0, len(retained))
+	for _, o := range retained {
+		if !wasReported[o.ID] {
+			log.Info(fmt.Sprintf("Disk [%s] will be retained", o.Name))
+			ReportRetained(ctx, o)
+		}
+		reported = append(reported, Task{Object: o, Operation: OperationRetain})
+	}
+
+	idle := make([]*types.DiskRecordType, 0, len(toDelete))
+	for _, diskRecord := range toDelete {
+		if !busy[diskRecord.Id] {
+			idle = append(idle, diskRecord)
+		}
+	}
+
+	var mu sync.Mutex
+	err = forEach(ctx, vc.Concurrency, "", idle, func(diskRecord *types.DiskRecordType) error {
+		started, err := vc.startNextTask(ctx, log, zones, cluster, backup, diskRecord)
+
+		mu.Lock()
+		pending = append(pending, started...)
+		mu.Unlock()
+
 		if err != nil {
-			return false, fmt.Errorf("failed to delete disk:[%s] [%v]", diskRecord.Name, err)
+			ReportFailed(ctx, zones.object(diskRecord), err)
+			return err
 		}
+		return nil
+	})
+	tasks.set(append(pending, reported...))
+	if err != nil {
+		return false, err
 	}
 
-	return false, nil
+	return len(pending) > 0, nil
+}
+
+// startNextTask starts detaching the disk from every vm holding it. A disk
+// nothing holds is renamed when it is retained renamed, deleted otherwise.
+func (vc *VolumeCleaner) startNextTask(ctx context.Context, log logr.Logger, zones *diskZones, cluster *vcdcluster.Cluster, backup string, diskRecord *types.DiskRecordType) ([]Task, error) {
+	vcdClient, err := zones.client(diskRecord)
+	if err != nil {
+		return nil, err
+	}
+
+	disk, err := vcd.GetDiskByHref(vcdClient, diskRecord.HREF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk:[%s] [%v]", diskRecord.Name, err)
+	}
+
+	vms, err := vcd.GetAttachedVms(vcdClient, disk)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get attached VMs to disk:[%s] [%v]", diskRecord.Name, err)
+	}
+
+	if len(vms) > 0 {
+		var started []Task
+		for _, vm := range vms {
+			log.Info(fmt.Sprintf("Detaching [%s] from [%s]", diskRecord.Name, vm.Name))
+			if err := ReportIntent(ctx, zones.object(diskRecord), OperationDetach); err != nil {
+				return started, err
+			}
+			task, err := vcd.StartDetachDiskFromVM(vcdClient, cluster.GetName(), vm.Name, disk)
+			if err != nil {
+				return started, fmt.Errorf("failed to detach VMs from disk:[%s] [%v]", diskRecord.Name, err)
+			}
+			started = append(started, Task{HREF: task.Task.HREF, Object: zones.object(diskRecord), Operation: OperationDetach, Detail: vm.Name})
+		}
+		return started, nil
+	}
+
+	if backup == DiskBackupRetainRenamed {
+		name := retainedDiskPrefix + diskRecord.Name
+		log.Info(fmt.Sprintf("Retaining disk [%s] renamed to [%s]", diskRecord.Name, name))
+		if err := ReportIntent(ctx, zones.object(diskRecord), OperationRetainRenamed); err != nil {
+			return nil, err
+		}
+		task, err := vcd.StartUpdateDisk(vcdClient, disk, name, retainedDescription(cluster, diskRecord.Name, time.Now()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to retain disk:[%s] renamed [%v]", diskRecord.Name, err)
+		}
+		return []Task{{HREF: task.Task.HREF, Object: zones.object(diskRecord), Operation: OperationRetainRenamed, Detail: renamedReference(name, diskRecord)}}, nil
+	}
+
+	log.Info(fmt.Sprintf("Disk [%s] will be deleted", diskRecord.Name))
+	if err := ReportIntent(ctx, zones.object(diskRecord), OperationDelete); err != nil {
+		return nil, err
+	}
+	task, err := vcd.StartDeleteDisk(vcdClient, disk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete disk:[%s] [%v]", diskRecord.Name, err)
+	}
+	return []Task{{HREF: task.Task.HREF, Object: zones.object(diskRecord), Operation: OperationDelete}}, nil
+}
+
+// deleteDisk releases the disk from every vm holding it, then deletes it.
+func (vc *VolumeCleaner) deleteDisk(ctx context.Context, zones *diskZones, cluster *vcdcluster.Cluster, diskRecord *types.DiskRecordType, log logr.Logger) error {
+	vcdClient, err := zones.client(diskRecord)
+	if err != nil {
+		return err
+	}
+
+	// The intent covers detaching the disk on the way.
+	if err := ReportIntent(ctx, zones.object(diskRecord), OperationDelete); err != nil {
+		return err
+	}
+
+	disk, err := vc.detachDisk(ctx, vcdClient, zones, cluster, diskRecord, log)
+	if err != nil {
+		return err
+	}
+
+	err = vcd.DeleteDisk(vcdClient, disk)
+	if err != nil {
+		return fmt.Errorf("failed to delete disk:[%s] [%v]", diskRecord.Name, err)
+	}
+
+	return nil
+}
+
+// keepDisk releases the disk from every vm holding it, then renames it and
+// drops the infra id from its description. It returns the reference to the
+// renamed disk: the new name and its id.
+func (vc *VolumeCleaner) keepDisk(ctx context.Context, zones *diskZones, cluster *vcdcluster.Cluster, diskRecord *types.DiskRecordType, log logr.Logger) (string, error) {
+	vcdClient, err := zones.client(diskRecord)
+	if err != nil {
+		return "", err
+	}
+
+	if err := ReportIntent(ctx, zones.object(diskRecord), OperationRetainRenamed); err != nil {
+		return "", err
+	}
+
+	disk, err := vc.detachDisk(ctx, vcdClient, zones, cluster, diskRecord, log)
+	if err != nil {
+		return "", err
+	}
+
+	name := retainedDiskPrefix + diskRecord.Name
+	log.Info(fmt.Sprintf("Retaining disk [%s] renamed to [%s]", diskRecord.Name, name))
+	err = vcd.UpdateDisk(vcdClient, disk, name, retainedDescription(cluster, diskRecord.Name, time.Now()))
+	if err != nil {
+		return "", fmt.Errorf("failed to retain disk:[%s] renamed [%v]", diskRecord.Name, err)
+	}
+
+	return renamedReference(name, diskRecord), nil
+}
+
+// detachDisk releases the disk from every vm holding it, and returns it with
+// the links it has once nothing holds it.
+func (vc *VolumeCleaner) detachDisk(ctx context.Context, vcdClient *vcdsdk.Client, zones *diskZones, cluster *vcdcluster.Cluster, diskRecord *types.DiskRecordType, log logr.Logger) (*types.Disk, error) {
+	disk, err := vcd.GetDiskByHref(vcdClient, diskRecord.HREF)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get disk:[%s] [%v]", diskRecord.Name, err)
+	}
+
+	detached, err := vcd.DetachFromAllVms(vcdClient, cluster.GetName(), disk, log)
+	for _, vmName := range detached {
+		ReportDetached(ctx, zones.object(diskRecord), vmName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to detach VMs from disk:[%s] [%v]", diskRecord.Name, err)
+	}
+
+	return disk, nil
+}
+
+// renamedReference is what a disk retained renamed is reported as: its new
+// name and the id of the disk.
+func renamedReference(name string, diskRecord *types.DiskRecordType) string {
+	return name + " (" + diskRecord.Id + ")"
+}
+
+func diskObject(diskRecord *types.DiskRecordType) Object {
+	return Object{Kind: KindDisk, ID: diskRecord.Id, Name: diskRecord.Name}
+}
+
+// diskZones finds the zone of a disk of the cluster, and a client working in
+// it. The disk query covers the whole org, while vms are looked up in the OVDC
+// of the client.
+type diskZones struct {
+	vcdClient *vcdsdk.Client
+	cluster   *vcdcluster.Cluster
+	zones     []vcdcluster.Zone
+
+	mu      sync.Mutex
+	clients map[string]*vcdsdk.Client
+}
+
+func newDiskZones(vcdClient *vcdsdk.Client, cluster *vcdcluster.Cluster) (*diskZones, error) {
+	zones, err := cluster.Zones()
+	if err != nil {
+		return nil, err
+	}
+
+	return &diskZones{
+		vcdClient: vcdClient,
+		cluster:   cluster,
+		zones:     zones,
+		clients:   map[string]*vcdsdk.Client{},
+	}, nil
+}
+
+// zone returns the zone of the OVDC the disk is in. A disk in an OVDC that is
+// no zone of the cluster is left to its default zone.
+func (dz *diskZones) zone(diskRecord *types.DiskRecordType) vcdcluster.Zone {
+	for _, zone := range dz.zones {
+		if zone.Ovdc == diskRecord.VdcName {
+			return zone
+		}
+	}
+	for _, zone := range dz.zones {
+		if zone.Ovdc == dz.cluster.Ovdc {
+			return zone
+		}
+	}
+
+	return dz.cluster.DefaultZone()
+}
+
+// client returns a client working in the zone of the disk.
+func (dz *diskZones) client(diskRecord *types.DiskRecordType) (*vcdsdk.Client, error) {
+	zone := dz.zone(diskRecord)
+
+	dz.mu.Lock()
+	defer dz.mu.Unlock()
+
+	if zoneClient, ok := dz.clients[zone.Ovdc]; ok {
+		return zoneClient, nil
+	}
+	zoneClient, err := vcd.ZoneClient(dz.vcdClient, zone)
+	if err != nil {
+		return nil, zoneError(zone, err)
+	}
+	dz.clients[zone.Ovdc] = zoneClient
+
+	return zoneClient, nil
+}
+
+// object is the disk as reported, with its zone.
+func (dz *diskZones) object(diskRecord *types.DiskRecordType) Object {
+	o := diskObject(diskRecord)
+	o.Zone = dz.zone(diskRecord).Name
+	return o
 }
@@ -0,0 +1,106 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// forEachZone calls fn for every zone of c with a client working in the OVDC
+// of the zone. A failing zone does not stop the others, forEachZone returns
+// the errors of every zone joined, each naming its zone.
+func forEachZone(vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, fn func(zoneClient *vcdsdk.Client, zone vcdcluster.Zone) error) error {
+	zones, err := c.Zones()
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, zone := range zones {
+		zoneClient, err := vcd.ZoneClient(vcdClient, zone)
+		if err == nil {
+			err = fn(zoneClient, zone)
+		}
+		if err != nil {
+			errs = append(errs, zoneError(zone, err))
+		}
+	}
+
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// forEachGateway calls fn with the edge gateway of every zone of c. Zones on
+// the same network share its gateway, fn is called once for them.
+func forEachGateway(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, fn func(zoneClient *vcdsdk.Client, zone vcdcluster.Zone, gateway *vcdsdk.GatewayManager) error) error {
+	seen := map[string]bool{}
+	return forEachZone(vcdClient, c, func(zoneClient *vcdsdk.Client, zone vcdcluster.Zone) error {
+		if seen[zone.OvdcNetwork] {
+			return nil
+		}
+		seen[zone.OvdcNetwork] = true
+
+		gateway, err := vcd.GetGateway(ctx, zoneClient, zone)
+		if err != nil {
+			return err
+		}
+		return fn(zoneClient, zone, gateway)
+	})
+}
+
+// zoneError names the zone err happened in. The zone of a single zone cluster
+// has no name, its errors are left as they are.
+func zoneError(zone vcdcluster.Zone, err error) error {
+	if zone.Name == "" {
+		return err
+	}
+	return fmt.Errorf("zone [%s]: %w", zone.Name, err)
+}
+
+// onGateway marks objects as found on the edge gateway of zone.
+func onGateway(objects []Object, zone vcdcluster.Zone, gateway *vcdsdk.GatewayManager) []Object {
+	for i := range objects {
+		objects[i].Zone = zone.Name
+		objects[i].Gateway = gatewayName(gateway)
+	}
+	return objects
+}
+
+// ownedOnGateway marks owned objects as found on the edge gateway of zone.
+func ownedOnGateway(all []Owned, zone vcdcluster.Zone, gateway *vcdsdk.GatewayManager) []Owned {
+	for i := range all {
+		all[i].Zone = zone.Name
+		all[i].Gateway = gatewayName(gateway)
+	}
+	return all
+}
+
+func gatewayName(gateway *vcdsdk.GatewayManager) string {
+	if gateway.GatewayRef == nil {
+		return ""
+	}
+	return gateway.GatewayRef.Name
+}
@@ -0,0 +1,363 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cleanerctl runs the cleaners against the VCD objects of an infra id
+// without a VCDCluster, for clusters whose VCDCluster is already gone. It needs
+// no Kubernetes api server, the cluster is described by Options.
+package cleanerctl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// Outcomes of the objects in a Result.
+const (
+	OutcomePlanned         = "planned"
+	OutcomeDeleted         = "deleted"
+	OutcomeFailed          = "failed"
+	OutcomeDetached        = "detached"
+	OutcomeRetained        = "retained"
+	OutcomeRetainedRenamed = "retainedRenamed"
+)
+
+// Options describe the cluster to clean up and how.
+type Options struct {
+	Site    string
+	Org     string
+	Ovdc    string
+	Network string
+	// Zones lists, as the json of key.ZonesAnnotation, the zones of a cluster
+	// that spans several OVDCs.
+	Zones string
+	// VApp is the vApp of the cluster. Disks attached to its vms are
+	// detached before they are deleted, without it they fail to delete.
+	VApp    string
+	InfraId string
+
+	// Username and Password, or APIToken, log in to VCD.
+	Username string
+	Password string
+	APIToken string
+
+	// DiskBackup is what is done with disks instead of deleting them,
+	// cleaner.DiskBackupNone when empty.
+	DiskBackup string
+
+	// DryRun only lists the objects that would be deleted. Yes deletes them
+	// without asking first.
+	DryRun bool
+	Yes    bool
+	// JSON writes the result as json instead of a table.
+	JSON bool
+
+	// Transport connects to the site, vcd.DefaultTransport when nil.
+	Transport *vcd.Transport
+	// PollInterval is how long to wait between the runs of a cleaner that
+	// waits for VCD tasks. It defaults to 5 seconds.
+	PollInterval time.Duration
+
+	Log logr.Logger
+}
+
+// Result is what a run did, or would do on a dry run.
+type Result struct {
+	InfraId string         `json:"infraId"`
+	DryRun  bool           `json:"dryRun"`
+	Objects []ObjectResult `json:"objects"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// ObjectResult is what happened to one VCD object.
+type ObjectResult struct {
+	cleaner.Object
+
+	Cleaner string `json:"cleaner"`
+	Outcome string `json:"outcome"`
+	// VM is the vm a disk was detached from, RenamedTo the name a retained
+	// disk was renamed to.
+	VM        string `json:"vm,omitempty"`
+	RenamedTo string `json:"renamedTo,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Run plans the clean-up of the objects of opts.InfraId and, unless it is a
+// dry run, asks on stdin whether to go on and runs the cleaners. Questions
+// go to stderr, the result to stdout.
+func Run(ctx context.Context, opts Options, stdin io.Reader, stdout, stderr io.Writer) error {
+	vcdCluster, err := newCluster(opts)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	transport := opts.Transport
+	if transport == nil {
+		transport = vcd.DefaultTransport
+	}
+	// The credentials are inline, no api server is needed to read them.
+	vcdClient, err := transport.GetVCDClient(ctx, nil, vcdCluster, opts.Log)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	volumeCleaner := cleaner.NewVolumeCleaner(nil)
+	volumeCleaner.DiskBackup = opts.DiskBackup
+	cleaners, err := cleaner.Sort([]cleaner.Cleaner{
+		volumeCleaner,
+		cleaner.NewVirtualServiceCleaner(nil),
+		cleaner.NewLBPoolCleaner(nil),
+		cleaner.NewDNATCleaner(nil),
+		cleaner.NewAppPortProfileCleaner(nil),
+	})
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	result := Result{InfraId: opts.InfraId, DryRun: opts.DryRun, Objects: []ObjectResult{}}
+	for _, c := range cleaners {
+		objects, err := c.Plan(ctx, opts.Log, vcdClient, vcdCluster)
+		if err != nil {
+			return microerror.Mask(err)
+		}
+		for _, o := range objects {
+			result.Objects = append(result.Objects, ObjectResult{Object: o, Cleaner: c.Name(), Outcome: OutcomePlanned})
+		}
+	}
+
+	if opts.DryRun || len(result.Objects) == 0 {
+		return microerror.Mask(writeResult(stdout, opts.JSON, result))
+	}
+
+	if !opts.Yes {
+		if err := writeTable(stderr, result.Objects); err != nil {
+			return microerror.Mask(err)
+		}
+		if !confirm(stdin, stderr, fmt.Sprintf("Delete these %d VCD objects of infra id %s?", len(result.Objects), opts.InfraId)) {
+			return microerror.Maskf(abortedError, "nothing was deleted")
+		}
+	}
+
+	collector := &collector{}
+	cleanErr := clean(ctx, opts, vcdClient, vcdCluster, cleaners, collector)
+
+	result.Objects = collector.objects
+	if result.Objects == nil {
+		result.Objects = []ObjectResult{}
+	}
+	if cleanErr != nil {
+		result.Error = cleanErr.Error()
+	}
+	if err := writeResult(stdout, opts.JSON, result); err != nil {
+		return microerror.Mask(err)
+	}
+
+	return microerror.Mask(cleanErr)
+}
+
+// newCluster describes the cluster of opts the way a VCDCluster would.
+func newCluster(opts Options) (*vcdcluster.Cluster, error) {
+	switch {
+	case opts.Site == "":
+		return nil, microerror.Maskf(invalidOptionsError, "the site is missing")
+	case opts.Org == "":
+		return nil, microerror.Maskf(invalidOptionsError, "the org is missing")
+	case opts.Ovdc == "":
+		return nil, microerror.Maskf(invalidOptionsError, "the ovdc is missing")
+	case opts.Username == "" && opts.APIToken == "":
+		return nil, microerror.Maskf(invalidOptionsError, "neither a username nor an api token is given")
+	}
+	if err := vcd.ValidateInfraId(opts.InfraId); err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if opts.DiskBackup != "" {
+		if err := cleaner.ValidateDiskBackup(opts.DiskBackup); err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	o := &capvcd.VCDCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			// The volume cleaner finds the vms in the vApp named after the
+			// cluster.
+			Name: opts.VApp,
+		},
+		Spec: capvcd.VCDClusterSpec{
+			Site:        opts.Site,
+			Org:         opts.Org,
+			Ovdc:        opts.Ovdc,
+			OvdcNetwork: opts.Network,
+			UserCredentialsContext: capvcd.UserCredentialsContext{
+				Username:     opts.Username,
+				Password:     opts.Password,
+				RefreshToken: opts.APIToken,
+			},
+		},
+		Status: capvcd.VCDClusterStatus{
+			InfraId: opts.InfraId,
+			// CAPVCD records the org there, the app port profiles are
+			// looked up in it.
+			Org: opts.Org,
+		},
+	}
+	if opts.Zones != "" {
+		o.SetAnnotations(map[string]string{key.ZonesAnnotation: opts.Zones})
+	}
+	c := vcdcluster.FromV1beta1(o)
+
+	// A malformed zones list fails here rather than half way through.
+	if _, err := c.Zones(); err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return c, nil
+}
+
+// clean runs the cleaners one after the other, in the order of their
+// dependencies. A cleaner that waits for VCD tasks runs again every
+// PollInterval until they are done. The first error stops the clean-up.
+func clean(ctx context.Context, opts Options, vcdClient *vcdsdk.Client, vcdCluster *vcdcluster.Cluster, cleaners []cleaner.Cleaner, collector *collector) error {
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = 5 * time.Second
+	}
+
+	for _, c := range cleaners {
+		tasks := cleaner.NewTasks(nil)
+		cleanerCtx := cleaner.WithTasks(cleaner.WithObserver(ctx, collector.forCleaner(c.Name())), tasks)
+		for {
+			requeue, err := c.Clean(cleanerCtx, opts.Log, vcdClient, vcdCluster)
+			if err != nil {
+				return microerror.Mask(err)
+			}
+			if !requeue {
+				break
+			}
+
+			opts.Log.Info("Waiting for VCD tasks", "cleaner", c.Name(), "tasks", len(tasks.List())-tasks.Retained())
+			select {
+			case <-ctx.Done():
+				return microerror.Mask(ctx.Err())
+			case <-time.After(pollInterval):
+			}
+		}
+	}
+
+	return nil
+}
+
+// confirm asks question on w and reads the answer from r. Only yes goes on.
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(r).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+func writeResult(w io.Writer, asJSON bool, result Result) error {
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return microerror.Mask(encoder.Encode(result))
+	}
+
+	if len(result.Objects) == 0 {
+		_, err := fmt.Fprintf(w, "No VCD objects of infra id %s found.\n", result.InfraId)
+		return microerror.Mask(err)
+	}
+	return microerror.Mask(writeTable(w, result.Objects))
+}
+
+func writeTable(w io.Writer, objects []ObjectResult) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "OUTCOME\tKIND\tNAME\tID\tZONE\tGATEWAY\tDETAILS")
+	for _, o := range objects {
+		details := o.Error
+		switch {
+		case o.VM != "":
+			details = "from vm " + o.VM
+		case o.RenamedTo != "":
+			details = "as " + o.RenamedTo
+		case o.Action == cleaner.OperationRetainRenamed:
+			details = "to retain renamed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", o.Outcome, o.Kind, o.Name, o.ID, o.Zone, o.Gateway, details)
+	}
+	return microerror.Mask(tw.Flush())
+}
+
+// collector gathers what the cleaners did.
+type collector struct {
+	mu      sync.Mutex
+	objects []ObjectResult
+}
+
+func (c *collector) forCleaner(name string) cleaner.Observer {
+	return &collectorObserver{collector: c, cleaner: name}
+}
+
+func (c *collector) add(o ObjectResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.objects = append(c.objects, o)
+}
+
+type collectorObserver struct {
+	collector *collector
+	cleaner   string
+}
+
+func (o *collectorObserver) ObjectDeleted(ctx context.Context, obj cleaner.Object) {
+	o.collector.add(ObjectResult{Object: obj, Cleaner: o.cleaner, Outcome: OutcomeDeleted})
+}
+
+func (o *collectorObserver) ObjectFailed(ctx context.Context, obj cleaner.Object, err error) {
+	o.collector.add(ObjectResult{Object: obj, Cleaner: o.cleaner, Outcome: OutcomeFailed, Error: err.Error()})
+}
+
+func (o *collectorObserver) ObjectDetached(ctx context.Context, obj cleaner.Object, vmName string) {
+	o.collector.add(ObjectResult{Object: obj, Cleaner: o.cleaner, Outcome: OutcomeDetached, VM: vmName})
+}
+
+func (o *collectorObserver) ObjectRetained(ctx context.Context, obj cleaner.Object) {
+	o.collector.add(ObjectResult{Object: obj, Cleaner: o.cleaner, Outcome: OutcomeRetained})
+}
+
+func (o *collectorObserver) ObjectRetainedRenamed(ctx context.Context, obj cleaner.Object, renamed string) {
+	o.collector.add(ObjectResult{Object: obj, Cleaner: o.cleaner, Outcome: OutcomeRetainedRenamed, RenamedTo: renamed})
+}
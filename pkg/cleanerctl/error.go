@@ -0,0 +1,41 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleanerctl
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var invalidOptionsError = &microerror.Error{
+	Kind: "invalidOptionsError",
+}
+
+// IsInvalidOptions asserts invalidOptionsError.
+func IsInvalidOptions(err error) bool {
+	return errors.Is(err, invalidOptionsError)
+}
+
+var abortedError = &microerror.Error{
+	Kind: "abortedError",
+}
+
+// IsAborted asserts abortedError.
+func IsAborted(err error) bool {
+	return errors.Is(err, abortedError)
+}
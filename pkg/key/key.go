@@ -16,7 +16,82 @@ limitations under the License.
 
 package key
 
+import (
+	"strings"
+)
+
 const (
 	CapiClusterLabelKey  = "cluster.x-k8s.io/cluster-name"
 	CleanerFinalizerName = "cluster-api-cleaner-cloud-director.finalizers.giantswarm.io"
+
+	// SecretFinalizerName keeps the credentials secret of a VCDCluster until
+	// the VCD objects of the cluster are cleaned up.
+	SecretFinalizerName = "cluster-api-cleaner-cloud-director.finalizers.giantswarm.io/credentials"
+
+	// DryRunPlanAnnotation holds the objects the cleaners would delete when the
+	// manager runs with --dry-run.
+	DryRunPlanAnnotation = "cluster-api-cleaner-cloud-director.giantswarm.io/dry-run-plan"
+
+	// DryRunPlanOmittedAnnotation holds how many objects DryRunPlanAnnotation
+	// leaves out to stay small. The logs list every object.
+	DryRunPlanOmittedAnnotation = "cluster-api-cleaner-cloud-director.giantswarm.io/dry-run-plan-omitted"
+
+	// VCDClientConditionAnnotation holds why the VCD client of a VCDCluster
+	// being deleted could not be built, and how often that failed.
+	VCDClientConditionAnnotation = "cluster-api-cleaner-cloud-director.giantswarm.io/vcd-client-condition"
+
+	// DiskBackupAnnotation overrides --disk-backup for the disks of one
+	// VCDCluster.
+	DiskBackupAnnotation = "cluster-api-cleaner-cloud-director.giantswarm.io/disk-backup"
+
+	// ZonesAnnotation lists, as json, the zones of a VCDCluster that spans
+	// several OVDCs. Without it the cluster has the single zone of its spec.
+	ZonesAnnotation = "cluster-api-cleaner-cloud-director.giantswarm.io/zones"
+
+	// CABundleAnnotation names a ConfigMap in the namespace of the VCDCluster
+	// whose ca.crt key holds PEM certificates trusted for its site.
+	CABundleAnnotation = "cluster-api-cleaner-cloud-director.giantswarm.io/ca-bundle"
+
+	// NotificationsAnnotation holds, as json, how often the clean-up of a
+	// VCDCluster being deleted failed in a row and which notifications were
+	// sent, so every one is sent once.
+	NotificationsAnnotation = "cluster-api-cleaner-cloud-director.giantswarm.io/notifications"
 )
+
+const (
+	cleanerStatusAnnotationPrefix = "cluster-api-cleaner-cloud-director.giantswarm.io/status-"
+	cleanerTasksAnnotationPrefix  = "cluster-api-cleaner-cloud-director.giantswarm.io/tasks-"
+)
+
+// CleanerStatusAnnotation is the annotation that holds the outcome of a cleaner
+// on a VCDCluster being deleted.
+func CleanerStatusAnnotation(cleanerName string) string {
+	return cleanerStatusAnnotationPrefix + strings.ToLower(cleanerName)
+}
+
+// CleanerTasksAnnotation is the annotation that holds the VCD tasks a cleaner
+// started on a VCDCluster being deleted and polls on its next run, and the
+// objects it already reported as retained.
+func CleanerTasksAnnotation(cleanerName string) string {
+	return cleanerTasksAnnotationPrefix + strings.ToLower(cleanerName)
+}
+
+// RetainAnnotation is the annotation that keeps the cleaners from deleting VCD
+// objects of the given kind when the VCDCluster is deleted. Its value is
+// "true" to retain every object of the kind, or a comma separated list of
+// shell patterns matched against the object names.
+func RetainAnnotation(kind string) string {
+	return "cluster-api-cleaner-cloud-director.giantswarm.io/retain-" + strings.ToLower(kind)
+}
+
+// StateAnnotation tells whether an annotation is one the manager writes to
+// track the clean-up of a VCDCluster, rather than one users set.
+func StateAnnotation(name string) bool {
+	switch name {
+	case VCDClientConditionAnnotation, DryRunPlanAnnotation, DryRunPlanOmittedAnnotation, NotificationsAnnotation:
+		return true
+	}
+
+	return strings.HasPrefix(name, cleanerStatusAnnotationPrefix) ||
+		strings.HasPrefix(name, cleanerTasksAnnotationPrefix)
+}
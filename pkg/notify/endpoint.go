@@ -0,0 +1,125 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+	"os"
+	"slices"
+	"text/template"
+
+	"github.com/giantswarm/microerror"
+	"sigs.k8s.io/yaml"
+)
+
+// Endpoint is an http endpoint notifications are posted to.
+type Endpoint struct {
+	// URL is where the notifications are posted, with an http or https
+	// scheme.
+	URL string `json:"url"`
+	// Events are the events the endpoint gets, every event when empty.
+	Events []string `json:"events,omitempty"`
+	// Headers are added to every request, e.g. an authorization header.
+	Headers map[string]string `json:"headers,omitempty"`
+	// Template is a Go template of the payload, run on the Notification. The
+	// json function encodes a value, e.g. {"text": {{ json .Cluster }}}. The
+	// Notification is posted as json when it is empty.
+	Template string `json:"template,omitempty"`
+
+	template *template.Template
+}
+
+// LoadEndpoints reads the yaml list of endpoints of a file. An empty file
+// name is no endpoints.
+func LoadEndpoints(file string) ([]Endpoint, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	var endpoints []Endpoint
+	if err := yaml.UnmarshalStrict(data, &endpoints); err != nil {
+		return nil, microerror.Maskf(invalidEndpointError, "%s: %s", file, err)
+	}
+
+	for i := range endpoints {
+		if err := endpoints[i].parse(); err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// parse validates the endpoint and parses its template.
+func (e *Endpoint) parse() error {
+	u, err := url.Parse(e.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return microerror.Maskf(invalidEndpointError, "endpoint [%s] is not an http or https url", e.URL)
+	}
+	for _, event := range e.Events {
+		if !slices.Contains(Events, event) {
+			return microerror.Maskf(invalidEndpointError, "endpoint [%s] subscribes to unknown event [%s]", e.URL, event)
+		}
+	}
+	if e.Template == "" {
+		return nil
+	}
+
+	e.template, err = template.New(e.URL).Funcs(template.FuncMap{"json": toJSON}).Option("missingkey=error").Parse(e.Template)
+	if err != nil {
+		return microerror.Maskf(invalidEndpointError, "template of endpoint [%s]: %s", e.URL, err)
+	}
+
+	// A template that fails on a sample would fail on every notification.
+	if _, err := e.payload(Notification{Event: EventCompleted}); err != nil {
+		return microerror.Maskf(invalidEndpointError, "template of endpoint [%s]: %s", e.URL, err)
+	}
+	return nil
+}
+
+// payload renders n for the endpoint. It has to be json.
+func (e *Endpoint) payload(n Notification) ([]byte, error) {
+	if e.Template != "" && e.template == nil {
+		if err := e.parse(); err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+	if e.template == nil {
+		body, err := json.Marshal(n)
+		return body, microerror.Mask(err)
+	}
+
+	var buf bytes.Buffer
+	if err := e.template.Execute(&buf, n); err != nil {
+		return nil, microerror.Maskf(invalidPayloadError, "endpoint [%s]: %s", e.URL, err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, microerror.Maskf(invalidPayloadError, "endpoint [%s] renders no valid json: %s", e.URL, buf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+func toJSON(v any) (string, error) {
+	encoded, err := json.Marshal(v)
+	return string(encoded), err
+}
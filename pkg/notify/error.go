@@ -0,0 +1,59 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package notify
+
+import (
+	"errors"
+
+	"github.com/giantswarm/microerror"
+)
+
+var invalidEndpointError = &microerror.Error{
+	Kind: "invalidEndpointError",
+}
+
+// IsInvalidEndpoint asserts invalidEndpointError.
+func IsInvalidEndpoint(err error) bool {
+	return errors.Is(err, invalidEndpointError)
+}
+
+var invalidPayloadError = &microerror.Error{
+	Kind: "invalidPayloadError",
+}
+
+// IsInvalidPayload asserts invalidPayloadError.
+func IsInvalidPayload(err error) bool {
+	return errors.Is(err, invalidPayloadError)
+}
+
+var deliveryFailedError = &microerror.Error{
+	Kind: "deliveryFailedError",
+}
+
+// IsDeliveryFailed asserts deliveryFailedError.
+func IsDeliveryFailed(err error) bool {
+	return errors.Is(err, deliveryFailedError)
+}
+
+var bufferFullError = &microerror.Error{
+	Kind: "bufferFullError",
+}
+
+// IsBufferFull asserts bufferFullError.
+func IsBufferFull(err error) bool {
+	return errors.Is(err, bufferFullError)
+}
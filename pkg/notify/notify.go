@@ -0,0 +1,222 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package notify posts a json payload to http endpoints when the clean-up of a
+// VCDCluster completes, keeps failing or takes too long, so chat and incident
+// tooling hear about it without scraping logs.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Events a notification is sent for.
+const (
+	// EventCompleted is sent when the finalizer is removed.
+	EventCompleted = "completed"
+	// EventFailed is sent when the clean-up failed several times in a row.
+	EventFailed = "failed"
+	// EventSlow is sent when the clean-up still runs a while after the
+	// deletion.
+	EventSlow = "slow"
+)
+
+// Events lists every event.
+var Events = []string{EventCompleted, EventFailed, EventSlow}
+
+// Notification is what an endpoint gets, as json or rendered by its
+// template.
+type Notification struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+
+	ManagementCluster string `json:"managementCluster,omitempty"`
+	Namespace         string `json:"namespace"`
+	// Cluster is the name of the CAPI cluster, VCDCluster the one of the
+	// VCDCluster being deleted.
+	Cluster    string `json:"cluster"`
+	VCDCluster string `json:"vcdCluster"`
+	InfraId    string `json:"infraId"`
+
+	// Objects counts the VCD objects of the clean-up by kind, then by outcome.
+	Objects map[string]map[string]int `json:"objects,omitempty"`
+	// Errors are the latest errors of the clean-up.
+	Errors []string `json:"errors,omitempty"`
+	// Failures is how many times in a row the clean-up failed.
+	Failures int `json:"failures,omitempty"`
+	// DurationSeconds is the time since the deletion of the VCDCluster.
+	DurationSeconds float64 `json:"durationSeconds"`
+}
+
+var notificationsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "cluster_api_cleaner_cloud_director",
+	Name:      "notifications_failed_total",
+	Help:      "Number of notifications an endpoint did not accept.",
+}, []string{"management_cluster", "event"})
+
+func init() {
+	metrics.Registry.MustRegister(notificationsFailed)
+}
+
+// Sender sends notifications. Notifier posts them right away,
+// BufferedNotifier in the background.
+type Sender interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// Notifier sends notifications to its endpoints.
+type Notifier struct {
+	Endpoints []Endpoint
+	// Client defaults to a client with a 10 seconds timeout.
+	Client *http.Client
+}
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// Notify posts n to every endpoint that subscribed to its event. A failing
+// endpoint does not keep n from the others, the errors are returned together.
+func (nt *Notifier) Notify(ctx context.Context, n Notification) error {
+	var errs []error
+	for _, e := range nt.Endpoints {
+		if len(e.Events) > 0 && !slices.Contains(e.Events, n.Event) {
+			continue
+		}
+		if err := nt.post(ctx, e, n); err != nil {
+			notificationsFailed.WithLabelValues(n.ManagementCluster, n.Event).Inc()
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (nt *Notifier) post(ctx context.Context, e Endpoint, n Notification) error {
+	body, err := e.payload(n)
+	if err != nil {
+		return microerror.Mask(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.URL, bytes.NewReader(body))
+	if err != nil {
+		return microerror.Mask(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	client := nt.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return microerror.Maskf(deliveryFailedError, "%s: %s", e.URL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return microerror.Maskf(deliveryFailedError, "%s answered %s", e.URL, resp.Status)
+	}
+	return nil
+}
+
+// drainTimeout bounds sending the buffered notifications when the manager
+// stops.
+const drainTimeout = 10 * time.Second
+
+// BufferedNotifier sends notifications with Notifier in the background, so a
+// slow endpoint does not hold up the reconciles. A notification that does not
+// get through is logged and counted, it is not sent again. Notify fails when
+// the buffer is full.
+type BufferedNotifier struct {
+	Notifier *Notifier
+	Log      logr.Logger
+
+	notifications chan Notification
+}
+
+// NewBufferedNotifier returns a notifier buffering size notifications for
+// notifier. It sends them once started.
+func NewBufferedNotifier(notifier *Notifier, size int, log logr.Logger) *BufferedNotifier {
+	return &BufferedNotifier{
+		Notifier:      notifier,
+		Log:           log,
+		notifications: make(chan Notification, size),
+	}
+}
+
+func (bn *BufferedNotifier) Notify(ctx context.Context, n Notification) error {
+	select {
+	case bn.notifications <- n:
+		return nil
+	default:
+		notificationsFailed.WithLabelValues(n.ManagementCluster, n.Event).Inc()
+		return microerror.Maskf(bufferFullError, "%d notifications are waiting to be sent", cap(bn.notifications))
+	}
+}
+
+// Start sends the buffered notifications until ctx is done, then tries the
+// ones left once more. It runs as a runnable of the manager.
+func (bn *BufferedNotifier) Start(ctx context.Context) error {
+	for {
+		select {
+		case n := <-bn.notifications:
+			bn.send(ctx, n)
+		case <-ctx.Done():
+			bn.drain()
+			return nil
+		}
+	}
+}
+
+// NeedLeaderElection is false, notifications are sent on every replica.
+func (bn *BufferedNotifier) NeedLeaderElection() bool {
+	return false
+}
+
+// drain sends the notifications left in the buffer.
+func (bn *BufferedNotifier) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	for {
+		select {
+		case n := <-bn.notifications:
+			bn.send(ctx, n)
+		default:
+			return
+		}
+	}
+}
+
+func (bn *BufferedNotifier) send(ctx context.Context, n Notification) {
+	if err := bn.Notifier.Notify(ctx, n); err != nil {
+		bn.Log.Error(err, "Failed to send the notification", "event", n.Event, "namespace", n.Namespace, "vcdCluster", n.VCDCluster)
+		return
+	}
+
+	bn.Log.V(1).Info("Sent the notification", "event", n.Event, "namespace", n.Namespace, "vcdCluster", n.VCDCluster)
+}
@@ -0,0 +1,213 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sweeper
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"reflect"
+	"slices"
+
+	"github.com/giantswarm/microerror"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// LedgerName is the name of the ConfigMap a manager keeps its ledger in.
+const LedgerName = "cluster-api-cleaner-cloud-director-infra-ids"
+
+const (
+	ledgerInfraIdsKey          = "infraIds"
+	ledgerEntriesKey           = "entries"
+	ledgerManagementClusterKey = "managementCluster"
+)
+
+// Ledger keeps the infra ids of the VCDClusters a management cluster manages,
+// from the first reconcile until their clean-up completed, in a ConfigMap of
+// its own namespace. Other management clusters and tenants may share an org
+// and vdc, so the sweeper only deletes the orphans of infra ids in the ledger:
+// those of clusters whose finalizer was removed before the clean-up was done.
+// Next to every infra id it keeps the Entry of the cluster, so the sweeper
+// still visits its locations once no VCDCluster points at them.
+type Ledger struct {
+	Client            client.Client
+	Namespace         string
+	Name              string
+	ManagementCluster string
+}
+
+// Entry is where a cluster in the ledger keeps its objects and what it logs
+// in to VCD with. Usernames and passwords in the spec are not kept, a cluster
+// that has no credentials secret is swept with the credentials of another one
+// in its org.
+type Entry struct {
+	Namespace         string                  `json:"namespace"`
+	Site              string                  `json:"site"`
+	Org               string                  `json:"org"`
+	Zones             []vcdcluster.Zone       `json:"zones"`
+	CredentialsSecret *corev1.SecretReference `json:"credentialsSecret,omitempty"`
+	// CABundle is the ConfigMap named by the CA bundle annotation.
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+// EntryOf returns the Entry of c. Zones that can't be read are left out, the
+// default zone is always there.
+func EntryOf(c *vcdcluster.Cluster) Entry {
+	zones, err := c.Zones()
+	if err != nil {
+		zones = []vcdcluster.Zone{c.DefaultZone()}
+	}
+
+	entry := Entry{
+		Namespace: c.GetNamespace(),
+		Site:      c.Site,
+		Org:       c.Org,
+		Zones:     zones,
+		CABundle:  c.GetAnnotations()[key.CABundleAnnotation],
+	}
+	if c.Credentials.SecretRef != nil {
+		ref := *c.Credentials.SecretRef
+		entry.CredentialsSecret = &ref
+	}
+
+	return entry
+}
+
+// Owned returns the infra ids in the ledger.
+func (l *Ledger) Owned(ctx context.Context) (map[string]bool, error) {
+	_, infraIds, _, err := l.get(ctx)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	owned := make(map[string]bool, len(infraIds))
+	for _, infraId := range infraIds {
+		owned[infraId] = true
+	}
+
+	return owned, nil
+}
+
+// Entries returns the Entry of every infra id in the ledger that has one.
+func (l *Ledger) Entries(ctx context.Context) (map[string]Entry, error) {
+	_, _, entries, err := l.get(ctx)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return entries, nil
+}
+
+// Add writes infraId and its entry to the ledger, unless they are already
+// there.
+func (l *Ledger) Add(ctx context.Context, infraId string, entry Entry) error {
+	return l.update(ctx, func(infraIds []string, entries map[string]Entry) []string {
+		entries[infraId] = entry
+		if slices.Contains(infraIds, infraId) {
+			return infraIds
+		}
+		return append(infraIds, infraId)
+	})
+}
+
+// Remove drops infraId and its entry from the ledger.
+func (l *Ledger) Remove(ctx context.Context, infraId string) error {
+	return l.update(ctx, func(infraIds []string, entries map[string]Entry) []string {
+		delete(entries, infraId)
+		return slices.DeleteFunc(infraIds, func(id string) bool { return id == infraId })
+	})
+}
+
+func (l *Ledger) update(ctx context.Context, change func([]string, map[string]Entry) []string) error {
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}, func() error {
+		cm, infraIds, entries, err := l.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		changedEntries := maps.Clone(entries)
+		changed := change(slices.Clone(infraIds), changedEntries)
+		slices.Sort(changed)
+		if slices.Equal(changed, infraIds) && reflect.DeepEqual(changedEntries, entries) {
+			return nil
+		}
+
+		encoded, err := json.Marshal(changed)
+		if err != nil {
+			return err
+		}
+		encodedEntries, err := json.Marshal(changedEntries)
+		if err != nil {
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[ledgerInfraIdsKey] = string(encoded)
+		cm.Data[ledgerEntriesKey] = string(encodedEntries)
+		cm.Data[ledgerManagementClusterKey] = l.ManagementCluster
+
+		if cm.ResourceVersion == "" {
+			return l.Client.Create(ctx, cm)
+		}
+		return l.Client.Update(ctx, cm)
+	})
+
+	return microerror.Mask(err)
+}
+
+// get reads the ConfigMap of the ledger, the sorted infra ids and the entries
+// in it. A ledger that does not exist yet is empty.
+func (l *Ledger) get(ctx context.Context) (*corev1.ConfigMap, []string, map[string]Entry, error) {
+	cm := &corev1.ConfigMap{}
+	err := l.Client.Get(ctx, types.NamespacedName{Namespace: l.Namespace, Name: l.Name}, cm)
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{}
+		cm.Namespace = l.Namespace
+		cm.Name = l.Name
+		return cm, nil, map[string]Entry{}, nil
+	} else if err != nil {
+		return nil, nil, nil, microerror.Mask(err)
+	}
+
+	var infraIds []string
+	if raw, ok := cm.Data[ledgerInfraIdsKey]; ok {
+		if err := json.Unmarshal([]byte(raw), &infraIds); err != nil {
+			return nil, nil, nil, microerror.Maskf(invalidLedgerError, "ConfigMap %s/%s: %s", l.Namespace, l.Name, err)
+		}
+	}
+	slices.Sort(infraIds)
+
+	// Ledgers written before entries were kept have none.
+	entries := map[string]Entry{}
+	if raw, ok := cm.Data[ledgerEntriesKey]; ok {
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			return nil, nil, nil, microerror.Maskf(invalidLedgerError, "ConfigMap %s/%s: %s", l.Namespace, l.Name, err)
+		}
+	}
+
+	return cm, infraIds, entries, nil
+}
@@ -0,0 +1,73 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sweeper
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+)
+
+const metricsNamespace = "cluster_api_cleaner_cloud_director"
+
+var (
+	orphanedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "orphaned_objects",
+		Help:      "Number of VCD objects whose infra id matches no VCDCluster, by kind, as of the last sweep.",
+	}, []string{"management_cluster", "kind"})
+
+	orphansDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "orphaned_objects_deleted_total",
+		Help:      "Number of orphaned VCD objects deleted by the sweeper, by kind.",
+	}, []string{"management_cluster", "kind"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(orphanedObjects, orphansDeleted)
+}
+
+func setOrphanMetrics(managementCluster string, orphans []Orphan) {
+	counts := map[string]int{
+		cleaner.KindDisk:           0,
+		cleaner.KindDNATRule:       0,
+		cleaner.KindVirtualService: 0,
+		cleaner.KindLBPool:         0,
+		cleaner.KindAppPortProfile: 0,
+	}
+	for _, o := range orphans {
+		counts[o.Kind]++
+	}
+	for kind, count := range counts {
+		orphanedObjects.WithLabelValues(managementCluster, kind).Set(float64(count))
+	}
+}
+
+// deleteCounter counts the orphans the cleaners delete.
+type deleteCounter struct {
+	managementCluster string
+}
+
+func (d *deleteCounter) ObjectDeleted(ctx context.Context, o cleaner.Object) {
+	orphansDeleted.WithLabelValues(d.managementCluster, o.Kind).Inc()
+}
+
+func (d *deleteCounter) ObjectFailed(ctx context.Context, o cleaner.Object, err error) {}
@@ -0,0 +1,432 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sweeper finds VCD objects left behind by clusters the cleaner never
+// saw being deleted, e.g. because the finalizer was removed by hand or the
+// cluster predates the cleaner.
+package sweeper
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/audit"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// Orphan is a VCD object whose infra id matches no VCDCluster. It is Owned
+// when its infra id is in the Ledger of the management cluster.
+type Orphan struct {
+	cleaner.Object
+	InfraId  string
+	Location Location
+	Owned    bool
+}
+
+// Location is where a group of VCDClusters keeps its objects.
+type Location struct {
+	Site        string
+	Org         string
+	Ovdc        string
+	OvdcNetwork string
+}
+
+func locationOf(c *vcdcluster.Cluster) Location {
+	return Location{
+		Site:        c.Site,
+		Org:         c.Org,
+		Ovdc:        c.Ovdc,
+		OvdcNetwork: c.OvdcNetwork,
+	}
+}
+
+// OrphanSweeper periodically lists the objects of every cleaner at every
+// location a VCDCluster or the Ledger points at, and reports those whose infra
+// id matches no VCDCluster.
+// With Delete set it also deletes them, using the cleaners, once an orphan was
+// found by two sweeps in a row and its infra id is in the Ledger. Orphans of
+// infra ids the management cluster never managed may belong to another one
+// sharing the org and vdc, they are only reported.
+type OrphanSweeper struct {
+	Client client.Client
+	Log    logr.Logger
+
+	ManagementCluster string
+	Cleaners          []cleaner.Cleaner
+	Interval          time.Duration
+	Delete            bool
+
+	// NewVCDClient builds the VCD api client. It defaults to vcd.GetVCDClient.
+	NewVCDClient func(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error)
+	// InvalidateVCDClient is called with a client VCD answered 401 to, so that
+	// a cached session is not reused. It may be nil.
+	InvalidateVCDClient func(vcdClient *vcdsdk.Client)
+	// Version is the api version VCDClusters are listed in. It defaults to
+	// v1beta1.
+	Version *vcdcluster.Version
+	// Audit, when set, gets a record of every orphaned object deleted.
+	Audit audit.Sink
+	// Ledger holds the infra ids of the clusters the management cluster
+	// manages. Without it no orphan is deleted.
+	Ledger *Ledger
+
+	// previous holds the orphaned infra ids of the last sweep per location.
+	previous map[Location]map[string]bool
+}
+
+// Start sweeps every Interval until ctx is done.
+func (s *OrphanSweeper) Start(ctx context.Context) error {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.Sweep(ctx); err != nil {
+			s.Log.Error(err, "Orphan sweep failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection makes only the leader sweep.
+func (s *OrphanSweeper) NeedLeaderElection() bool {
+	return true
+}
+
+// Sweep runs once and returns the orphans it found. A location that can't be
+// listed is logged and skipped.
+func (s *OrphanSweeper) Sweep(ctx context.Context) ([]Orphan, error) {
+	vcdClusters, err := s.Version.List(ctx, s.Client)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	owned := map[string]bool{}
+	entries := map[string]Entry{}
+	if s.Ledger != nil {
+		owned, err = s.Ledger.Owned(ctx)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		entries, err = s.Ledger.Entries(ctx)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	// The cloud provider trims infra ids in the names of load balancer
+	// objects, those are known by their trimmed form.
+	known := map[string]bool{}
+	clustersAt := map[Location][]*vcdcluster.Cluster{}
+	for _, c := range vcdClusters {
+		if c.Status.InfraId == "" {
+			continue
+		}
+		known[c.Status.InfraId] = true
+		known[vcd.TrimInfraId(c.Status.InfraId)] = true
+		clustersAt[locationOf(c)] = append(clustersAt[locationOf(c)], c)
+	}
+
+	var orphans []Orphan
+	current := map[Location]map[string]bool{}
+	reported := map[string]bool{}
+	sweep := func(log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, location Location) {
+		found, err := s.find(ctx, vcdClient, c, location, known, owned)
+		if err != nil {
+			s.invalidateVCDClient(vcdClient, err)
+			log.Error(err, "Failed to list VCD objects, skipping the location")
+			return
+		}
+
+		current[location] = map[string]bool{}
+		for _, o := range found {
+			current[location][o.InfraId] = true
+			// App port profiles belong to the org, every network of it lists them.
+			key := location.Site + "/" + o.Kind + "/" + o.ID + "/" + o.Name
+			if reported[key] {
+				continue
+			}
+			reported[key] = true
+			orphans = append(orphans, o)
+			log.Info("Found orphaned VCD object", "kind", o.Kind, "name", o.Name, "id", o.ID, "infraId", o.InfraId, "owned", o.Owned)
+		}
+
+		if s.Delete {
+			s.deleteOrphans(ctx, log, vcdClient, c, location, current[location], owned)
+		}
+	}
+
+	// swept holds every zone a VCDCluster was listed in, a location of the
+	// ledger that is one of them is not listed again.
+	swept := map[Location]bool{}
+	locations := sortedLocations(clustersAt)
+	for _, location := range locations {
+		log := s.Log.WithValues("site", location.Site, "org", location.Org, "ovdc", location.Ovdc, "network", location.OvdcNetwork)
+
+		vcdClient, c, err := s.clientFor(ctx, log, clustersAt[location])
+		if err != nil {
+			log.Error(err, "Failed to build a VCD client, skipping the location")
+			continue
+		}
+		if err := vcdcluster.LoadZones(ctx, s.Client, c); err != nil {
+			log.Error(err, "Failed to read the zones of the location, skipping it")
+			continue
+		}
+		if zones, err := c.Zones(); err == nil {
+			for _, zone := range zones {
+				swept[Location{Site: c.Site, Org: c.Org, Ovdc: zone.Ovdc, OvdcNetwork: zone.OvdcNetwork}] = true
+			}
+		}
+
+		sweep(log, vcdClient, c, location)
+	}
+
+	// The locations only the ledger knows of are those of clusters that are
+	// gone. They are listed with the credentials of a VCDCluster in the same
+	// org, or else with those the clusters there had.
+	ledgerAt, err := s.ledgerClusters(entries, swept)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	ledgerLocations := sortedLocations(ledgerAt)
+	for _, location := range ledgerLocations {
+		log := s.Log.WithValues("site", location.Site, "org", location.Org, "ovdc", location.Ovdc, "network", location.OvdcNetwork)
+
+		var candidates []*vcdcluster.Cluster
+		for _, l := range locations {
+			if l.Site == location.Site && l.Org == location.Org {
+				candidates = append(candidates, clustersAt[l]...)
+			}
+		}
+		vcdClient, _, err := s.clientFor(ctx, log, append(candidates, ledgerAt[location]...))
+		if err != nil {
+			log.Error(err, "Failed to build a VCD client, skipping the location")
+			continue
+		}
+
+		sweep(log, vcdClient, ledgerAt[location][0], location)
+	}
+	s.previous = current
+
+	setOrphanMetrics(s.ManagementCluster, orphans)
+	s.Log.Info("Orphan sweep done", "locations", len(locations)+len(ledgerLocations), "orphans", len(orphans))
+
+	return orphans, nil
+}
+
+// ledgerClusters builds, for every zone of entries that was not swept, a
+// cluster in that zone per infra id, with the credentials the cluster had.
+func (s *OrphanSweeper) ledgerClusters(entries map[string]Entry, swept map[Location]bool) (map[Location][]*vcdcluster.Cluster, error) {
+	infraIds := make([]string, 0, len(entries))
+	for infraId := range entries {
+		infraIds = append(infraIds, infraId)
+	}
+	sort.Strings(infraIds)
+
+	clustersAt := map[Location][]*vcdcluster.Cluster{}
+	for _, infraId := range infraIds {
+		entry := entries[infraId]
+		for _, zone := range entry.Zones {
+			location := Location{Site: entry.Site, Org: entry.Org, Ovdc: zone.Ovdc, OvdcNetwork: zone.OvdcNetwork}
+			if swept[location] {
+				continue
+			}
+
+			c, err := vcdcluster.New(s.Version.NewObject())
+			if err != nil {
+				return nil, microerror.Mask(err)
+			}
+			c.SetNamespace(entry.Namespace)
+			if entry.CABundle != "" {
+				c.SetAnnotations(map[string]string{key.CABundleAnnotation: entry.CABundle})
+			}
+			c.Site = entry.Site
+			c.Org = entry.Org
+			c.Ovdc = zone.Ovdc
+			c.OvdcNetwork = zone.OvdcNetwork
+			c.VipSubnet = zone.VipSubnet
+			if entry.CredentialsSecret != nil {
+				ref := *entry.CredentialsSecret
+				c.Credentials.SecretRef = &ref
+			}
+			clustersAt[location] = append(clustersAt[location], c)
+		}
+	}
+
+	return clustersAt, nil
+}
+
+// sortedLocations returns the locations of clustersAt in a stable order.
+func sortedLocations(clustersAt map[Location][]*vcdcluster.Cluster) []Location {
+	locations := make([]Location, 0, len(clustersAt))
+	for location := range clustersAt {
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		a, b := locations[i], locations[j]
+		if a.Site != b.Site {
+			return a.Site < b.Site
+		}
+		if a.Org != b.Org {
+			return a.Org < b.Org
+		}
+		if a.Ovdc != b.Ovdc {
+			return a.Ovdc < b.Ovdc
+		}
+		return a.OvdcNetwork < b.OvdcNetwork
+	})
+
+	return locations
+}
+
+// clientFor builds a VCD client with the credentials of the first cluster at
+// the location that has working ones.
+func (s *OrphanSweeper) clientFor(ctx context.Context, log logr.Logger, clusters []*vcdcluster.Cluster) (*vcdsdk.Client, *vcdcluster.Cluster, error) {
+	newVCDClient := s.NewVCDClient
+	if newVCDClient == nil {
+		newVCDClient = vcd.GetVCDClient
+	}
+
+	var err error
+	for _, c := range clusters {
+		var vcdClient *vcdsdk.Client
+		vcdClient, err = newVCDClient(ctx, s.Client, c, log)
+		if err == nil {
+			return vcdClient, c, nil
+		}
+	}
+
+	return nil, nil, microerror.Mask(err)
+}
+
+// invalidateVCDClient drops vcdClient when err says its session is no longer
+// valid.
+func (s *OrphanSweeper) invalidateVCDClient(vcdClient *vcdsdk.Client, err error) {
+	if s.InvalidateVCDClient != nil && vcd.IsUnauthorized(err) {
+		s.InvalidateVCDClient(vcdClient)
+	}
+}
+
+// find lists the objects of every cleaner at the location and keeps those with
+// an infra id that is not known. A trimmed infra id is matched with the owned
+// ones it may be trimmed from.
+func (s *OrphanSweeper) find(ctx context.Context, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, location Location, known, owned map[string]bool) ([]Orphan, error) {
+	trimmed := make(map[string]string, len(owned))
+	for infraId := range owned {
+		trimmed[vcd.TrimInfraId(infraId)] = infraId
+	}
+
+	var orphans []Orphan
+	for _, cl := range s.Cleaners {
+		lister, ok := cl.(cleaner.Lister)
+		if !ok {
+			continue
+		}
+
+		all, err := lister.List(ctx, vcdClient, c)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		for _, o := range all {
+			infraId, ok := vcd.FindInfraId(o.Owner)
+			if !ok || known[infraId] {
+				continue
+			}
+			if full, ok := trimmed[infraId]; ok {
+				infraId = full
+			}
+			orphans = append(orphans, Orphan{Object: o.Object, InfraId: infraId, Location: location, Owned: owned[infraId]})
+		}
+	}
+
+	return orphans, nil
+}
+
+// deleteOrphans runs the cleaners for every owned infra id that was orphaned
+// in the last sweep already. The second look makes sure a cluster that was
+// just created, and whose VCDCluster the cache did not see yet, is left alone.
+// An infra id whose objects are all gone is dropped from the ledger.
+func (s *OrphanSweeper) deleteOrphans(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster, location Location, orphaned, owned map[string]bool) {
+	cleaners, err := cleaner.Sort(s.Cleaners)
+	if err != nil {
+		log.Error(err, "Invalid cleaner dependencies, not deleting orphans")
+		return
+	}
+
+	infraIds := make([]string, 0, len(orphaned))
+	for infraId := range orphaned {
+		if !owned[infraId] {
+			continue
+		}
+		if s.previous[location][infraId] {
+			infraIds = append(infraIds, infraId)
+		}
+	}
+	sort.Strings(infraIds)
+
+	for _, infraId := range infraIds {
+		// The cleaners find the objects of a cluster by its infra id. The name
+		// of the vApp of the lost cluster is unknown, so attached disks are not
+		// detached and fail to delete.
+		// The annotations are those of another cluster, so its retained
+		// objects do not apply. Its zones do, they are where the orphans were
+		// listed.
+		orphan := c.DeepCopy()
+		orphan.SetName("")
+		orphan.SetAnnotations(nil)
+		if zones, ok := c.GetAnnotations()[key.ZonesAnnotation]; ok {
+			orphan.SetAnnotations(map[string]string{key.ZonesAnnotation: zones})
+		}
+		orphan.Status.InfraId = infraId
+
+		log := log.WithValues("infraId", infraId)
+		cleanerCtx := cleaner.WithObserver(ctx, &deleteCounter{managementCluster: s.ManagementCluster})
+		done := true
+		for _, cl := range cleaners {
+			cleanerCtx := cleanerCtx
+			if s.Audit != nil {
+				cleanerCtx = cleaner.WithObserver(cleanerCtx, audit.NewObserver(s.Audit, log, s.ManagementCluster, vcdClient, orphan, cl.Name()))
+			}
+			requeue, err := cl.Clean(cleanerCtx, log, vcdClient, orphan)
+			if err != nil {
+				s.invalidateVCDClient(vcdClient, err)
+				log.Error(err, "Failed to delete orphaned VCD objects", "cleaner", cl.Name())
+				done = false
+				break
+			}
+			done = done && !requeue
+		}
+
+		if done {
+			if err := s.Ledger.Remove(ctx, infraId); err != nil {
+				log.Error(err, "Failed to drop the infra id from the ledger")
+			}
+		}
+	}
+}
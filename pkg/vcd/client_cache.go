@@ -0,0 +1,197 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// ClientCache shares logged in VCD clients between reconciles, so deleting
+// many clusters of one org does not log in for every one of them. Clients are
+// keyed by site, org, vdc, the identity of the credentials and the way the
+// site is reached, so a changed credentials secret, ca bundle or proxy logs in
+// again.
+type ClientCache struct {
+	// MaxAge is how long a client is reused after it logged in. It should be
+	// shorter than the session lifetime VCD enforces.
+	MaxAge time.Duration
+	// IdleTimeout drops a client that was not used for that long, before VCD
+	// expires its idle session. Zero only applies MaxAge.
+	IdleTimeout time.Duration
+
+	// Transport is how the clients connect to sites. Clusters that connect to
+	// a site differently do not share sessions. It defaults to
+	// DefaultTransport.
+	Transport *Transport
+	// NewClient logs in. It defaults to the GetVCDClient of Transport.
+	NewClient func(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error)
+
+	mu      sync.Mutex
+	clients map[string]*cachedClient
+}
+
+type cachedClient struct {
+	client *vcdsdk.Client
+	// serviceAccountToken is a hash of the service account token the login
+	// left in the credentials secret, empty for other credentials.
+	serviceAccountToken string
+	loggedIn            time.Time
+	lastUsed            time.Time
+}
+
+// NewClientCache returns an empty cache.
+func NewClientCache(maxAge, idleTimeout time.Duration) *ClientCache {
+	return &ClientCache{MaxAge: maxAge, IdleTimeout: idleTimeout}
+}
+
+// GetVCDClient returns the cached client for the credentials of vcdCluster,
+// or logs in with NewClient.
+func (cc *ClientCache) GetVCDClient(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error) {
+	transport := cc.Transport
+	if transport == nil {
+		transport = DefaultTransport
+	}
+	newClient := cc.NewClient
+	if newClient == nil {
+		newClient = transport.GetVCDClient
+	}
+
+	userCreds, err := getUserCredentialsForCluster(ctx, c, vcdCluster.Credentials)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	connection, err := transport.connectionKey(ctx, c, vcdCluster)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	key := clientKey(vcdCluster, userCreds, connection)
+
+	cc.mu.Lock()
+	cc.expire()
+	if cached, ok := cc.clients[key]; ok {
+		if cached.serviceAccountToken == serviceAccountTokenHash(userCreds) {
+			cached.lastUsed = time.Now()
+			cc.mu.Unlock()
+			log.V(1).Info("Reusing VCD session", "site", vcdCluster.Site, "org", vcdCluster.Org)
+			return cached.client, nil
+		}
+		// The token was replaced outside of the cleaner, e.g. by the token
+		// of another service account, which logs in again.
+		delete(cc.clients, key)
+	}
+	cc.mu.Unlock()
+
+	vcdClient, err := newClient(ctx, c, vcdCluster, log)
+	if err != nil {
+		return nil, err
+	}
+
+	// Logging in with a service account replaced its token, the session is
+	// kept for the new one.
+	if userCreds.ServiceAccountToken != "" {
+		userCreds, err = getUserCredentialsForCluster(ctx, c, vcdCluster.Credentials)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if cc.clients == nil {
+		cc.clients = map[string]*cachedClient{}
+	}
+	now := time.Now()
+	cc.clients[key] = &cachedClient{
+		client:              vcdClient,
+		serviceAccountToken: serviceAccountTokenHash(userCreds),
+		loggedIn:            now,
+		lastUsed:            now,
+	}
+
+	return vcdClient, nil
+}
+
+// Invalidate drops vcdClient from the cache, e.g. after VCD answered 401 to
+// it. The next GetVCDClient for its credentials logs in again.
+func (cc *ClientCache) Invalidate(vcdClient *vcdsdk.Client) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for key, cached := range cc.clients {
+		if cached.client == vcdClient {
+			delete(cc.clients, key)
+		}
+	}
+}
+
+// expire drops the clients that are too old or were idle for too long. The
+// caller holds mu.
+func (cc *ClientCache) expire() {
+	now := time.Now()
+	for key, cached := range cc.clients {
+		if now.Sub(cached.loggedIn) >= cc.MaxAge || (cc.IdleTimeout > 0 && now.Sub(cached.lastUsed) >= cc.IdleTimeout) {
+			delete(cc.clients, key)
+		}
+	}
+}
+
+// clientKey identifies the session a VCDCluster logs in with: the site, org
+// and vdc, the credentials and the digest of how the site is reached. VCD replaces the token of a service account on
+// every login, so a service account is identified by the secret its token is
+// kept in instead. Credentials are hashed so they are not kept in plain text.
+func clientKey(vcdCluster *vcdcluster.Cluster, userCreds vcdcluster.Credentials, connection string) string {
+	identity := []string{userCreds.Username, userCreds.Password, userCreds.RefreshToken}
+	if ref := vcdCluster.Credentials.SecretRef; userCreds.ServiceAccountToken != "" && ref != nil {
+		identity = []string{serviceAccountTokenKey, ref.Namespace, ref.Name}
+	}
+
+	return hash(append([]string{vcdCluster.Site, vcdCluster.Org, vcdCluster.Ovdc, connection}, identity...)...)
+}
+
+// serviceAccountTokenHash hashes the service account token of the credentials,
+// empty when they have none.
+func serviceAccountTokenHash(userCreds vcdcluster.Credentials) string {
+	if userCreds.ServiceAccountToken == "" {
+		return ""
+	}
+
+	return hash(userCreds.ServiceAccountToken)
+}
+
+// hash returns the sha256 of the values, separated so they can't run into
+// each other.
+func hash(values ...string) string {
+	h := sha256.New()
+	for _, s := range values {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,216 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Reasons a VCD client could not be built, as returned by ClassifyClientError.
+const (
+	ReasonSecretNotFound       = "SecretNotFound"
+	ReasonInvalidCredentials   = "InvalidCredentials"
+	ReasonSiteUnreachable      = "SiteUnreachable"
+	ReasonUntrustedCertificate = "UntrustedCertificate"
+	ReasonInvalidCABundle      = "InvalidCABundle"
+	ReasonClientFailed         = "VCDClientFailed"
+)
+
+var secretNotFoundError = &microerror.Error{
+	Kind: "secretNotFoundError",
+}
+
+// IsSecretNotFound asserts secretNotFoundError.
+func IsSecretNotFound(err error) bool {
+	return errors.Is(err, secretNotFoundError)
+}
+
+var invalidCredentialsError = &microerror.Error{
+	Kind: "invalidCredentialsError",
+}
+
+// IsInvalidCredentials asserts invalidCredentialsError.
+func IsInvalidCredentials(err error) bool {
+	return errors.Is(err, invalidCredentialsError)
+}
+
+var siteUnreachableError = &microerror.Error{
+	Kind: "siteUnreachableError",
+}
+
+// IsSiteUnreachable asserts siteUnreachableError.
+func IsSiteUnreachable(err error) bool {
+	return errors.Is(err, siteUnreachableError)
+}
+
+var untrustedCertificateError = &microerror.Error{
+	Kind: "untrustedCertificateError",
+}
+
+// IsUntrustedCertificate asserts untrustedCertificateError.
+func IsUntrustedCertificate(err error) bool {
+	return errors.Is(err, untrustedCertificateError)
+}
+
+var invalidCABundleError = &microerror.Error{
+	Kind: "invalidCABundleError",
+}
+
+// IsInvalidCABundle asserts invalidCABundleError.
+func IsInvalidCABundle(err error) bool {
+	return errors.Is(err, invalidCABundleError)
+}
+
+var invalidProxyError = &microerror.Error{
+	Kind: "invalidProxyError",
+}
+
+// IsInvalidProxy asserts invalidProxyError.
+func IsInvalidProxy(err error) bool {
+	return errors.Is(err, invalidProxyError)
+}
+
+var invalidInfraIdError = &microerror.Error{
+	Kind: "invalidInfraIdError",
+}
+
+// IsInvalidInfraId asserts invalidInfraIdError.
+func IsInvalidInfraId(err error) bool {
+	return errors.Is(err, invalidInfraIdError)
+}
+
+// vcdsdk flattens the errors it gets from govcd and net/http into strings, so
+// the only way to tell them apart is by their message.
+var (
+	unreachableMessages = []string{
+		"error finding LoginUrl",
+		"dial tcp",
+		"no such host",
+		"connection refused",
+		"connection reset",
+		"i/o timeout",
+		"context deadline exceeded",
+		"TLS handshake timeout",
+		"network is unreachable",
+	}
+	// untrustedCertificateMessages are what crypto/tls says when the
+	// certificate of a site can't be verified.
+	untrustedCertificateMessages = []string{
+		"x509: ",
+		"tls: failed to verify certificate",
+	}
+	invalidCredentialsMessages = []string{
+		"error authorizing",
+		"failed to authenticate",
+		"failed to set authorization header",
+		"401 Unauthorized",
+		"403 Forbidden",
+	}
+	// unauthorizedMessages are what govcd and the swagger client say when VCD
+	// answers 401 to a client that logged in before.
+	unauthorizedMessages = []string{
+		"401 Unauthorized",
+		"API Error: 401",
+		"[401]",
+	}
+	// taskGoneMessages are what govcd says when VCD answers 404 or 403 to a
+	// task, once it was purged or the session lost access to it.
+	taskGoneMessages = []string{
+		"API Error: 404",
+		"API Error: 403",
+		"404 Not Found",
+		"403 Forbidden",
+	}
+)
+
+// ClassifyClientError returns why a VCD client could not be built.
+func ClassifyClientError(err error) string {
+	switch {
+	case IsSecretNotFound(err) || apierrors.IsNotFound(err):
+		return ReasonSecretNotFound
+	case IsInvalidCABundle(err):
+		return ReasonInvalidCABundle
+	case IsSiteUnreachable(err):
+		return ReasonSiteUnreachable
+	case IsUntrustedCertificate(err):
+		return ReasonUntrustedCertificate
+	case IsInvalidCredentials(err):
+		return ReasonInvalidCredentials
+	}
+
+	message := err.Error()
+	// govcd wraps a certificate that can't be verified in the messages of
+	// the others, so it is checked first.
+	for _, m := range untrustedCertificateMessages {
+		if strings.Contains(message, m) {
+			return ReasonUntrustedCertificate
+		}
+	}
+	// A network error while authenticating says "error authorizing" too, so
+	// the unreachable messages are checked first.
+	for _, m := range unreachableMessages {
+		if strings.Contains(message, m) {
+			return ReasonSiteUnreachable
+		}
+	}
+	for _, m := range invalidCredentialsMessages {
+		if strings.Contains(message, m) {
+			return ReasonInvalidCredentials
+		}
+	}
+
+	return ReasonClientFailed
+}
+
+// IsUnauthorized tells whether VCD refused a request because the session of
+// the client expired or was revoked.
+func IsUnauthorized(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	message := err.Error()
+	for _, m := range unauthorizedMessages {
+		if strings.Contains(message, m) {
+			return true
+		}
+	}
+
+	return false
+}
+
+var taskGoneError = &microerror.Error{
+	Kind: "taskGoneError",
+}
+
+// IsTaskGone asserts taskGoneError.
+func IsTaskGone(err error) bool {
+	return errors.Is(err, taskGoneError)
+}
+
+var taskFailedError = &microerror.Error{
+	Kind: "taskFailedError",
+}
+
+// IsTaskFailed asserts taskFailedError.
+func IsTaskFailed(err error) bool {
+	return errors.Is(err, taskFailedError)
+}
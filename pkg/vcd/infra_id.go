@@ -0,0 +1,84 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+)
+
+const uuidPattern = `[0-9a-fA-F]{8}(?:-[0-9a-fA-F]{4}){3}-[0-9a-fA-F]{12}`
+
+// The cloud provider strips these prefixes from the infra id before it puts it
+// into the names of load balancer objects, to stay within the VCD name limits.
+// https://github.com/vmware/cloud-provider-for-cloud-director/blob/1.2.0/pkg/ccm/loadbalancer.go#L360
+var trimmedInfraIdPrefixes = []string{
+	"urn:vcloud:entity:vmware:",
+	"urn:vcloud:entity:cse:nativeCluster:",
+}
+
+var (
+	// infraIdPattern matches the infra id CAPVCD gives a cluster: the id of its
+	// runtime defined entity, or a random uuid prefixed with NO_RDE_ when the
+	// cluster has no entity.
+	infraIdPattern = regexp.MustCompile(`urn:vcloud:entity:[A-Za-z0-9._-]+:[A-Za-z0-9._-]+:` + uuidPattern + `|NO_RDE_` + uuidPattern)
+	// validInfraIdPattern is infraIdPattern for the whole string.
+	validInfraIdPattern = regexp.MustCompile(`^(?:` + infraIdPattern.String() + `)$`)
+	// trimmedInfraIdPattern matches what is left once the cloud provider
+	// trimmed one of trimmedInfraIdPrefixes: the entity type and uuid of
+	// "urn:vcloud:entity:vmware:", the bare uuid of
+	// "urn:vcloud:entity:cse:nativeCluster:".
+	trimmedInfraIdPattern = regexp.MustCompile(`(?:^|[-_])((?:[A-Za-z][A-Za-z0-9]*:)?` + uuidPattern + `)(?:$|-)`)
+)
+
+// ValidateInfraId refuses infra ids that do not look like one CAPVCD hands
+// out. Objects are matched on the infra id, so a short or generic one, such as
+// "cluster", would match the objects of other clusters.
+func ValidateInfraId(infraId string) error {
+	if !validInfraIdPattern.MatchString(infraId) {
+		return microerror.Maskf(invalidInfraIdError, "infra id [%s] is not a CAPVCD entity urn or NO_RDE_ id", infraId)
+	}
+
+	return nil
+}
+
+// TrimInfraId returns the infra id the way the cloud provider puts it into
+// the names of load balancer objects.
+func TrimInfraId(infraId string) string {
+	for _, prefix := range trimmedInfraIdPrefixes {
+		infraId = strings.TrimPrefix(infraId, prefix)
+	}
+
+	return infraId
+}
+
+// FindInfraId returns the first infra id in text, e.g. the name of a load
+// balancer pool or the description of a disk. An id the cloud provider
+// trimmed is returned trimmed, the prefix it had can't be told. Compare it
+// with the TrimInfraId of the ids it may be.
+func FindInfraId(text string) (string, bool) {
+	if infraId := infraIdPattern.FindString(text); infraId != "" {
+		return infraId, true
+	}
+	if match := trimmedInfraIdPattern.FindStringSubmatch(text); match != nil {
+		return match[1], true
+	}
+
+	return "", false
+}
@@ -0,0 +1,148 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"golang.org/x/net/http/httpproxy"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// Proxy is the egress proxy a VCD site is reached through.
+type Proxy struct {
+	// Site is the url or host name of the VCD site.
+	Site string `json:"site"`
+	// URL is the address of the proxy, with an http or https scheme.
+	URL string `json:"url"`
+	// NoProxy lists the hosts, domains and CIDRs that are reached directly,
+	// the way NO_PROXY does.
+	NoProxy []string `json:"noProxy,omitempty"`
+	// CredentialsSecret names the secret whose username and password keys
+	// authenticate to the proxy.
+	CredentialsSecret *v1.SecretReference `json:"credentialsSecret,omitempty"`
+}
+
+// LoadProxies reads the yaml list of proxies of a file. An empty file name is
+// no proxies.
+func LoadProxies(file string) ([]Proxy, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	var proxies []Proxy
+	if err := yaml.UnmarshalStrict(data, &proxies); err != nil {
+		return nil, microerror.Maskf(invalidProxyError, "%s: %s", file, err)
+	}
+
+	sites := map[string]bool{}
+	for _, p := range proxies {
+		if p.Site == "" {
+			return nil, microerror.Maskf(invalidProxyError, "proxy [%s] has no site", p.URL)
+		}
+		host := siteHost(p.Site)
+		if sites[host] {
+			return nil, microerror.Maskf(invalidProxyError, "site [%s] has several proxies", p.Site)
+		}
+		sites[host] = true
+
+		u, err := url.Parse(p.URL)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, microerror.Maskf(invalidProxyError, "proxy [%s] of site [%s] is not an http or https url", p.URL, p.Site)
+		}
+		if ref := p.CredentialsSecret; ref != nil && (ref.Name == "" || ref.Namespace == "") {
+			return nil, microerror.Maskf(invalidProxyError, "credentials secret of the proxy of site [%s] needs a name and a namespace", p.Site)
+		}
+	}
+
+	return proxies, nil
+}
+
+// proxy returns the proxy function of the connections to the site of
+// vcdCluster. Sites without a proxy use the one of the environment.
+func (t *Transport) proxy(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (func(*http.Request) (*url.URL, error), error) {
+	p := t.siteProxy(vcdCluster.Site)
+	if p == nil {
+		return http.ProxyFromEnvironment, nil
+	}
+
+	proxyURL, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, microerror.Maskf(invalidProxyError, "proxy [%s] of site [%s]: %s", p.URL, p.Site, err)
+	}
+	if p.CredentialsSecret != nil {
+		user, err := proxyCredentials(ctx, c, types.NamespacedName{Namespace: p.CredentialsSecret.Namespace, Name: p.CredentialsSecret.Name})
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		proxyURL.User = user
+	}
+	log.V(1).Info("Reaching the VCD site through a proxy", "site", vcdCluster.Site, "proxy", p.URL)
+
+	proxyFunc := (&httpproxy.Config{
+		HTTPProxy:  proxyURL.String(),
+		HTTPSProxy: proxyURL.String(),
+		NoProxy:    strings.Join(p.NoProxy, ","),
+	}).ProxyFunc()
+	return func(r *http.Request) (*url.URL, error) {
+		return proxyFunc(r.URL)
+	}, nil
+}
+
+// siteProxy returns the proxy of site, nil when it has none.
+func (t *Transport) siteProxy(site string) *Proxy {
+	host := siteHost(site)
+	for i := range t.Proxies {
+		if siteHost(t.Proxies[i].Site) == host {
+			return &t.Proxies[i]
+		}
+	}
+
+	return nil
+}
+
+// proxyCredentials reads the username and password of a proxy.
+func proxyCredentials(ctx context.Context, c client.Client, name types.NamespacedName) (*url.Userinfo, error) {
+	secret := &v1.Secret{}
+	if err := c.Get(ctx, name, secret); apierrors.IsNotFound(err) {
+		return nil, microerror.Maskf(secretNotFoundError, "proxy credentials secret [%s] in namespace [%s] not found",
+			name.Name, name.Namespace)
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	username := strings.TrimRight(string(secret.Data["username"]), "\n")
+	password := strings.TrimRight(string(secret.Data["password"]), "\n")
+
+	return url.UserPassword(username, password), nil
+}
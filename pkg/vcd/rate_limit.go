@@ -0,0 +1,126 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const metricsNamespace = "cluster_api_cleaner_cloud_director"
+
+// RateLimit is how many requests the clients of every cleaner, reconcile and
+// sweep send to one VCD site together.
+type RateLimit struct {
+	// RequestsPerSecond is the rate requests are sent at once the burst is
+	// spent. Zero does not limit the requests.
+	RequestsPerSecond float64
+	// Burst is how many requests are sent at once after a quiet time.
+	Burst int
+}
+
+var (
+	requestsThrottled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "vcd_requests_throttled_total",
+		Help:      "Number of VCD api requests that waited for the rate limit of their site.",
+	}, []string{"management_cluster", "site"})
+
+	throttleWait = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "vcd_request_throttle_seconds_total",
+		Help:      "Time VCD api requests waited for the rate limit of their site.",
+	}, []string{"management_cluster", "site"})
+
+	requestsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "vcd_requests_rejected_total",
+		Help:      "Number of VCD api requests the site answered with 429 Too Many Requests or 503 Service Unavailable, by code.",
+	}, []string{"management_cluster", "site", "code"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(requestsThrottled, throttleWait, requestsRejected)
+}
+
+// limiter returns the limiter shared by the clients of site, or nil when the
+// requests are not limited.
+func (t *Transport) limiter(site string) *rate.Limiter {
+	if t.RateLimit.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	host := siteHost(site)
+	if l, ok := t.limiters[host]; ok {
+		return l
+	}
+	if t.limiters == nil {
+		t.limiters = map[string]*rate.Limiter{}
+	}
+	burst := t.RateLimit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	l := rate.NewLimiter(rate.Limit(t.RateLimit.RequestsPerSecond), burst)
+	t.limiters[host] = l
+
+	return l
+}
+
+// rateLimitedTransport waits for the limiter of its site before every
+// request.
+type rateLimitedTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+
+	managementCluster string
+	site              string
+}
+
+func (rt *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if rt.limiter != nil {
+		r := rt.limiter.Reserve()
+		if delay := r.Delay(); delay > 0 {
+			requestsThrottled.WithLabelValues(rt.managementCluster, rt.site).Inc()
+			throttleWait.WithLabelValues(rt.managementCluster, rt.site).Add(delay.Seconds())
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				r.Cancel()
+				return nil, req.Context().Err()
+			}
+		}
+	}
+
+	resp, err := rt.base.RoundTrip(req)
+	if err == nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		requestsRejected.WithLabelValues(rt.managementCluster, rt.site, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+
+	return resp, err
+}
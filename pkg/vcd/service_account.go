@@ -0,0 +1,164 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// serviceAccountTokenKey is the key of the credentials secret that holds the
+// refresh token of a service account.
+const serviceAccountTokenKey = "serviceAccountToken"
+
+// serviceAccountTokens remembers the tokens this process replaced.
+var serviceAccountTokens = &tokenRotations{}
+
+// tokenRotations tracks the refresh tokens VCD replaced for every credentials
+// secret. VCD invalidates a service account token once it is used, and the
+// cache of the manager may serve the secret with the old token for a while
+// after the new one was written back.
+type tokenRotations struct {
+	// mu also serializes the logins of service accounts, so two reconciles do
+	// not spend the same token.
+	mu      sync.Mutex
+	secrets map[types.NamespacedName]*rotation
+}
+
+type rotation struct {
+	// next maps every token replaced since the secret was last read up to
+	// date to the one that replaced it.
+	next   map[string]string
+	latest string
+}
+
+// latest returns the token that replaced token, directly or not, or token
+// when it was not replaced.
+func (t *tokenRotations) latest(secret types.NamespacedName, token string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.latestLocked(secret, token)
+}
+
+func (t *tokenRotations) latestLocked(secret types.NamespacedName, token string) string {
+	r, ok := t.secrets[secret]
+	if !ok {
+		return token
+	}
+	// The secret is read up to date, the older tokens are not needed anymore.
+	if token == r.latest {
+		r.next = map[string]string{}
+		return token
+	}
+
+	seen := map[string]bool{}
+	for !seen[token] {
+		seen[token] = true
+		next, ok := r.next[token]
+		if !ok {
+			break
+		}
+		token = next
+	}
+
+	return token
+}
+
+// replaced records that VCD replaced token with next. The caller holds mu.
+func (t *tokenRotations) replaced(secret types.NamespacedName, token, next string) {
+	if t.secrets == nil {
+		t.secrets = map[types.NamespacedName]*rotation{}
+	}
+	r, ok := t.secrets[secret]
+	if !ok {
+		r = &rotation{next: map[string]string{}}
+		t.secrets[secret] = r
+	}
+	r.next[token] = next
+	r.latest = next
+}
+
+// newServiceAccountClient logs in with the refresh token of a service account.
+// VCD exchanges it for a bearer token and a new refresh token, which is
+// written back to the credentials secret.
+func newServiceAccountClient(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, token string, conn *connection, log logr.Logger) (*vcdsdk.Client, error) {
+	ref := vcdCluster.Credentials.SecretRef
+	if ref == nil {
+		return nil, fmt.Errorf("a service account token needs a credentials secret to be written back to")
+	}
+	secret := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+
+	serviceAccountTokens.mu.Lock()
+	defer serviceAccountTokens.mu.Unlock()
+
+	// Another reconcile may have spent the token while this one waited.
+	token = serviceAccountTokens.latestLocked(secret, token)
+
+	vcdClient, err := newGovcdClient(vcdCluster.Site, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshed, err := vcdClient.SetApiToken(vcdCluster.Org, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to authenticate with the service account token of secret [%s]: [%v]", secret, err)
+	}
+
+	if refreshed.RefreshToken != "" && refreshed.RefreshToken != token {
+		serviceAccountTokens.replaced(secret, token, refreshed.RefreshToken)
+		// The new token is remembered, a later login writes it back again.
+		if err := storeServiceAccountToken(ctx, c, secret, refreshed.RefreshToken); err != nil {
+			log.Error(err, "Failed to write the new service account token back", "secret", secret)
+		}
+	}
+
+	authConfig := vcdsdk.NewVCDAuthConfigFromSecrets(vcdCluster.Site, "", "", "", vcdCluster.Org, conn.tls.InsecureSkipVerify)
+
+	return newSDKClient(vcdCluster, authConfig, vcdClient, conn)
+}
+
+// storeServiceAccountToken writes the new refresh token of a service account
+// to its credentials secret.
+func storeServiceAccountToken(ctx context.Context, c client.Client, secret types.NamespacedName, token string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		s := &v1.Secret{}
+		if err := c.Get(ctx, secret, s); err != nil {
+			return err
+		}
+		if strings.TrimRight(string(s.Data[serviceAccountTokenKey]), "\n") == token {
+			return nil
+		}
+		if s.Data == nil {
+			s.Data = map[string][]byte{}
+		}
+		s.Data[serviceAccountTokenKey] = []byte(token)
+
+		return c.Update(ctx, s)
+	})
+}
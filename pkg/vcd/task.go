@@ -0,0 +1,58 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	"github.com/vmware/go-vcloud-director/v2/govcd"
+)
+
+// PollTask reads the VCD task at href once. It tells whether the task is
+// finished. A task that finished without success is a taskFailedError, one VCD
+// no longer knows or lets the client read is a taskGoneError.
+func PollTask(vcdClient *vcdsdk.Client, href string) (bool, error) {
+	task := govcd.NewTask(&vcdClient.VCDClient.Client)
+	task.Task.HREF = href
+
+	err := task.Refresh()
+	if err != nil {
+		for _, m := range taskGoneMessages {
+			if strings.Contains(err.Error(), m) {
+				return false, microerror.Maskf(taskGoneError, "task [%s]: %s", href, err)
+			}
+		}
+		return false, fmt.Errorf("failed to get task [%s]: [%v]", href, err)
+	}
+
+	switch task.Task.Status {
+	case "success":
+		return true, nil
+	case "error", "aborted", "canceled":
+		message := task.Task.Status
+		if task.Task.Error != nil {
+			message = task.Task.Error.Message
+		}
+		return true, microerror.Maskf(taskFailedError, "task [%s] %s: %s", task.Task.Operation, task.Task.Status, message)
+	}
+
+	// queued, preRunning or running
+	return false, nil
+}
@@ -0,0 +1,126 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// CABundleKey is the key of the ConfigMap named by the ca-bundle annotation
+// that holds the PEM certificates of a VCDCluster.
+const CABundleKey = "ca.crt"
+
+// LoadCABundle reads a PEM file of CA certificates. An empty file name is
+// no bundle.
+func LoadCABundle(file string) ([]byte, error) {
+	if file == "" {
+		return nil, nil
+	}
+
+	bundle, err := os.ReadFile(file)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return nil, microerror.Maskf(invalidCABundleError, "no certificate found in [%s]", file)
+	}
+
+	return bundle, nil
+}
+
+// Insecure tells whether the certificate of site is not verified.
+func (t *Transport) Insecure(site string) bool {
+	host := siteHost(site)
+	for _, s := range t.InsecureSites {
+		if siteHost(s) == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tlsConfig returns the TLS configuration of the connections to the site of
+// vcdCluster.
+func (t *Transport) tlsConfig(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*tls.Config, error) {
+	if t.Insecure(vcdCluster.Site) {
+		log.Info("Not verifying the certificate of the VCD site", "site", vcdCluster.Site)
+		return &tls.Config{InsecureSkipVerify: true}, nil //nolint:gosec // opted in with --vcd-insecure-sites
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if len(t.CABundle) > 0 {
+		pool.AppendCertsFromPEM(t.CABundle)
+	}
+
+	if name := vcdCluster.GetAnnotations()[key.CABundleAnnotation]; name != "" {
+		bundle, err := clusterCABundle(ctx, c, types.NamespacedName{Namespace: vcdCluster.GetNamespace(), Name: name})
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		pool.AppendCertsFromPEM(bundle)
+	}
+
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// clusterCABundle reads the ca bundle a VCDCluster points at.
+func clusterCABundle(ctx context.Context, c client.Client, name types.NamespacedName) ([]byte, error) {
+	cm := &v1.ConfigMap{}
+	if err := c.Get(ctx, name, cm); apierrors.IsNotFound(err) {
+		return nil, microerror.Maskf(invalidCABundleError, "configmap [%s] in namespace [%s] not found", name.Name, name.Namespace)
+	} else if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	bundle := []byte(cm.Data[CABundleKey])
+	if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+		return nil, microerror.Maskf(invalidCABundleError, "no certificate found in key [%s] of configmap [%s] in namespace [%s]",
+			CABundleKey, name.Name, name.Namespace)
+	}
+
+	return bundle, nil
+}
+
+// siteHost returns the lower case host of a site url, or the site itself when
+// it is a bare host name.
+func siteHost(site string) string {
+	site = strings.ToLower(strings.TrimSpace(site))
+	if u, err := url.Parse(site); err == nil && u.Host != "" {
+		return u.Host
+	}
+
+	return strings.TrimRight(site, "/")
+}
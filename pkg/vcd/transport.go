@@ -0,0 +1,175 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcd
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/giantswarm/microerror"
+	"github.com/go-logr/logr"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// Transport is how the VCD clients connect to sites. A certificate is trusted
+// when it chains up to the system roots, the CABundle or the ca bundle of the
+// VCDCluster.
+type Transport struct {
+	// CABundle holds PEM certificates trusted for every site.
+	CABundle []byte
+	// InsecureSites are the sites whose certificates are not verified at all,
+	// as urls or host names.
+	InsecureSites []string
+	// Proxies are the egress proxies of the sites that are not reached
+	// directly. Other sites use the proxy of the environment, if any.
+	Proxies []Proxy
+	// RateLimit limits the requests sent to every site.
+	RateLimit RateLimit
+	// ManagementCluster labels the metrics.
+	ManagementCluster string
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+}
+
+// DefaultTransport verifies every site against the system roots and the ca
+// bundle of its VCDCluster, and uses the proxy of the environment.
+var DefaultTransport = &Transport{}
+
+// connection is how the clients of one VCDCluster reach its site.
+type connection struct {
+	tls     *tls.Config
+	proxy   func(*http.Request) (*url.URL, error)
+	limiter *rate.Limiter
+
+	managementCluster string
+	site              string
+}
+
+// connection returns how the clients of vcdCluster reach its site.
+func (t *Transport) connection(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*connection, error) {
+	tlsConfig, err := t.tlsConfig(ctx, c, vcdCluster, log)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+	proxy, err := t.proxy(ctx, c, vcdCluster, log)
+	if err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return &connection{
+		tls:               tlsConfig,
+		proxy:             proxy,
+		limiter:           t.limiter(vcdCluster.Site),
+		managementCluster: t.ManagementCluster,
+		site:              siteHost(vcdCluster.Site),
+	}, nil
+}
+
+// connectionKey digests the settings the clients of vcdCluster reach its site
+// with: whether its certificate is verified, the ca bundles it is verified
+// against and the proxy with its credentials. Clusters whose connections
+// differ do not share sessions.
+func (t *Transport) connectionKey(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster) (string, error) {
+	values := []string{siteHost(vcdCluster.Site)}
+
+	if t.Insecure(vcdCluster.Site) {
+		values = append(values, "insecure")
+	} else {
+		values = append(values, string(t.CABundle))
+		if name := vcdCluster.GetAnnotations()[key.CABundleAnnotation]; name != "" {
+			bundle, err := clusterCABundle(ctx, c, types.NamespacedName{Namespace: vcdCluster.GetNamespace(), Name: name})
+			if err != nil {
+				return "", microerror.Mask(err)
+			}
+			values = append(values, string(bundle))
+		}
+	}
+
+	if p := t.siteProxy(vcdCluster.Site); p != nil {
+		values = append(values, p.URL, strings.Join(p.NoProxy, ","))
+		if p.CredentialsSecret != nil {
+			user, err := proxyCredentials(ctx, c, types.NamespacedName{Namespace: p.CredentialsSecret.Namespace, Name: p.CredentialsSecret.Name})
+			if err != nil {
+				return "", microerror.Mask(err)
+			}
+			values = append(values, user.String())
+		}
+	}
+
+	return hash(values...), nil
+}
+
+// roundTripper configures a transport of govcd or the swagger client, and
+// wraps it in the rate limit of the site.
+func (conn *connection) roundTripper(transport *http.Transport) http.RoundTripper {
+	transport.TLSClientConfig = conn.tls
+	transport.Proxy = conn.proxy
+
+	return &rateLimitedTransport{
+		base:              transport,
+		limiter:           conn.limiter,
+		managementCluster: conn.managementCluster,
+		site:              conn.site,
+	}
+}
+
+// GetVCDClient logs in to the site of vcdCluster with the credentials of its
+// secret, verifying the certificate of the site and going through its proxy
+// as configured.
+func (t *Transport) GetVCDClient(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error) {
+	userCreds, err := getUserCredentialsForCluster(ctx, c, vcdCluster.Credentials)
+	if err != nil {
+		log.V(1).Info("Error getting client credentials for vcd client", "vcdCluster", vcdCluster)
+		return nil, microerror.Mask(err)
+	}
+	conn, err := t.connection(ctx, c, vcdCluster, log)
+	if err != nil {
+		log.V(1).Info("Error configuring the connection of the vcd client", "vcdCluster", vcdCluster)
+		return nil, microerror.Mask(err)
+	}
+
+	var workloadVCDClient *vcdsdk.Client
+	if userCreds.ServiceAccountToken != "" {
+		workloadVCDClient, err = newServiceAccountClient(ctx, c, vcdCluster, userCreds.ServiceAccountToken, conn, log)
+	} else {
+		workloadVCDClient, err = newClient(vcdCluster, userCreds, conn)
+	}
+	if err != nil {
+		log.V(1).Info("Error creating VCD client", "vcdCluster", vcdCluster)
+		switch ClassifyClientError(err) {
+		case ReasonSiteUnreachable:
+			return nil, microerror.Maskf(siteUnreachableError, "site [%s]: %s", vcdCluster.Site, err)
+		case ReasonUntrustedCertificate:
+			return nil, microerror.Maskf(untrustedCertificateError, "site [%s]: %s", vcdCluster.Site, err)
+		case ReasonInvalidCredentials:
+			return nil, microerror.Maskf(invalidCredentialsError, "org [%s]: %s", vcdCluster.Org, err)
+		}
+		return nil, microerror.Mask(err)
+	}
+	return workloadVCDClient, nil
+}
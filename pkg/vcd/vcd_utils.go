@@ -30,22 +30,34 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	swaggerClient "github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdswaggerclient"
+	"github.com/vmware/go-vcloud-director/v2/govcd"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 )
 
-func getUserCredentialsForCluster(ctx context.Context, cli client.Client, definedCreds capvcd.UserCredentialsContext) (capvcd.UserCredentialsContext, error) {
+// getUserCredentialsForCluster reads the credentials of a cluster. The keys of
+// the secret select how it logs in: username and password, an api token in
+// apiToken or refreshToken, or the refresh token of a service account in
+// serviceAccountToken.
+func getUserCredentialsForCluster(ctx context.Context, cli client.Client, definedCreds vcdcluster.Credentials) (vcdcluster.Credentials, error) {
 	username, password, refreshToken := definedCreds.Username, definedCreds.Password, definedCreds.RefreshToken
+	serviceAccountToken := ""
 	if definedCreds.SecretRef != nil {
 		secretNamespacedName := types.NamespacedName{
 			Name:      definedCreds.SecretRef.Name,
 			Namespace: definedCreds.SecretRef.Namespace,
 		}
 		userCredsSecret := &v1.Secret{}
-		if err := cli.Get(ctx, secretNamespacedName, userCredsSecret); err != nil {
-			return capvcd.UserCredentialsContext{}, errors.Wrapf(err, "error getting secret [%s] in namespace [%s]",
+		if err := cli.Get(ctx, secretNamespacedName, userCredsSecret); apierrors.IsNotFound(err) {
+			return vcdcluster.Credentials{}, microerror.Maskf(secretNotFoundError, "secret [%s] in namespace [%s] not found",
+				secretNamespacedName.Name, secretNamespacedName.Namespace)
+		} else if err != nil {
+			return vcdcluster.Credentials{}, errors.Wrapf(err, "error getting secret [%s] in namespace [%s]",
 				secretNamespacedName.Name, secretNamespacedName.Namespace)
 		}
 		if b, exists := userCredsSecret.Data["username"]; exists {
@@ -57,41 +69,148 @@ func getUserCredentialsForCluster(ctx context.Context, cli client.Client, define
 		if b, exists := userCredsSecret.Data["refreshToken"]; exists {
 			refreshToken = strings.TrimRight(string(b), "\n")
 		}
+		if b, exists := userCredsSecret.Data["apiToken"]; exists {
+			refreshToken = strings.TrimRight(string(b), "\n")
+		}
+		if b, exists := userCredsSecret.Data[serviceAccountTokenKey]; exists {
+			serviceAccountToken = serviceAccountTokens.latest(secretNamespacedName, strings.TrimRight(string(b), "\n"))
+		}
 	}
-	userCredentials := capvcd.UserCredentialsContext{
-		Username:     username,
-		Password:     password,
-		RefreshToken: refreshToken,
+	userCredentials := vcdcluster.Credentials{
+		Username:            username,
+		Password:            password,
+		RefreshToken:        refreshToken,
+		ServiceAccountToken: serviceAccountToken,
 	}
 
 	return userCredentials, nil
 }
 
-// GetVCDClient a helper function for initializing vcd api client, it gets the credentials from the k8s secret
-func GetVCDClient(ctx context.Context, c client.Client, vcdCluster *capvcd.VCDCluster, log logr.Logger) (*vcdsdk.Client, error) {
-	userCreds, err := getUserCredentialsForCluster(ctx, c, vcdCluster.Spec.UserCredentialsContext)
+// GetVCDClient a helper function for initializing vcd api client, it gets the credentials from the k8s secret.
+// It connects to the site as DefaultTransport does.
+func GetVCDClient(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error) {
+	return DefaultTransport.GetVCDClient(ctx, c, vcdCluster, log)
+}
+
+// newClient logs in with a username and password or an api token, the way
+// vcdsdk.NewVCDClientFromSecrets does, over connections configured by conn.
+func newClient(vcdCluster *vcdcluster.Cluster, userCreds vcdcluster.Credentials, conn *connection) (*vcdsdk.Client, error) {
+	userOrg, username, err := vcdsdk.GetUserAndOrg(userCreds.Username, vcdCluster.Org, vcdCluster.Org)
 	if err != nil {
-		log.V(1).Info("Error getting client credentials for vcd client", "vcdCluster", vcdCluster)
-		return nil, microerror.Mask(err)
+		return nil, fmt.Errorf("error parsing username before authenticating to VCD: [%v]", err)
 	}
-	workloadVCDClient, err := vcdsdk.NewVCDClientFromSecrets(vcdCluster.Spec.Site, vcdCluster.Spec.Org,
-		vcdCluster.Spec.Ovdc, vcdCluster.Spec.Org, userCreds.Username, userCreds.Password, userCreds.RefreshToken, true, true)
+	authConfig := vcdsdk.NewVCDAuthConfigFromSecrets(vcdCluster.Site, username, userCreds.Password, userCreds.RefreshToken,
+		userOrg, conn.tls.InsecureSkipVerify)
+
+	vcdClient, err := newGovcdClient(vcdCluster.Site, conn)
 	if err != nil {
-		log.V(1).Info("Error creating VCD client", "vcdCluster", vcdCluster)
-		return nil, microerror.Mask(err)
+		return nil, err
+	}
+
+	if userCreds.RefreshToken != "" {
+		// A system administrator token only works in the system org, every
+		// other one in the org of the user.
+		if err := vcdClient.SetToken("system", govcd.ApiTokenHeader, userCreds.RefreshToken); err == nil {
+			authConfig.UserOrg = "system"
+		} else if err := vcdClient.SetToken(userOrg, govcd.ApiTokenHeader, userCreds.RefreshToken); err != nil {
+			return nil, fmt.Errorf("failed to set authorization header: [%v]", err)
+		}
+		authConfig.IsSysAdmin = vcdClient.Client.IsSysAdmin
+	} else {
+		resp, err := vcdClient.GetAuthResponse(username, userCreds.Password, userOrg)
+		if err != nil {
+			return nil, fmt.Errorf("unable to authenticate [%s/%s] for url [%s]: [%v]", userOrg, username, vcdCluster.Site, err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to authenticate with VCD with username [%s] and org [%s]: [%s]", username, userOrg, resp.Status)
+		}
 	}
-	return workloadVCDClient, nil
+
+	return newSDKClient(vcdCluster, authConfig, vcdClient, conn)
 }
 
-// GetGateway a helper function that creates and returns GatewayManager
-func GetGateway(ctx context.Context, vcdClient *vcdsdk.Client, vcdCluster *capvcd.VCDCluster) (*vcdsdk.GatewayManager, error) {
-	gateway, err := vcdsdk.NewGatewayManager(ctx, vcdClient, vcdCluster.Spec.OvdcNetwork, vcdCluster.Spec.LoadBalancerConfigSpec.VipSubnet)
+// newGovcdClient returns a client of the site that has not logged in yet.
+func newGovcdClient(site string, conn *connection) (*govcd.VCDClient, error) {
+	href := fmt.Sprintf("%s/api", site)
+	u, err := url.ParseRequestURI(href)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse url [%s]: [%v]", href, err)
+	}
+
+	vcdClient := govcd.NewVCDClient(*u, conn.tls.InsecureSkipVerify)
+	vcdClient.Client.APIVersion = vcdsdk.VCloudApiVersion
+	transport, ok := vcdClient.Client.Http.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("unexpected transport [%T] of the govcd client", vcdClient.Client.Http.Transport)
+	}
+	vcdClient.Client.Http.Transport = conn.roundTripper(transport)
+
+	return vcdClient, nil
+}
+
+// newSDKClient completes a logged in govcd client with the swagger client of
+// the cloudapi and the VDC of the cluster.
+func newSDKClient(vcdCluster *vcdcluster.Cluster, authConfig *vcdsdk.VCDAuthConfig, vcdClient *govcd.VCDClient, conn *connection) (*vcdsdk.Client, error) {
+	swaggerConfig := swaggerClient.NewConfiguration()
+	swaggerConfig.BasePath = fmt.Sprintf("%s/cloudapi", vcdCluster.Site)
+	swaggerConfig.AddDefaultHeader("Authorization", fmt.Sprintf("Bearer %s", vcdClient.Client.VCDToken))
+	swaggerConfig.HTTPClient = &http.Client{Transport: conn.roundTripper(&http.Transport{})}
+
+	org, err := vcdClient.GetOrgByName(vcdCluster.Org)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get org from name [%s]: [%v]", vcdCluster.Org, err)
+	}
+	vdc, err := org.GetVDCByName(vcdCluster.Ovdc, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get VDC [%s] from org [%s]: [%v]", vcdCluster.Ovdc, vcdCluster.Org, err)
+	}
+
+	return &vcdsdk.Client{
+		VCDAuthConfig:   authConfig,
+		ClusterOrgName:  vcdCluster.Org,
+		ClusterOVDCName: vcdCluster.Ovdc,
+		VCDClient:       vcdClient,
+		VDC:             vdc,
+		APIClient:       swaggerClient.NewAPIClient(swaggerConfig),
+	}, nil
+}
+
+// GetGateway a helper function that creates and returns the GatewayManager of
+// the network of a zone
+func GetGateway(ctx context.Context, vcdClient *vcdsdk.Client, zone vcdcluster.Zone) (*vcdsdk.GatewayManager, error) {
+	gateway, err := vcdsdk.NewGatewayManager(ctx, vcdClient, zone.OvdcNetwork, zone.VipSubnet)
 	if err != nil {
 		return nil, err
 	}
 	return gateway, nil
 }
 
+// ZoneClient returns a client working in the OVDC of the zone. It shares the
+// session of vcdClient, which is returned as is for a zone in its own OVDC.
+func ZoneClient(vcdClient *vcdsdk.Client, zone vcdcluster.Zone) (*vcdsdk.Client, error) {
+	if zone.Ovdc == vcdClient.ClusterOVDCName {
+		return vcdClient, nil
+	}
+
+	org, err := vcdClient.VCDClient.GetOrgByName(vcdClient.ClusterOrgName)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get org [%s]: [%v]", vcdClient.ClusterOrgName, err)
+	}
+	vdc, err := org.GetVDCByName(zone.Ovdc, true)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get vdc [%s] of org [%s]: [%v]", zone.Ovdc, vcdClient.ClusterOrgName, err)
+	}
+
+	return &vcdsdk.Client{
+		VCDAuthConfig:   vcdClient.VCDAuthConfig,
+		ClusterOrgName:  vcdClient.ClusterOrgName,
+		ClusterOVDCName: zone.Ovdc,
+		VCDClient:       vcdClient.VCDClient,
+		VDC:             vdc,
+		APIClient:       vcdClient.APIClient,
+	}, nil
+}
+
 // GetCursor handles the paging mechanism for queries that may return a lot of items
 // https://github.com/vmware/cloud-provider-for-cloud-director/blob/v1.2.0/pkg/vcdsdk/gateway.go#L199
 func GetCursor(resp *http.Response) (string, error) {
@@ -8,6 +8,7 @@ import (
 
 	"github.com/go-logr/logr"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	"github.com/vmware/go-vcloud-director/v2/govcd"
 	"github.com/vmware/go-vcloud-director/v2/types/v56"
 )
 
@@ -15,14 +16,33 @@ func GetDiskRecordsOfClusterByDescription(vcdClient *vcdsdk.Client, clusterId st
 	filter := "description==" + url.QueryEscape(clusterId)
 	params := map[string]string{"type": "disk", "filter": filter, "filterEncoded": "true"}
 
+	disks, err := queryDiskRecords(vcdClient, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disk records by description. description[%s] %v", clusterId, err)
+	}
+
+	return disks, nil
+}
+
+// GetAllDiskRecords lists every named disk the client can see.
+func GetAllDiskRecords(vcdClient *vcdsdk.Client) ([]*types.DiskRecordType, error) {
+	disks, err := queryDiskRecords(vcdClient, map[string]string{"type": "disk"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list disk records. %v", err)
+	}
+
+	return disks, nil
+}
+
+// queryDiskRecords walks every page of a disk query.
+func queryDiskRecords(vcdClient *vcdsdk.Client, params map[string]string) ([]*types.DiskRecordType, error) {
 	page := 1
 	disks := make([]*types.DiskRecordType, 0)
 	for {
 		params["page"] = strconv.Itoa(page)
 		results, err := vcdClient.VCDClient.QueryWithNotEncodedParams(nil, params)
 		if err != nil {
-			return nil, fmt.Errorf("failed to list disk records by description. description[%s] page[%d] [%v]",
-				clusterId, page, err)
+			return nil, fmt.Errorf("page[%d] [%v]", page, err)
 		}
 		disks = append(disks, results.Results.DiskRecord...)
 
@@ -44,23 +64,31 @@ func GetDiskByHref(vcdClient *vcdsdk.Client, diskHref string) (*types.Disk, erro
 	return disk, err
 }
 
-func DetachFromAllVms(vcdClient *vcdsdk.Client, vAppName string, disk *types.Disk, log logr.Logger) error {
+// DetachFromAllVms releases the disk from every vm holding it. It returns the
+// names of the vms the disk was detached from, also when it fails part way.
+func DetachFromAllVms(vcdClient *vcdsdk.Client, vAppName string, disk *types.Disk, log logr.Logger) ([]string, error) {
 	vdcManager, err := vcdsdk.NewVDCManager(vcdClient, vcdClient.ClusterOrgName, vcdClient.ClusterOVDCName)
 	if err != nil {
-		return fmt.Errorf("unable to initialize vdcManager: [%v]", err)
+		return nil, fmt.Errorf("unable to initialize vdcManager: [%v]", err)
 	}
 
-	vms, err := getAllAttachedVms(vcdClient, disk)
+	vms, err := GetAttachedVms(vcdClient, disk)
 	if err != nil {
-		return fmt.Errorf("unable to get attached VMs to disk:[%s] [%v]", disk.Name, err)
+		return nil, fmt.Errorf("unable to get attached VMs to disk:[%s] [%v]", disk.Name, err)
 	}
 
+	var detached []string
 	for _, vm := range vms {
 		log.Info(fmt.Sprintf("Detaching [%s] from [%s]", disk.Name, vm.Name))
-		err = detachDiskFromVM(vdcManager, vAppName, vm.Name, disk)
+		task, err := detachDiskFromVM(vdcManager, vAppName, vm.Name, disk)
+		if err != nil {
+			return detached, err
+		}
+		err = task.WaitTaskCompletion()
 		if err != nil {
-			return err
+			return detached, err
 		}
+		detached = append(detached, vm.Name)
 	}
 
 	// when there is a VM attached to the disk, there is no remove link on disk object initially.
@@ -68,14 +96,30 @@ func DetachFromAllVms(vcdClient *vcdsdk.Client, vAppName string, disk *types.Dis
 	if len(vms) > 0 {
 		refreshed, err := GetDiskByHref(vcdClient, disk.HREF)
 		if err != nil {
-			return err
+			return detached, err
 		}
 		*disk = *refreshed
 	}
-	return nil
+	return detached, nil
 }
 
 func DeleteDisk(vcdClient *vcdsdk.Client, disk *types.Disk) error {
+	task, err := StartDeleteDisk(vcdClient, disk)
+	if err != nil {
+		return err
+	}
+
+	err = task.WaitTaskCompletion()
+	if err != nil {
+		return fmt.Errorf("failed to wait for deletion task of disk [%s]: [%v]", disk.Name, err)
+	}
+
+	return nil
+}
+
+// StartDeleteDisk starts the deletion of the disk and returns its task
+// without waiting for it.
+func StartDeleteDisk(vcdClient *vcdsdk.Client, disk *types.Disk) (govcd.Task, error) {
 	var deleteDiskLink *types.Link
 
 	// Find the proper link for request
@@ -87,7 +131,7 @@ func DeleteDisk(vcdClient *vcdsdk.Client, disk *types.Disk) error {
 	}
 
 	if deleteDiskLink == nil {
-		return fmt.Errorf("could not find request URL for delete disk in disk Link")
+		return govcd.Task{}, fmt.Errorf("could not find request URL for delete disk in disk Link")
 	}
 
 	// Return the task
@@ -95,19 +139,66 @@ func DeleteDisk(vcdClient *vcdsdk.Client, disk *types.Disk) error {
 		"", "error delete disk: %s", nil,
 		vcdClient.VCDClient.Client.APIVersion)
 	if err != nil {
-		return fmt.Errorf("failed to execute deletion task of disk [%s]: [%v]", disk.Name, err)
+		return govcd.Task{}, fmt.Errorf("failed to execute deletion task of disk [%s]: [%v]", disk.Name, err)
+	}
+
+	return task, nil
+}
+
+// UpdateDisk renames the disk and replaces its description. The disk must not
+// be attached to any vm.
+func UpdateDisk(vcdClient *vcdsdk.Client, disk *types.Disk, name, description string) error {
+	task, err := StartUpdateDisk(vcdClient, disk, name, description)
+	if err != nil {
+		return err
 	}
 
 	err = task.WaitTaskCompletion()
 	if err != nil {
-		return fmt.Errorf("failed to wait for deletion task of disk [%s]: [%v]", disk.Name, err)
+		return fmt.Errorf("failed to wait for update task of disk [%s]: [%v]", disk.Name, err)
 	}
 
 	return nil
 }
 
+// StartUpdateDisk starts UpdateDisk and returns its task without waiting for
+// it.
+func StartUpdateDisk(vcdClient *vcdsdk.Client, disk *types.Disk, name, description string) (govcd.Task, error) {
+	var editDiskLink *types.Link
+
+	// Find the proper link for request
+	for _, diskLink := range disk.Link {
+		if diskLink.Rel == types.RelEdit && diskLink.Type == types.MimeDisk {
+			editDiskLink = diskLink
+			break
+		}
+	}
+
+	if editDiskLink == nil {
+		return govcd.Task{}, fmt.Errorf("could not find request URL for update disk in disk Link")
+	}
+
+	payload := &types.Disk{
+		Xmlns:          types.XMLNamespaceVCloud,
+		Name:           name,
+		Description:    description,
+		SizeMb:         disk.SizeMb,
+		StorageProfile: disk.StorageProfile,
+	}
+
+	task, err := vcdClient.VCDClient.Client.ExecuteTaskRequestWithApiVersion(editDiskLink.HREF, http.MethodPut,
+		editDiskLink.Type, "error updating disk: %s", payload,
+		vcdClient.VCDClient.Client.APIVersion)
+	if err != nil {
+		return govcd.Task{}, fmt.Errorf("failed to execute update task of disk [%s]: [%v]", disk.Name, err)
+	}
+
+	return task, nil
+}
+
+// GetAttachedVms lists the vms holding the disk.
 // inspired from https://github.com/vmware/cloud-director-named-disk-csi-driver/blob/6e3b7b79efdced300b4bd65dcdc98b07658fbfe7/pkg/vcdcsiclient/disks.go#L293
-func getAllAttachedVms(vcdClient *vcdsdk.Client, disk *types.Disk) ([]*types.Reference, error) {
+func GetAttachedVms(vcdClient *vcdsdk.Client, disk *types.Disk) ([]*types.Reference, error) {
 	var attachedVMLink *types.Link
 
 	// Find the proper link for request
@@ -131,12 +222,23 @@ func getAllAttachedVms(vcdClient *vcdsdk.Client, disk *types.Disk) ([]*types.Ref
 	return attachedVMs.VmReference, err
 }
 
+// StartDetachDiskFromVM starts releasing the disk from one vm of the vApp and
+// returns its task without waiting for it.
+func StartDetachDiskFromVM(vcdClient *vcdsdk.Client, vAppName string, vmName string, disk *types.Disk) (govcd.Task, error) {
+	vdcManager, err := vcdsdk.NewVDCManager(vcdClient, vcdClient.ClusterOrgName, vcdClient.ClusterOVDCName)
+	if err != nil {
+		return govcd.Task{}, fmt.Errorf("unable to initialize vdcManager: [%v]", err)
+	}
+
+	return detachDiskFromVM(vdcManager, vAppName, vmName, disk)
+}
+
 // inspired from https://github.com/vmware/cloud-director-named-disk-csi-driver/blob/6e3b7b79efdced300b4bd65dcdc98b07658fbfe7/pkg/vcdcsiclient/disks.go#L514
-func detachDiskFromVM(vdcManager *vcdsdk.VdcManager, vAppName string, vmName string, disk *types.Disk) error {
+func detachDiskFromVM(vdcManager *vcdsdk.VdcManager, vAppName string, vmName string, disk *types.Disk) (govcd.Task, error) {
 
 	vm, err := vdcManager.FindVMByName(vAppName, vmName)
 	if err != nil {
-		return fmt.Errorf("unable to get vm: [%v]", err)
+		return govcd.Task{}, fmt.Errorf("unable to get vm: [%v]", err)
 	}
 
 	params := &types.DiskAttachOrDetachParams{
@@ -144,10 +246,10 @@ func detachDiskFromVM(vdcManager *vcdsdk.VdcManager, vAppName string, vmName str
 	}
 	task, err := vm.DetachDisk(params)
 	if err != nil {
-		return fmt.Errorf("unable to detack disk [%s] from vm[%s]  [%v]", disk.Name, vmName, err)
+		return govcd.Task{}, fmt.Errorf("unable to detack disk [%s] from vm[%s]  [%v]", disk.Name, vmName, err)
 	}
 
-	return task.WaitTaskCompletion()
+	return task, nil
 }
 
 func nextPageExists(links []*types.Link) bool {
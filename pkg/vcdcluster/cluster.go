@@ -0,0 +1,152 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package vcdcluster describes a CAPVCD VCDCluster independently of the api
+// version it was read in, so the cleaners work on v1beta1 and v1beta2 alike.
+package vcdcluster
+
+import (
+	capvcdv1beta1 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	capvcdv1beta2 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/microerror"
+)
+
+// Cluster is what the cleaner needs to know about a VCDCluster.
+type Cluster struct {
+	// Object is the metadata of the VCDCluster it was built from. Finalizers
+	// and annotations set on it are written with Update or Patch on
+	// ClientObject.
+	metav1.Object
+
+	Site        string
+	Org         string
+	Ovdc        string
+	OvdcNetwork string
+	// VipSubnet is the subnet the virtual IPs of load balancers are taken from.
+	VipSubnet   string
+	Credentials Credentials
+	Status      Status
+
+	// ZoneConfigMap names the ConfigMap, in the namespace of the VCDCluster,
+	// with the zone topology of a multi-zone cluster. LoadZones reads it into
+	// SpecZones.
+	ZoneConfigMap string
+	SpecZones     []Zone
+
+	obj client.Object
+}
+
+// Credentials are the credentials a VCDCluster logs in to VCD with. The ones
+// in the SecretRef secret take precedence.
+type Credentials struct {
+	Username string
+	Password string
+	// RefreshToken is a VCD api token. It is used instead of the username and
+	// password when set.
+	RefreshToken string
+	// ServiceAccountToken is the refresh token of a VCD service account. VCD
+	// replaces it on every use, so it can only come from the SecretRef secret,
+	// where the new one is written back. It takes precedence over the others.
+	ServiceAccountToken string
+	SecretRef           *corev1.SecretReference
+}
+
+// Status is what CAPVCD recorded in the status of the VCDCluster.
+type Status struct {
+	InfraId string
+	Org     string
+}
+
+// ClientObject returns the VCDCluster in the api version it was read in.
+func (c *Cluster) ClientObject() client.Object {
+	return c.obj
+}
+
+// DeepCopy copies the Cluster and the VCDCluster it was built from.
+func (c *Cluster) DeepCopy() *Cluster {
+	out := *c
+	out.obj = c.obj.DeepCopyObject().(client.Object)
+	out.Object = out.obj
+	if c.Credentials.SecretRef != nil {
+		ref := *c.Credentials.SecretRef
+		out.Credentials.SecretRef = &ref
+	}
+	out.SpecZones = append([]Zone(nil), c.SpecZones...)
+	return &out
+}
+
+// New builds the Cluster of a VCDCluster of any supported api version.
+func New(obj client.Object) (*Cluster, error) {
+	switch o := obj.(type) {
+	case *capvcdv1beta1.VCDCluster:
+		return FromV1beta1(o), nil
+	case *capvcdv1beta2.VCDCluster:
+		return FromV1beta2(o), nil
+	}
+
+	return nil, microerror.Maskf(unsupportedVersionError, "%T is not a supported VCDCluster", obj)
+}
+
+// FromV1beta1 builds the Cluster of a v1beta1 VCDCluster.
+func FromV1beta1(o *capvcdv1beta1.VCDCluster) *Cluster {
+	return &Cluster{
+		Object:      o,
+		Site:        o.Spec.Site,
+		Org:         o.Spec.Org,
+		Ovdc:        o.Spec.Ovdc,
+		OvdcNetwork: o.Spec.OvdcNetwork,
+		VipSubnet:   o.Spec.LoadBalancerConfigSpec.VipSubnet,
+		Credentials: Credentials{
+			Username:     o.Spec.UserCredentialsContext.Username,
+			Password:     o.Spec.UserCredentialsContext.Password,
+			RefreshToken: o.Spec.UserCredentialsContext.RefreshToken,
+			SecretRef:    o.Spec.UserCredentialsContext.SecretRef,
+		},
+		Status: Status{
+			InfraId: o.Status.InfraId,
+			Org:     o.Status.Org,
+		},
+		obj: o,
+	}
+}
+
+// FromV1beta2 builds the Cluster of a v1beta2 VCDCluster.
+func FromV1beta2(o *capvcdv1beta2.VCDCluster) *Cluster {
+	return &Cluster{
+		Object:      o,
+		Site:        o.Spec.Site,
+		Org:         o.Spec.Org,
+		Ovdc:        o.Spec.Ovdc,
+		OvdcNetwork: o.Spec.OvdcNetwork,
+		VipSubnet:   o.Spec.LoadBalancerConfigSpec.VipSubnet,
+		Credentials: Credentials{
+			Username:     o.Spec.UserCredentialsContext.Username,
+			Password:     o.Spec.UserCredentialsContext.Password,
+			RefreshToken: o.Spec.UserCredentialsContext.RefreshToken,
+			SecretRef:    o.Spec.UserCredentialsContext.SecretRef,
+		},
+		Status: Status{
+			InfraId: o.Status.InfraId,
+			Org:     o.Status.Org,
+		},
+		ZoneConfigMap: o.Spec.OvdcZoneConfigMapName,
+		obj:           o,
+	}
+}
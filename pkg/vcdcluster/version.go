@@ -0,0 +1,159 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcdcluster
+
+import (
+	"context"
+
+	capvcdv1beta1 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	capvcdv1beta2 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/giantswarm/microerror"
+)
+
+// CRDName is the name of the CustomResourceDefinition of VCDClusters.
+const CRDName = "vcdclusters.infrastructure.cluster.x-k8s.io"
+
+// Version is an api version VCDClusters are read, listed and watched in. A nil
+// Version is V1beta1.
+type Version struct {
+	GroupVersion schema.GroupVersion
+
+	newObject func() client.Object
+	newList   func() client.ObjectList
+	items     func(list client.ObjectList) []client.Object
+}
+
+var (
+	V1beta1 = &Version{
+		GroupVersion: capvcdv1beta1.GroupVersion,
+		newObject:    func() client.Object { return &capvcdv1beta1.VCDCluster{} },
+		newList:      func() client.ObjectList { return &capvcdv1beta1.VCDClusterList{} },
+		items: func(list client.ObjectList) []client.Object {
+			items := list.(*capvcdv1beta1.VCDClusterList).Items
+			objects := make([]client.Object, 0, len(items))
+			for i := range items {
+				objects = append(objects, &items[i])
+			}
+			return objects
+		},
+	}
+	V1beta2 = &Version{
+		GroupVersion: capvcdv1beta2.GroupVersion,
+		newObject:    func() client.Object { return &capvcdv1beta2.VCDCluster{} },
+		newList:      func() client.ObjectList { return &capvcdv1beta2.VCDClusterList{} },
+		items: func(list client.ObjectList) []client.Object {
+			items := list.(*capvcdv1beta2.VCDClusterList).Items
+			objects := make([]client.Object, 0, len(items))
+			for i := range items {
+				objects = append(objects, &items[i])
+			}
+			return objects
+		},
+	}
+)
+
+// Versions are the supported api versions, oldest first.
+var Versions = []*Version{V1beta1, V1beta2}
+
+// NewObject returns an empty VCDCluster of the version, to watch it.
+func (v *Version) NewObject() client.Object {
+	return v.orDefault().newObject()
+}
+
+// Get reads the VCDCluster named key.
+func (v *Version) Get(ctx context.Context, c client.Reader, key client.ObjectKey) (*Cluster, error) {
+	obj := v.orDefault().newObject()
+	if err := c.Get(ctx, key, obj); err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	return New(obj)
+}
+
+// List lists the VCDClusters matching opts.
+func (v *Version) List(ctx context.Context, c client.Reader, opts ...client.ListOption) ([]*Cluster, error) {
+	v = v.orDefault()
+	list := v.newList()
+	if err := c.List(ctx, list, opts...); err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	var clusters []*Cluster
+	for _, obj := range v.items(list) {
+		cluster, err := New(obj)
+		if err != nil {
+			return nil, microerror.Mask(err)
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	return clusters, nil
+}
+
+func (v *Version) orDefault() *Version {
+	if v == nil {
+		return V1beta1
+	}
+	return v
+}
+
+// StorageVersion returns the version VCDClusters are stored in, so that
+// reading them needs no conversion by the CAPVCD webhook. When the storage
+// version is not supported it falls back to the newest supported version the
+// CRD serves.
+func StorageVersion(ctx context.Context, c client.Reader) (*Version, error) {
+	var crd apiextensionsv1.CustomResourceDefinition
+	if err := c.Get(ctx, client.ObjectKey{Name: CRDName}, &crd); err != nil {
+		return nil, microerror.Mask(err)
+	}
+
+	served := map[string]bool{}
+	for _, version := range crd.Spec.Versions {
+		if !version.Served {
+			continue
+		}
+		if version.Storage {
+			if v, ok := lookup(version.Name); ok {
+				return v, nil
+			}
+		}
+		served[version.Name] = true
+	}
+
+	for i := len(Versions) - 1; i >= 0; i-- {
+		if served[Versions[i].GroupVersion.Version] {
+			return Versions[i], nil
+		}
+	}
+
+	return nil, microerror.Maskf(unsupportedVersionError, "CRD %s serves no supported version", CRDName)
+}
+
+// lookup returns the supported version called name.
+func lookup(name string) (*Version, bool) {
+	for _, v := range Versions {
+		if v.GroupVersion.Version == name {
+			return v, true
+		}
+	}
+
+	return nil, false
+}
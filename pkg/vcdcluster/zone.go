@@ -0,0 +1,156 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcdcluster
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/giantswarm/microerror"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+)
+
+// Zone is one OVDC a cluster runs in, together with the network of the
+// cluster in it and so the edge gateway behind that network.
+type Zone struct {
+	// Name identifies the zone in reports. The zone of a single zone cluster
+	// has no name.
+	Name        string `json:"name"`
+	Ovdc        string `json:"ovdc"`
+	OvdcNetwork string `json:"ovdcNetwork"`
+	// VipSubnet defaults to the one of the cluster.
+	VipSubnet string `json:"vipSubnet,omitempty"`
+}
+
+// DefaultZone is the zone of the spec of the cluster, the one its VCD client
+// logs in to.
+func (c *Cluster) DefaultZone() Zone {
+	return Zone{
+		Ovdc:        c.Ovdc,
+		OvdcNetwork: c.OvdcNetwork,
+		VipSubnet:   c.VipSubnet,
+	}
+}
+
+// Zones lists every zone of the cluster. key.ZonesAnnotation overrides them,
+// it has to list the default zone too when the cluster keeps objects in it.
+// Without the annotation they are the SpecZones LoadZones read, and without
+// those the cluster has only its default zone.
+func (c *Cluster) Zones() ([]Zone, error) {
+	value, ok := c.GetAnnotations()[key.ZonesAnnotation]
+	if !ok {
+		if len(c.SpecZones) == 0 {
+			return []Zone{c.DefaultZone()}, nil
+		}
+		zones := make([]Zone, 0, len(c.SpecZones))
+		for _, zone := range c.SpecZones {
+			if zone.VipSubnet == "" {
+				zone.VipSubnet = c.VipSubnet
+			}
+			zones = append(zones, zone)
+		}
+		return zones, nil
+	}
+
+	var zones []Zone
+	if err := json.Unmarshal([]byte(value), &zones); err != nil {
+		return nil, microerror.Maskf(invalidZonesError, "annotation [%s]: %s", key.ZonesAnnotation, err)
+	}
+	if len(zones) == 0 {
+		return nil, microerror.Maskf(invalidZonesError, "annotation [%s] lists no zone", key.ZonesAnnotation)
+	}
+
+	seen := map[string]bool{}
+	for i, zone := range zones {
+		if zone.Name == "" || zone.Ovdc == "" || zone.OvdcNetwork == "" {
+			return nil, microerror.Maskf(invalidZonesError, "annotation [%s]: zone %d needs a name, an ovdc and an ovdcNetwork", key.ZonesAnnotation, i)
+		}
+		if seen[zone.Name] {
+			return nil, microerror.Maskf(invalidZonesError, "annotation [%s]: zone [%s] is listed twice", key.ZonesAnnotation, zone.Name)
+		}
+		seen[zone.Name] = true
+
+		if zone.VipSubnet == "" {
+			zones[i].VipSubnet = c.VipSubnet
+		}
+	}
+
+	return zones, nil
+}
+
+// ovdcZones is the zone topology CAPVCD reads from the ConfigMap named by
+// ovdcZoneConfigMapName in the spec of a multi-zone v1beta2 VCDCluster.
+type ovdcZones struct {
+	Zones []struct {
+		Name                  string `json:"name"`
+		OvdcName              string `json:"ovdcName"`
+		OvdcNetworkName       string `json:"ovdcNetworkName"`
+		LoadBalancerVipSubnet string `json:"loadBalancerVIPSubnet,omitempty"`
+	} `json:"zones"`
+}
+
+// LoadZones sets the SpecZones of the cluster from the zone topology of its
+// spec. It does nothing for a cluster with a single zone.
+func LoadZones(ctx context.Context, c client.Reader, cluster *Cluster) error {
+	if cluster.ZoneConfigMap == "" {
+		return nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	name := types.NamespacedName{Namespace: cluster.GetNamespace(), Name: cluster.ZoneConfigMap}
+	if err := c.Get(ctx, name, cm); err != nil {
+		return microerror.Mask(err)
+	}
+
+	// The ConfigMap holds a single yaml document, whatever its key.
+	keys := make([]string, 0, len(cm.Data))
+	for k := range cm.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		var topology ovdcZones
+		if err := yaml.Unmarshal([]byte(cm.Data[k]), &topology); err != nil || len(topology.Zones) == 0 {
+			continue
+		}
+
+		zones := make([]Zone, 0, len(topology.Zones))
+		seen := map[string]bool{}
+		for i, z := range topology.Zones {
+			if z.Name == "" || z.OvdcName == "" || z.OvdcNetworkName == "" {
+				return microerror.Maskf(invalidZonesError, "ConfigMap [%s] key [%s]: zone %d needs a name, an ovdcName and an ovdcNetworkName", name, k, i)
+			}
+			if seen[z.Name] {
+				return microerror.Maskf(invalidZonesError, "ConfigMap [%s] key [%s]: zone [%s] is listed twice", name, k, z.Name)
+			}
+			seen[z.Name] = true
+			zones = append(zones, Zone{Name: z.Name, Ovdc: z.OvdcName, OvdcNetwork: z.OvdcNetworkName, VipSubnet: z.LoadBalancerVipSubnet})
+		}
+		cluster.SpecZones = zones
+
+		return nil
+	}
+
+	return microerror.Maskf(invalidZonesError, "ConfigMap [%s] lists no zone", name)
+}
@@ -0,0 +1,208 @@
+//go:build integration
+
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/audit"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/test/integration/vcdfake"
+)
+
+// auditWebhook records the audit records posted to it.
+type auditWebhook struct {
+	mu      sync.Mutex
+	records []audit.Record
+}
+
+func (w *auditWebhook) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	var r audit.Record
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.records = append(w.records, r)
+}
+
+func (w *auditWebhook) Records() []audit.Record {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]audit.Record(nil), w.records...)
+}
+
+func readAuditLog(t *testing.T, path string) []audit.Record {
+	t.Helper()
+	g := gomega.NewWithT(t)
+
+	file, err := os.Open(path)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer file.Close()
+
+	var records []audit.Record
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r audit.Record
+		g.Expect(json.Unmarshal(scanner.Bytes(), &r)).To(gomega.Succeed())
+		records = append(records, r)
+	}
+	g.Expect(scanner.Err()).NotTo(gomega.HaveOccurred())
+	return records
+}
+
+func TestCleanersWriteAuditRecords(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	controlPlane := "dnat-" + controlPlaneName(name, infraId)
+	ingress := "dnat-" + ingressName("ingress-vs-", "nginx", infraId, "http")
+
+	_, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		NatRules: []vcdfake.Resource{
+			{ID: "nat-1", Name: controlPlane},
+			{ID: "nat-2", Name: ingress},
+		},
+		FailDeletes: []string{ingress},
+	})
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	fileSink, err := audit.NewFileSink(path)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	defer fileSink.Close()
+
+	webhook := &auditWebhook{}
+	webhookServer := httptest.NewServer(webhook)
+	defer webhookServer.Close()
+
+	sink := audit.Sinks{fileSink, &audit.WebhookSink{URL: webhookServer.URL}}
+	observer := audit.NewObserver(sink, logr.Discard(), "test-mc", client, vcdCluster, cleaner.DNATCleanerName)
+	ctx = cleaner.WithObserver(ctx, observer)
+
+	_, err = cleaner.NewDNATCleaner(k8sClient).Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	record := func(id, objectName, outcome string) gomega.OmegaMatcher {
+		return gomega.And(
+			gomega.HaveField("ManagementCluster", "test-mc"),
+			gomega.HaveField("User", testOrgName+"/"+testUsername),
+			gomega.HaveField("Namespace", testNamespace),
+			gomega.HaveField("Cluster", name),
+			gomega.HaveField("InfraId", infraId),
+			gomega.HaveField("Org", testOrgName),
+			gomega.HaveField("Cleaner", cleaner.DNATCleanerName),
+			gomega.HaveField("Action", audit.ActionDelete),
+			gomega.HaveField("Kind", cleaner.KindDNATRule),
+			gomega.HaveField("ID", id),
+			gomega.HaveField("Name", objectName),
+			gomega.HaveField("Gateway", "fake-edge"),
+			gomega.HaveField("Outcome", outcome),
+			gomega.HaveField("Time", gomega.Not(gomega.BeZero())),
+		)
+	}
+	// Every call is recorded before it is made.
+	expected := gomega.ConsistOf(
+		record("nat-1", controlPlane, audit.OutcomeStarted),
+		record("nat-1", controlPlane, audit.OutcomeSucceeded),
+		record("nat-2", ingress, audit.OutcomeStarted),
+		gomega.And(record("nat-2", ingress, audit.OutcomeFailed), gomega.HaveField("Error", gomega.Not(gomega.BeEmpty()))),
+	)
+
+	g.Expect(readAuditLog(t, path)).To(expected)
+	g.Expect(webhook.Records()).To(expected)
+}
+
+// failingSink refuses every record.
+type failingSink struct{}
+
+func (failingSink) Write(ctx context.Context, r audit.Record) error {
+	return errors.New("audit log is unavailable")
+}
+
+func TestCleanersDoNotDeleteWithoutAuditRecord(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		NatRules: []vcdfake.Resource{
+			{ID: "nat-1", Name: "dnat-" + controlPlaneName(name, infraId)},
+		},
+	})
+
+	observer := audit.NewObserver(failingSink{}, logr.Discard(), "test-mc", client, vcdCluster, cleaner.DNATCleanerName)
+	ctx = cleaner.WithObserver(ctx, observer)
+
+	_, err := cleaner.NewDNATCleaner(k8sClient).Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(server.DeletedNatRules()).To(gomega.BeEmpty())
+}
+
+func TestBufferedSinkRetriesInTheBackground(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The webhook is down for the first two attempts.
+	webhook := &auditWebhook{}
+	var attempts atomic.Int32
+	webhookServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if attempts.Add(1) <= 2 {
+			http.Error(rw, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		webhook.ServeHTTP(rw, req)
+	}))
+	defer webhookServer.Close()
+
+	sink := audit.NewBufferedSink(&audit.WebhookSink{URL: webhookServer.URL}, 1, logr.Discard())
+	sink.Backoff = wait.Backoff{Duration: 10 * time.Millisecond, Factor: 1, Steps: 5}
+
+	// Nothing writes the buffer before the sink is started.
+	g.Expect(sink.Write(ctx, audit.Record{Name: "first"})).To(gomega.Succeed())
+	g.Expect(audit.IsBufferFull(sink.Write(ctx, audit.Record{Name: "second"}))).To(gomega.BeTrue())
+
+	go func() {
+		_ = sink.Start(ctx)
+	}()
+
+	g.Eventually(webhook.Records).Should(gomega.ConsistOf(gomega.HaveField("Name", "first")))
+	g.Expect(attempts.Load()).To(gomega.Equal(int32(3)))
+}
@@ -0,0 +1,151 @@
+//go:build integration
+
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/onsi/gomega"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleanerctl"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/test/integration/vcdfake"
+)
+
+// newCleanerctlFixture starts a fake VCD and returns the options that clean
+// it up. No VCDCluster is created, cleanerctl does not need one.
+func newCleanerctlFixture(t *testing.T) (*vcdfake.Server, cleanerctl.Options) {
+	t.Helper()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	server := newVCDServer(t, vcdfake.Config{
+		VAppName: name,
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-one", Description: infraId, AttachedVM: "node-0"},
+			{ID: "disk-2", Name: "pvc-other", Description: otherInfraId},
+		}},
+		NatRules: []vcdfake.Resource{
+			{ID: "nat-1", Name: "dnat-" + controlPlaneName(name, infraId)},
+			{ID: "nat-2", Name: "dnat-" + controlPlaneName("other", otherInfraId)},
+		},
+	})
+
+	return server, cleanerctl.Options{
+		Site:     server.URL(),
+		Org:      testOrgName,
+		Ovdc:     testVdcName,
+		Network:  testNetworkName,
+		VApp:     name,
+		InfraId:  infraId,
+		Username: testUsername,
+		Password: testPassword,
+		// The fake VCD runs tasks right away.
+		PollInterval: 10 * time.Millisecond,
+		Log:          logr.Discard(),
+	}
+}
+
+func TestCleanerctlDryRun(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	server, opts := newCleanerctlFixture(t)
+	opts.DryRun = true
+	opts.JSON = true
+
+	var stdout, stderr bytes.Buffer
+	g.Expect(cleanerctl.Run(ctx, opts, strings.NewReader(""), &stdout, &stderr)).To(gomega.Succeed())
+
+	var result cleanerctl.Result
+	g.Expect(json.Unmarshal(stdout.Bytes(), &result)).To(gomega.Succeed())
+	g.Expect(result.InfraId).To(gomega.Equal(opts.InfraId))
+	g.Expect(result.DryRun).To(gomega.BeTrue())
+	g.Expect(result.Objects).To(gomega.ConsistOf(
+		gomega.And(gomega.HaveField("Kind", cleaner.KindDisk), gomega.HaveField("ID", "urn:vcloud:disk:disk-1"), gomega.HaveField("Outcome", cleanerctl.OutcomePlanned)),
+		gomega.And(gomega.HaveField("Kind", cleaner.KindDNATRule), gomega.HaveField("ID", "nat-1"), gomega.HaveField("Outcome", cleanerctl.OutcomePlanned)),
+	))
+
+	g.Expect(server.DeletedDisks()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedNatRules()).To(gomega.BeEmpty())
+}
+
+func TestCleanerctlAsksBeforeDeleting(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	server, opts := newCleanerctlFixture(t)
+
+	var stdout, stderr bytes.Buffer
+	err := cleanerctl.Run(ctx, opts, strings.NewReader("n\n"), &stdout, &stderr)
+	g.Expect(cleanerctl.IsAborted(err)).To(gomega.BeTrue())
+
+	// The plan and the question go to stderr.
+	g.Expect(stderr.String()).To(gomega.ContainSubstring("pvc-one"))
+	g.Expect(stderr.String()).To(gomega.ContainSubstring("Delete these 2 VCD objects"))
+	g.Expect(stdout.String()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedDisks()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedNatRules()).To(gomega.BeEmpty())
+}
+
+func TestCleanerctlDeletes(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	server, opts := newCleanerctlFixture(t)
+	opts.JSON = true
+
+	var stdout, stderr bytes.Buffer
+	g.Expect(cleanerctl.Run(ctx, opts, strings.NewReader("yes\n"), &stdout, &stderr)).To(gomega.Succeed())
+
+	var result cleanerctl.Result
+	g.Expect(json.Unmarshal(stdout.Bytes(), &result)).To(gomega.Succeed())
+	g.Expect(result.DryRun).To(gomega.BeFalse())
+	g.Expect(result.Error).To(gomega.BeEmpty())
+	g.Expect(result.Objects).To(gomega.ConsistOf(
+		gomega.And(gomega.HaveField("ID", "urn:vcloud:disk:disk-1"), gomega.HaveField("Outcome", cleanerctl.OutcomeDetached), gomega.HaveField("VM", "node-0")),
+		gomega.And(gomega.HaveField("ID", "urn:vcloud:disk:disk-1"), gomega.HaveField("Outcome", cleanerctl.OutcomeDeleted)),
+		gomega.And(gomega.HaveField("ID", "nat-1"), gomega.HaveField("Outcome", cleanerctl.OutcomeDeleted), gomega.HaveField("Gateway", "fake-edge")),
+	))
+
+	// Objects of other clusters are left alone.
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-one"))
+	g.Expect(server.DeletedNatRules()).To(gomega.HaveLen(1))
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+func TestCleanerctlRefusesInvalidInfraId(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	server, opts := newCleanerctlFixture(t)
+	opts.InfraId = "abc"
+	opts.Yes = true
+
+	var stdout, stderr bytes.Buffer
+	err := cleanerctl.Run(ctx, opts, strings.NewReader(""), &stdout, &stderr)
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(server.Requests()).To(gomega.BeEmpty())
+}
@@ -20,21 +20,25 @@ package integration
 
 import (
 	"context"
+	"crypto/sha1"
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/onsi/gomega"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
-	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
 
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/test/integration/vcdfake"
 )
 
 // newCleanerFixture starts a fake VCD, creates the VCDCluster that points at
 // it, and logs in. infraId is the marker the cleaners match resources on.
-func newCleanerFixture(t *testing.T, cfg vcdfake.Config) (*vcdfake.Server, *vcdsdk.Client, *capvcd.VCDCluster) {
+func newCleanerFixture(t *testing.T, cfg vcdfake.Config) (*vcdfake.Server, *vcdsdk.Client, *vcdcluster.Cluster) {
 	t.Helper()
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
@@ -45,7 +49,7 @@ func newCleanerFixture(t *testing.T, cfg vcdfake.Config) (*vcdfake.Server, *vcds
 
 	server := newVCDServer(t, cfg)
 
-	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, server.URL(), nil), infraIdFor(t))
+	vcdCluster := vcdcluster.FromV1beta1(createVCDCluster(t, ctx, newVCDCluster(name, server.URL(), nil), infraIdFor(t)))
 
 	client, err := vcd.GetVCDClient(ctx, k8sClient, vcdCluster, logr.Discard())
 	g.Expect(err).NotTo(gomega.HaveOccurred())
@@ -53,11 +57,29 @@ func newCleanerFixture(t *testing.T, cfg vcdfake.Config) (*vcdfake.Server, *vcds
 	return server, client, vcdCluster
 }
 
-// infraIdFor is the cluster marker VCD resource names carry.
+// infraIdFor is the infra id CAPVCD would give the cluster of the test. It is
+// derived from the test name so that tests can tell it before the VCDCluster
+// exists.
 func infraIdFor(t *testing.T) string {
 	t.Helper()
 
-	return "infra-" + uniqueName(t)
+	sum := sha1.Sum([]byte(uniqueName(t)))
+	return fmt.Sprintf("urn:vcloud:entity:vmware:capvcdCluster:%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// otherInfraId is the infra id of a cluster no test creates.
+const otherInfraId = "urn:vcloud:entity:vmware:capvcdCluster:00000000-0000-0000-0000-000000000000"
+
+// controlPlaneName is the name CAPVCD gives the virtual service and pool of
+// the control plane load balancer of a cluster.
+func controlPlaneName(clusterName, infraId string) string {
+	return clusterName + "-" + infraId + "-tcp"
+}
+
+// ingressName is the name the cloud provider gives the virtual service or pool
+// of a LoadBalancer service.
+func ingressName(prefix, service, infraId, port string) string {
+	return prefix + service + "-" + vcd.TrimInfraId(infraId) + "-" + port
 }
 
 func TestVolumeCleaner(t *testing.T) {
@@ -71,7 +93,7 @@ func TestVolumeCleaner(t *testing.T) {
 		DiskPages: [][]vcdfake.Disk{
 			{
 				{ID: "disk-1", Name: "pvc-one", Description: infraId, AttachedVM: "node-0"},
-				{ID: "disk-2", Name: "pvc-other", Description: "other-cluster"},
+				{ID: "disk-2", Name: "pvc-other", Description: otherInfraId},
 			},
 			{
 				{ID: "disk-3", Name: "pvc-two", Description: infraId},
@@ -93,11 +115,264 @@ func TestVolumeCleaner(t *testing.T) {
 	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
 }
 
+// renamedObjects collects the objects the cleaners report retained renamed.
+type renamedObjects struct {
+	renamed map[string]string
+}
+
+func (r *renamedObjects) ObjectDeleted(ctx context.Context, o cleaner.Object) {}
+
+func (r *renamedObjects) ObjectFailed(ctx context.Context, o cleaner.Object, err error) {}
+
+func (r *renamedObjects) ObjectRetainedRenamed(ctx context.Context, o cleaner.Object, renamed string) {
+	r.renamed[o.Name] = renamed
+}
+
+func TestVolumeCleanerRetainsDisksRenamed(t *testing.T) {
+	g := gomega.NewWithT(t)
+	infraId := infraIdFor(t)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-one", Description: infraId, AttachedVM: "node-0"},
+			{ID: "disk-2", Name: "pvc-two", Description: infraId},
+		}},
+	})
+	vcdCluster.SetAnnotations(map[string]string{key.DiskBackupAnnotation: cleaner.DiskBackupRetainRenamed})
+
+	renamed := &renamedObjects{renamed: map[string]string{}}
+	ctx := cleaner.WithObserver(context.Background(), renamed)
+	volumes := cleaner.NewVolumeCleaner(k8sClient)
+
+	// The plan tells the disks are retained renamed rather than deleted.
+	plan, err := volumes.Plan(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(plan).To(gomega.HaveLen(2))
+	for _, o := range plan {
+		g.Expect(o.Action).To(gomega.Equal(cleaner.OperationRetainRenamed), o.Name)
+	}
+
+	requeue, err := volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.BeFalse())
+
+	// The disks are released and renamed, not deleted.
+	g.Expect(server.DetachedDisks()).To(gomega.Equal([]string{"pvc-one"}))
+	g.Expect(server.DeletedDisks()).To(gomega.BeEmpty())
+	disks := server.Disks()
+	g.Expect(disks).To(gomega.HaveLen(2))
+	for _, disk := range disks {
+		g.Expect(disk.Name).To(gomega.HavePrefix("retained-pvc-"))
+		g.Expect(disk.Description).To(gomega.ContainSubstring(vcdCluster.GetName()))
+		g.Expect(disk.Description).NotTo(gomega.ContainSubstring(infraId))
+		_, found := vcd.FindInfraId(disk.Description)
+		g.Expect(found).To(gomega.BeFalse())
+	}
+	g.Expect(renamed.renamed).To(gomega.Equal(map[string]string{
+		"pvc-one": "retained-pvc-one (urn:vcloud:disk:disk-1)",
+		"pvc-two": "retained-pvc-two (urn:vcloud:disk:disk-2)",
+	}))
+
+	// The renamed disks no longer belong to the cluster.
+	withoutAnnotation := vcdCluster.DeepCopy()
+	withoutAnnotation.SetAnnotations(nil)
+	plan, err = volumes.Plan(ctx, logr.Discard(), client, withoutAnnotation)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(plan).To(gomega.BeEmpty())
+
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+func TestVolumeCleanerDiskBackupAnnotationOverridesDefault(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	infraId := infraIdFor(t)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{{ID: "disk-1", Name: "pvc-one", Description: infraId}}},
+	})
+	volumes := cleaner.NewVolumeCleaner(k8sClient)
+	volumes.DiskBackup = cleaner.DiskBackupRetainRenamed
+
+	// An unknown mode is refused rather than read as none.
+	vcdCluster.SetAnnotations(map[string]string{key.DiskBackupAnnotation: "snapshot"})
+	_, err := volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(cleaner.IsInvalidDiskBackup(err)).To(gomega.BeTrue())
+	g.Expect(server.Disks()[0].Name).To(gomega.Equal("pvc-one"))
+
+	vcdCluster.SetAnnotations(map[string]string{key.DiskBackupAnnotation: cleaner.DiskBackupNone})
+	_, err = volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-one"))
+}
+
+// taskOutcomes collects what the cleaners report, in order.
+type taskOutcomes struct {
+	outcomes []string
+}
+
+func (o *taskOutcomes) ObjectDeleted(ctx context.Context, obj cleaner.Object) {
+	o.outcomes = append(o.outcomes, "deleted "+obj.Name)
+}
+
+func (o *taskOutcomes) ObjectFailed(ctx context.Context, obj cleaner.Object, err error) {
+	o.outcomes = append(o.outcomes, "failed "+obj.Name)
+}
+
+func (o *taskOutcomes) ObjectDetached(ctx context.Context, obj cleaner.Object, vmName string) {
+	o.outcomes = append(o.outcomes, "detached "+obj.Name+" from "+vmName)
+}
+
+func (o *taskOutcomes) ObjectRetained(ctx context.Context, obj cleaner.Object) {
+	o.outcomes = append(o.outcomes, "retained "+obj.Name)
+}
+
+func TestVolumeCleanerPollsTasksAcrossRuns(t *testing.T) {
+	g := gomega.NewWithT(t)
+	infraId := infraIdFor(t)
+
+	// The first poll finds the detach task still running.
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-one", Description: infraId, AttachedVM: "node-0"},
+			{ID: "disk-2", Name: "pvc-two", Description: infraId},
+		}},
+		RunningTaskPolls: 1,
+	})
+
+	outcomes := &taskOutcomes{}
+	tasks := cleaner.NewTasks(nil)
+	ctx := cleaner.WithTasks(cleaner.WithObserver(context.Background(), outcomes), tasks)
+	volumes := cleaner.NewVolumeCleaner(k8sClient)
+
+	operations := func() []string {
+		var operations []string
+		for _, task := range tasks.List() {
+			operations = append(operations, task.Operation+" "+task.Object.Name)
+		}
+		return operations
+	}
+
+	// The tasks are started, nothing is reported before they finish.
+	requeue, err := volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.BeTrue())
+	g.Expect(operations()).To(gomega.Equal([]string{"detach pvc-one", "delete pvc-two"}))
+	g.Expect(outcomes.outcomes).To(gomega.BeEmpty())
+
+	// The disk being detached is left alone until its task is done.
+	requeue, err = volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.BeTrue())
+	g.Expect(operations()).To(gomega.Equal([]string{"detach pvc-one"}))
+	g.Expect(outcomes.outcomes).To(gomega.Equal([]string{"deleted pvc-two"}))
+	g.Expect(server.DeletedDisks()).To(gomega.Equal([]string{"pvc-two"}))
+
+	requeue, err = volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.BeTrue())
+	g.Expect(operations()).To(gomega.Equal([]string{"delete pvc-one"}))
+
+	requeue, err = volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.BeFalse())
+	g.Expect(tasks.List()).To(gomega.BeEmpty())
+	g.Expect(outcomes.outcomes).To(gomega.Equal([]string{
+		"deleted pvc-two",
+		"detached pvc-one from node-0",
+		"deleted pvc-one",
+	}))
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-one", "pvc-two"))
+
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+func TestVolumeCleanerReportsRetainedDisksOnce(t *testing.T) {
+	g := gomega.NewWithT(t)
+	infraId := infraIdFor(t)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-one", Description: infraId, AttachedVM: "node-0"},
+			{ID: "disk-2", Name: "pvc-keep", Description: infraId},
+		}},
+	})
+	vcdCluster.SetAnnotations(map[string]string{key.RetainAnnotation(cleaner.KindDisk): "pvc-keep"})
+
+	outcomes := &taskOutcomes{}
+	tasks := cleaner.NewTasks(nil)
+	ctx := cleaner.WithTasks(cleaner.WithObserver(context.Background(), outcomes), tasks)
+	volumes := cleaner.NewVolumeCleaner(k8sClient)
+
+	// Every run while pvc-one is detached and deleted finds pvc-keep again.
+	for requeue := true; requeue; {
+		var err error
+		requeue, err = volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(tasks.Retained()).To(gomega.Equal(1))
+	}
+	g.Expect(outcomes.outcomes).To(gomega.Equal([]string{
+		"retained pvc-keep",
+		"detached pvc-one from node-0",
+		"deleted pvc-one",
+	}))
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-one"))
+
+	// Once it is no longer retained it is deleted like the others.
+	vcdCluster.SetAnnotations(nil)
+	for requeue := true; requeue; {
+		var err error
+		requeue, err = volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+	g.Expect(tasks.List()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-one", "pvc-keep"))
+}
+
+func TestVolumeCleanerDropsGoneTasks(t *testing.T) {
+	g := gomega.NewWithT(t)
+	infraId := infraIdFor(t)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-one", Description: infraId},
+		}},
+	})
+
+	// A task of an earlier run that VCD purged since.
+	outcomes := &taskOutcomes{}
+	tasks := cleaner.NewTasks([]cleaner.Task{{
+		HREF:      server.URL() + "/api/task/purged",
+		Object:    cleaner.Object{Kind: cleaner.KindDisk, ID: "urn:vcloud:disk:disk-1", Name: "pvc-one"},
+		Operation: cleaner.OperationDetach,
+		Detail:    "node-0",
+	}})
+	ctx := cleaner.WithTasks(cleaner.WithObserver(context.Background(), outcomes), tasks)
+	volumes := cleaner.NewVolumeCleaner(k8sClient)
+
+	// The disk goes on from where it is instead of waiting for the task.
+	requeue, err := volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.BeTrue())
+	g.Expect(tasks.List()).To(gomega.HaveLen(1))
+	g.Expect(tasks.List()[0].Operation).To(gomega.Equal(cleaner.OperationDelete))
+
+	requeue, err = volumes.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(requeue).To(gomega.BeFalse())
+	g.Expect(outcomes.outcomes).To(gomega.Equal([]string{"deleted pvc-one"}))
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-one"))
+}
+
 func TestDNATCleaner(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
+	name := uniqueName(t)
 	infraId := infraIdFor(t)
 
+	controlPlane := "dnat-" + controlPlaneName(name, infraId)
+	ingress := "dnat-" + ingressName("ingress-vs-", "nginx", infraId, "http")
+
 	// One rule per page, so the cursor paging really runs.
 	//
 	// The two matching rules are next to each other on purpose. A cursor is an
@@ -107,9 +382,9 @@ func TestDNATCleaner(t *testing.T) {
 	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
 		NatRulePageSize: 1,
 		NatRules: []vcdfake.Resource{
-			{ID: "nat-1", Name: "dnat-" + infraId + "-a"},
-			{ID: "nat-2", Name: "dnat-" + infraId + "-b"},
-			{ID: "nat-3", Name: "dnat-other-cluster"},
+			{ID: "nat-1", Name: controlPlane},
+			{ID: "nat-2", Name: ingress},
+			{ID: "nat-3", Name: "dnat-" + controlPlaneName("other", otherInfraId)},
 		},
 	})
 
@@ -117,7 +392,7 @@ func TestDNATCleaner(t *testing.T) {
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(requeue).To(gomega.BeFalse())
 
-	g.Expect(server.DeletedNatRules()).To(gomega.ConsistOf("dnat-"+infraId+"-a", "dnat-"+infraId+"-b"))
+	g.Expect(server.DeletedNatRules()).To(gomega.ConsistOf(controlPlane, ingress))
 
 	// Every page was read, so the cursor handling really ran.
 	g.Expect(server.Requests()).To(gomega.ContainElement(gomega.ContainSubstring("cursor=3")))
@@ -128,13 +403,17 @@ func TestDNATCleaner(t *testing.T) {
 func TestVirtualServiceCleaner(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
+	name := uniqueName(t)
 	infraId := infraIdFor(t)
 
+	controlPlane := controlPlaneName(name, infraId)
+	ingress := ingressName("ingress-vs-", "nginx", infraId, "https")
+
 	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
 		VirtualServices: []vcdfake.Resource{
-			{ID: "vs-1", Name: "svc-" + infraId + "-a"},
-			{ID: "vs-2", Name: "svc-other-cluster"},
-			{ID: "vs-3", Name: "svc-" + infraId + "-b"},
+			{ID: "vs-1", Name: controlPlane},
+			{ID: "vs-2", Name: controlPlaneName("other", otherInfraId)},
+			{ID: "vs-3", Name: ingress},
 		},
 	})
 
@@ -142,20 +421,56 @@ func TestVirtualServiceCleaner(t *testing.T) {
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(requeue).To(gomega.BeFalse())
 
-	g.Expect(server.DeletedVirtualServices()).To(gomega.ConsistOf("svc-"+infraId+"-a", "svc-"+infraId+"-b"))
+	g.Expect(server.DeletedVirtualServices()).To(gomega.ConsistOf(controlPlane, ingress))
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+func TestVirtualServiceCleanerDeletesConcurrently(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	infraId := infraIdFor(t)
+
+	services := []string{"nginx", "postgres", "redis", "kafka"}
+	resources := make([]vcdfake.Resource, 0, len(services))
+	for i, service := range services {
+		resources = append(resources, vcdfake.Resource{ID: fmt.Sprintf("vs-%d", i), Name: ingressName("ingress-vs-", service, infraId, "https")})
+	}
+	failing := resources[0].Name
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		VirtualServices: resources,
+		FailDeletes:     []string{failing},
+		DeleteLatency:   200 * time.Millisecond,
+	})
+
+	virtualServices := cleaner.NewVirtualServiceCleaner(k8sClient)
+	virtualServices.Concurrency = &cleaner.Concurrency{Workers: 4, PerGateway: 2}
+
+	_, err := virtualServices.Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring(failing)))
+
+	// The failure does not stop the other deletions, which run no more than
+	// the gateway allows at the same time.
+	g.Expect(server.DeletedVirtualServices()).To(gomega.ConsistOf(resources[1].Name, resources[2].Name, resources[3].Name))
+	g.Expect(server.MaxConcurrentDeletes()).To(gomega.Equal(2))
+
 	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
 }
 
 func TestLBPoolCleaner(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
+	name := uniqueName(t)
 	infraId := infraIdFor(t)
 
+	controlPlane := controlPlaneName(name, infraId)
+	ingress := ingressName("ingress-pool-", "nginx", infraId, "http")
+
 	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
 		Pools: []vcdfake.Resource{
-			{ID: "pool-1", Name: "pool-" + infraId + "-a"},
-			{ID: "pool-2", Name: "pool-other-cluster"},
-			{ID: "pool-3", Name: "pool-" + infraId + "-b"},
+			{ID: "pool-1", Name: controlPlane},
+			{ID: "pool-2", Name: ingressName("ingress-pool-", "nginx", otherInfraId, "http")},
+			{ID: "pool-3", Name: ingress},
 		},
 	})
 
@@ -163,20 +478,24 @@ func TestLBPoolCleaner(t *testing.T) {
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(requeue).To(gomega.BeFalse())
 
-	g.Expect(server.DeletedPools()).To(gomega.ConsistOf("pool-"+infraId+"-a", "pool-"+infraId+"-b"))
+	g.Expect(server.DeletedPools()).To(gomega.ConsistOf(controlPlane, ingress))
 	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
 }
 
 func TestAppPortProfileCleaner(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
+	name := uniqueName(t)
 	infraId := infraIdFor(t)
 
+	controlPlane := "appPort_dnat-" + controlPlaneName(name, infraId)
+	ingress := "appPort_dnat-" + ingressName("ingress-vs-", "nginx", infraId, "http")
+
 	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
 		AppPortProfiles: []vcdfake.Resource{
-			{ID: "app-1", Name: "appPort-" + infraId + "-a"},
-			{ID: "app-2", Name: "appPort-other-cluster"},
-			{ID: "app-3", Name: "appPort-" + infraId + "-b"},
+			{ID: "app-1", Name: controlPlane},
+			{ID: "app-2", Name: "appPort_dnat-" + controlPlaneName("other", otherInfraId)},
+			{ID: "app-3", Name: ingress},
 		},
 	})
 
@@ -184,7 +503,7 @@ func TestAppPortProfileCleaner(t *testing.T) {
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(requeue).To(gomega.BeFalse())
 
-	g.Expect(server.DeletedAppPortProfiles()).To(gomega.ConsistOf("appPort-"+infraId+"-a", "appPort-"+infraId+"-b"))
+	g.Expect(server.DeletedAppPortProfiles()).To(gomega.ConsistOf(controlPlane, ingress))
 
 	// The listing is limited to the tenant scope.
 	g.Expect(server.Requests()).To(gomega.ContainElement(gomega.ContainSubstring("scope%3D%3DTENANT")))
@@ -192,18 +511,186 @@ func TestAppPortProfileCleaner(t *testing.T) {
 	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
 }
 
+// TestCleanersPlan checks every cleaner lists what belongs to the cluster and
+// deletes nothing while doing so.
+func TestCleanersPlan(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	vsName := controlPlaneName(name, infraId)
+	otherName := controlPlaneName("other", otherInfraId)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-one", Description: infraId, AttachedVM: "node-0"},
+			{ID: "disk-2", Name: "pvc-other", Description: otherInfraId},
+		}},
+		NatRules:        []vcdfake.Resource{{ID: "nat-1", Name: "dnat-" + vsName}, {ID: "nat-2", Name: "dnat-" + otherName}},
+		VirtualServices: []vcdfake.Resource{{ID: "vs-1", Name: vsName}, {ID: "vs-2", Name: otherName}},
+		Pools:           []vcdfake.Resource{{ID: "pool-1", Name: vsName}, {ID: "pool-2", Name: otherName}},
+		AppPortProfiles: []vcdfake.Resource{{ID: "app-1", Name: "appPort_dnat-" + vsName}, {ID: "app-2", Name: "appPort_dnat-" + otherName}},
+	})
+
+	cleaners := []cleaner.Cleaner{
+		cleaner.NewVolumeCleaner(k8sClient),
+		cleaner.NewVirtualServiceCleaner(k8sClient),
+		cleaner.NewLBPoolCleaner(k8sClient),
+		cleaner.NewDNATCleaner(k8sClient),
+		cleaner.NewAppPortProfileCleaner(k8sClient),
+	}
+
+	plan := []cleaner.Object{}
+	for _, c := range cleaners {
+		objects, err := c.Plan(ctx, logr.Discard(), client, vcdCluster)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		plan = append(plan, objects...)
+	}
+
+	g.Expect(plan).To(gomega.Equal([]cleaner.Object{
+		{Kind: cleaner.KindDisk, ID: "urn:vcloud:disk:disk-1", Name: "pvc-one"},
+		{Kind: cleaner.KindVirtualService, ID: "vs-1", Name: vsName, Gateway: "fake-edge"},
+		{Kind: cleaner.KindLBPool, ID: "pool-1", Name: vsName, Gateway: "fake-edge"},
+		{Kind: cleaner.KindDNATRule, ID: "nat-1", Name: "dnat-" + vsName, Gateway: "fake-edge"},
+		{Kind: cleaner.KindAppPortProfile, ID: "app-1", Name: "appPort_dnat-" + vsName},
+	}))
+
+	g.Expect(server.DetachedDisks()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedDisks()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedNatRules()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedVirtualServices()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedPools()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedAppPortProfiles()).To(gomega.BeEmpty())
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+// retainedObjects collects the objects the cleaners report as retained.
+type retainedObjects struct {
+	names []string
+}
+
+func (r *retainedObjects) ObjectDeleted(ctx context.Context, o cleaner.Object) {}
+
+func (r *retainedObjects) ObjectFailed(ctx context.Context, o cleaner.Object, err error) {}
+
+func (r *retainedObjects) ObjectRetained(ctx context.Context, o cleaner.Object) {
+	r.names = append(r.names, o.Name)
+}
+
+func TestCleanersRetainAnnotatedObjects(t *testing.T) {
+	g := gomega.NewWithT(t)
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	controlPlane := controlPlaneName(name, infraId)
+	postgres := ingressName("ingress-vs-", "postgres", infraId, "tcp")
+	nginx := ingressName("ingress-vs-", "nginx", infraId, "http")
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-data", Description: infraId, AttachedVM: "node-0"},
+			{ID: "disk-2", Name: "pvc-scratch", Description: infraId},
+		}},
+		VirtualServices: []vcdfake.Resource{
+			{ID: "vs-1", Name: controlPlane},
+			{ID: "vs-2", Name: postgres},
+			{ID: "vs-3", Name: nginx},
+		},
+	})
+	vcdCluster.SetAnnotations(map[string]string{
+		key.RetainAnnotation(cleaner.KindDisk):           "true",
+		key.RetainAnnotation(cleaner.KindVirtualService): "ingress-vs-postgres-*, " + controlPlane,
+	})
+
+	retained := &retainedObjects{}
+	ctx := cleaner.WithObserver(context.Background(), retained)
+
+	volumes := cleaner.NewVolumeCleaner(k8sClient)
+	virtualServices := cleaner.NewVirtualServiceCleaner(k8sClient)
+
+	// The plan leaves out what is retained.
+	plan, err := volumes.Plan(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(plan).To(gomega.BeEmpty())
+	plan, err = virtualServices.Plan(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(plan).To(gomega.ConsistOf(cleaner.Object{Kind: cleaner.KindVirtualService, ID: "vs-3", Name: nginx, Gateway: "fake-edge"}))
+	g.Expect(retained.names).To(gomega.BeEmpty())
+
+	for _, c := range []cleaner.Cleaner{volumes, virtualServices} {
+		requeue, err := c.Clean(ctx, logr.Discard(), client, vcdCluster)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(requeue).To(gomega.BeFalse())
+	}
+
+	// A retained disk is not even detached.
+	g.Expect(server.DetachedDisks()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedDisks()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedVirtualServices()).To(gomega.ConsistOf(nginx))
+	g.Expect(retained.names).To(gomega.ConsistOf("pvc-data", "pvc-scratch", controlPlane, postgres))
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+func TestCleanersRefuseMalformedRetainPattern(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		Pools: []vcdfake.Resource{{ID: "pool-1", Name: controlPlaneName(name, infraId)}},
+	})
+	vcdCluster.SetAnnotations(map[string]string{
+		key.RetainAnnotation(cleaner.KindLBPool): "ingress-pool-[",
+	})
+
+	// A typo in the annotation must not get the object deleted.
+	_, err := cleaner.NewLBPoolCleaner(k8sClient).Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(cleaner.IsInvalidRetainPattern(err)).To(gomega.BeTrue())
+	g.Expect(server.DeletedPools()).To(gomega.BeEmpty())
+}
+
 // TestCleanersLeaveOtherClustersAlone runs every cleaner against resources that
-// belong to a different cluster.
+// belong to a different cluster, or only resemble the names the cluster's
+// objects get.
 func TestCleanersLeaveOtherClustersAlone(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	// The infra id with a longer uuid, and with the name of another cluster.
+	longer := controlPlaneName(name, infraId+"0")
+	otherCluster := controlPlaneName("other", infraId)
+	// The infra id in a name neither CAPVCD nor the cloud provider would use.
+	handMade := "backup-of-" + vcd.TrimInfraId(infraId)
 
 	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
-		DiskPages:       [][]vcdfake.Disk{{{ID: "disk-1", Name: "pvc-other", Description: "other-cluster"}}},
-		NatRules:        []vcdfake.Resource{{ID: "nat-1", Name: "dnat-other-cluster"}},
-		VirtualServices: []vcdfake.Resource{{ID: "vs-1", Name: "svc-other-cluster"}},
-		Pools:           []vcdfake.Resource{{ID: "pool-1", Name: "pool-other-cluster"}},
-		AppPortProfiles: []vcdfake.Resource{{ID: "app-1", Name: "appPort-other-cluster"}},
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-other", Description: otherInfraId},
+			{ID: "disk-2", Name: "pvc-copy", Description: "copy of " + infraId},
+		}},
+		NatRules: []vcdfake.Resource{
+			{ID: "nat-1", Name: "dnat-" + longer},
+			{ID: "nat-2", Name: "dnat-" + otherCluster},
+			{ID: "nat-3", Name: handMade},
+		},
+		VirtualServices: []vcdfake.Resource{
+			{ID: "vs-1", Name: longer},
+			{ID: "vs-2", Name: otherCluster},
+			{ID: "vs-3", Name: handMade},
+		},
+		Pools: []vcdfake.Resource{
+			{ID: "pool-1", Name: longer},
+			{ID: "pool-2", Name: ingressName("ingress-pool-", "nginx", otherInfraId, "http")},
+			{ID: "pool-3", Name: handMade},
+		},
+		AppPortProfiles: []vcdfake.Resource{
+			{ID: "app-1", Name: "appPort_dnat-" + longer},
+			{ID: "app-2", Name: "appPort_" + controlPlaneName(name, infraId)},
+			{ID: "app-3", Name: handMade},
+		},
 	})
 
 	cleaners := []cleaner.Cleaner{
@@ -227,3 +714,94 @@ func TestCleanersLeaveOtherClustersAlone(t *testing.T) {
 	g.Expect(server.DeletedAppPortProfiles()).To(gomega.BeEmpty())
 	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
 }
+
+func TestCleanersCleanEveryZone(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	controlPlane := "dnat-" + controlPlaneName(name, infraId)
+	zoneIngress := "dnat-" + ingressName("ingress-vs-", "nginx", infraId, "http")
+
+	// The second zone has a vdc, a network and an edge gateway of its own. Its
+	// disk is held by a node of that zone.
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-a", Description: infraId},
+			{ID: "disk-2", Name: "pvc-b", Description: infraId, VdcName: "vdc-b", AttachedVM: "node-b"},
+		}},
+		NatRules: []vcdfake.Resource{{ID: "nat-1", Name: controlPlane}},
+		Zones: []vcdfake.Zone{{
+			VdcName:     "vdc-b",
+			NetworkName: "network-b",
+			NatRules:    []vcdfake.Resource{{ID: "nat-2", Name: zoneIngress}},
+		}},
+	})
+	vcdCluster.SetAnnotations(map[string]string{
+		key.ZonesAnnotation: fmt.Sprintf(`[{"name":"a","ovdc":%q,"ovdcNetwork":%q},{"name":"b","ovdc":"vdc-b","ovdcNetwork":"network-b"}]`,
+			testVdcName, testNetworkName),
+	})
+
+	// The plan tells every object by its zone.
+	plan, err := cleaner.NewDNATCleaner(k8sClient).Plan(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(plan).To(gomega.ConsistOf(
+		cleaner.Object{Kind: cleaner.KindDNATRule, ID: "nat-1", Name: controlPlane, Zone: "a", Gateway: "fake-edge"},
+		cleaner.Object{Kind: cleaner.KindDNATRule, ID: "nat-2", Name: zoneIngress, Zone: "b", Gateway: "fake-edge"},
+	))
+	plan, err = cleaner.NewVolumeCleaner(k8sClient).Plan(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(plan).To(gomega.ConsistOf(
+		cleaner.Object{Kind: cleaner.KindDisk, ID: "urn:vcloud:disk:disk-1", Name: "pvc-a", Zone: "a"},
+		cleaner.Object{Kind: cleaner.KindDisk, ID: "urn:vcloud:disk:disk-2", Name: "pvc-b", Zone: "b"},
+	))
+
+	for _, c := range []cleaner.Cleaner{cleaner.NewVolumeCleaner(k8sClient), cleaner.NewDNATCleaner(k8sClient)} {
+		_, err := c.Clean(ctx, logr.Discard(), client, vcdCluster)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	g.Expect(server.DeletedNatRules()).To(gomega.ConsistOf(controlPlane, zoneIngress))
+	g.Expect(server.DetachedDisks()).To(gomega.Equal([]string{"pvc-b"}))
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-a", "pvc-b"))
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+func TestCleanersGoOnAfterAFailingZone(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	controlPlane := "dnat-" + controlPlaneName(name, infraId)
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{
+		NatRules: []vcdfake.Resource{{ID: "nat-1", Name: controlPlane}},
+	})
+	// The first zone has a vdc VCD does not know.
+	vcdCluster.SetAnnotations(map[string]string{
+		key.ZonesAnnotation: fmt.Sprintf(`[{"name":"gone","ovdc":"vdc-gone","ovdcNetwork":"network-gone"},{"name":"a","ovdc":%q,"ovdcNetwork":%q}]`,
+			testVdcName, testNetworkName),
+	})
+
+	_, err := cleaner.NewDNATCleaner(k8sClient).Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(err).To(gomega.MatchError(gomega.ContainSubstring("zone [gone]")))
+
+	g.Expect(server.DeletedNatRules()).To(gomega.ConsistOf(controlPlane))
+}
+
+func TestCleanersRefuseMalformedZones(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	server, client, vcdCluster := newCleanerFixture(t, vcdfake.Config{})
+	vcdCluster.SetAnnotations(map[string]string{
+		key.ZonesAnnotation: `[{"name":"a","ovdc":"vdc-a"}]`,
+	})
+
+	_, err := cleaner.NewDNATCleaner(k8sClient).Clean(ctx, logr.Discard(), client, vcdCluster)
+	g.Expect(vcdcluster.IsInvalidZones(err)).To(gomega.BeTrue())
+
+	g.Expect(server.DeletedNatRules()).To(gomega.BeEmpty())
+}
@@ -0,0 +1,277 @@
+//go:build integration
+
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/controllers"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+)
+
+func TestReconcileDeleteWritesCleanupReport(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	volumes := &stubCleaner{name: "volumes", deleted: []cleaner.Object{{Kind: cleaner.KindDisk, ID: "disk-1", Name: "pvc-one"}}}
+	failing := &stubCleaner{name: "failing", err: errStubVCDClient}
+	r := newReconciler([]*stubCleaner{volumes, failing})
+	r.ManagementCluster = "test-mc"
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	reportName := controllers.CleanupReportName(getVCDCluster(t, ctx, name))
+	report := getCleanupReport(t, ctx, reportName)
+	g.Expect(report.Spec.ClusterName).To(gomega.Equal(name))
+	g.Expect(report.Spec.InfraID).To(gomega.Equal(infraIdFor(t)))
+	g.Expect(report.Spec.ManagementCluster).To(gomega.Equal("test-mc"))
+	g.Expect(report.Status.StartTime).NotTo(gomega.BeNil())
+	g.Expect(report.Status.CompletionTime).To(gomega.BeNil())
+	g.Expect(report.Status.Objects).To(gomega.HaveLen(1))
+	g.Expect(report.Status.Objects[0].Cleaner).To(gomega.Equal("volumes"))
+	g.Expect(report.Status.Objects[0].Name).To(gomega.Equal("pvc-one"))
+	g.Expect(report.Status.Objects[0].Outcome).To(gomega.Equal(cleanerv1alpha1.OutcomeDeleted))
+	g.Expect(report.Status.Errors).To(gomega.HaveLen(1))
+	g.Expect(report.Status.Errors[0].Message).To(gomega.Equal(errStubVCDClient.Error()))
+
+	// The next run adds to the same report and completes it.
+	volumes.deleted = []cleaner.Object{{Kind: cleaner.KindDisk, ID: "disk-2", Name: "pvc-two"}}
+	failing.err = nil
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+
+	report = getCleanupReport(t, ctx, reportName)
+	g.Expect(report.Status.CompletionTime).NotTo(gomega.BeNil())
+	g.Expect(report.Status.Objects).To(gomega.HaveLen(2))
+	g.Expect(report.Status.Objects[1].Name).To(gomega.Equal("pvc-two"))
+}
+
+func TestReconcileDeleteWritesCleanupReportToReportNamespace(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	g.Expect(k8sClient.Create(ctx, namespace)).To(gomega.Succeed())
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	r := newReconciler([]*stubCleaner{{name: "pending", requeue: true}})
+	r.ReportNamespace = name
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	reportKey := types.NamespacedName{Name: controllers.CleanupReportName(getVCDCluster(t, ctx, name)), Namespace: name}
+	report := &cleanerv1alpha1.VCDCleanupReport{}
+	g.Expect(k8sClient.Get(ctx, reportKey, report)).To(gomega.Succeed())
+	g.Expect(report.Spec.VCDClusterName).To(gomega.Equal(name))
+	g.Expect(report.Spec.VCDClusterNamespace).To(gomega.Equal(testNamespace))
+	g.Expect(report.Status.StartTime).NotTo(gomega.BeNil())
+
+	// A start time that was never written is made up for on the next run.
+	report.Status.StartTime = nil
+	g.Expect(k8sClient.Status().Update(ctx, report)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Get(ctx, reportKey, report)).To(gomega.Succeed())
+	g.Expect(report.Status.StartTime).NotTo(gomega.BeNil())
+}
+
+func TestReconcileDeleteReportsRetainedObjects(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	retained := cleaner.Object{Kind: cleaner.KindDisk, ID: "disk-1", Name: "pvc-data"}
+	volumes := &stubCleaner{name: "volumes", retained: []cleaner.Object{retained}, requeue: true}
+	r := newReconciler([]*stubCleaner{volumes})
+	recorder := events.NewFakeRecorder(100)
+	r.Recorder = recorder
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	// A retained object seen on every run is listed once.
+	for i := 0; i < 2; i++ {
+		_, err = r.Reconcile(ctx, reconcileRequest(name))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	current := getVCDCluster(t, ctx, name)
+	g.Expect(controllers.GetCleanerStatus(current, "volumes").Retained).To(gomega.Equal(1))
+
+	report := getCleanupReport(t, ctx, controllers.CleanupReportName(current))
+	g.Expect(report.Status.Objects).To(gomega.HaveLen(1))
+	g.Expect(report.Status.Objects[0].Name).To(gomega.Equal("pvc-data"))
+	g.Expect(report.Status.Objects[0].Outcome).To(gomega.Equal(cleanerv1alpha1.OutcomeRetained))
+
+	g.Expect(drainEvents(recorder)).To(gomega.ContainElement(gomega.HavePrefix("Normal VCDObjectRetained")))
+}
+
+func TestCleanupReportGarbageCollection(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	completed := createCleanupReport(t, ctx, name+"-old", ptrTime(time.Now().Add(-2*time.Hour)))
+	recent := createCleanupReport(t, ctx, name+"-recent", ptrTime(time.Now().Add(-time.Minute)))
+	running := createCleanupReport(t, ctx, name+"-running", nil)
+	retained := createCleanupReport(t, ctx, name+"-retained", ptrTime(time.Now().Add(-2*time.Hour)))
+	retained.Status.Objects = []cleanerv1alpha1.CleanedObject{{
+		Cleaner: cleaner.VolumeCleanerName,
+		Kind:    cleaner.KindDisk,
+		Name:    "pvc-data",
+		Outcome: cleanerv1alpha1.OutcomeRetained,
+		Time:    metav1.Now(),
+	}}
+	g.Expect(k8sClient.Status().Update(ctx, retained)).To(gomega.Succeed())
+
+	r := &controllers.VCDCleanupReportReconciler{
+		Client: k8sClient,
+		Log:    logr.Discard(),
+		TTL:    time.Hour,
+	}
+
+	for _, report := range []*cleanerv1alpha1.VCDCleanupReport{completed, recent, running, retained} {
+		_, err := r.Reconcile(ctx, reconcileRequest(report.Name))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	// Only the report whose clean-up finished more than TTL ago goes, unless
+	// it tracks retained objects.
+	err := k8sClient.Get(ctx, types.NamespacedName{Name: completed.Name, Namespace: testNamespace}, &cleanerv1alpha1.VCDCleanupReport{})
+	g.Expect(apierrors.IsNotFound(err)).To(gomega.BeTrue())
+	getCleanupReport(t, ctx, recent.Name)
+	getCleanupReport(t, ctx, running.Name)
+	getCleanupReport(t, ctx, retained.Name)
+
+	// A recent report is looked at again once it expires.
+	result, err := r.Reconcile(ctx, reconcileRequest(recent.Name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).To(gomega.BeNumerically("~", 59*time.Minute, time.Minute))
+}
+
+// createCleanupReport creates a report. completion is written through the
+// status subresource, nil leaves the clean-up running.
+func createCleanupReport(t *testing.T, ctx context.Context, name string, completion *metav1.Time) *cleanerv1alpha1.VCDCleanupReport {
+	t.Helper()
+	g := gomega.NewWithT(t)
+
+	report := &cleanerv1alpha1.VCDCleanupReport{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Spec: cleanerv1alpha1.VCDCleanupReportSpec{
+			ClusterName:    name,
+			VCDClusterName: name,
+			VCDClusterUID:  "uid",
+			InfraID:        infraIdFor(t),
+		},
+	}
+
+	g.Expect(k8sClient.Create(ctx, report)).To(gomega.Succeed())
+	t.Cleanup(func() {
+		_ = k8sClient.Delete(context.Background(), report)
+	})
+
+	if completion != nil {
+		report.Status.CompletionTime = completion
+		g.Expect(k8sClient.Status().Update(ctx, report)).To(gomega.Succeed())
+	}
+
+	return report
+}
+
+// getCleanupReport reads a report back from the api server.
+func getCleanupReport(t *testing.T, ctx context.Context, name string) *cleanerv1alpha1.VCDCleanupReport {
+	t.Helper()
+	g := gomega.NewWithT(t)
+
+	report := &cleanerv1alpha1.VCDCleanupReport{}
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, report)).To(gomega.Succeed())
+
+	return report
+}
+
+func ptrTime(t time.Time) *metav1.Time {
+	mt := metav1.NewTime(t)
+	return &mt
+}
+
+// TestHelmCRDsMatchConfig fails when the CRDs installed by the Helm chart
+// drift from the ones controller-gen writes to config/crd/bases.
+func TestHelmCRDsMatchConfig(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	bases := filepath.Join("..", "..", "config", "crd", "bases")
+	chart := filepath.Join("..", "..", "helm", "cluster-api-cleaner-cloud-director", "crds")
+
+	files, err := filepath.Glob(filepath.Join(bases, "*.yaml"))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(files).NotTo(gomega.BeEmpty())
+
+	for _, file := range files {
+		want, err := os.ReadFile(file)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+
+		got, err := os.ReadFile(filepath.Join(chart, filepath.Base(file)))
+		g.Expect(err).NotTo(gomega.HaveOccurred(), "CRD %s is missing from the Helm chart, run `make manifests`", filepath.Base(file))
+		g.Expect(string(got)).To(gomega.Equal(string(want)), "CRD %s of the Helm chart differs from config/crd/bases, run `make manifests`", filepath.Base(file))
+	}
+}
@@ -20,6 +20,10 @@ package integration
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -27,14 +31,25 @@ import (
 	"github.com/onsi/gomega"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
 	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	"k8s.io/utils/ptr"
+	capi "sigs.k8s.io/cluster-api/api/core/v1beta2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/controllers"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/test/integration/vcdfake"
 )
 
 // newReconciler builds a reconciler that never talks to a real VCD endpoint.
@@ -48,7 +63,7 @@ func newReconciler(cleaners []*stubCleaner) *controllers.VCDClusterReconciler {
 		Client:   k8sClient,
 		Log:      logr.Discard(),
 		Cleaners: list,
-		NewVCDClient: func(ctx context.Context, c client.Client, vcdCluster *capvcd.VCDCluster, log logr.Logger) (*vcdsdk.Client, error) {
+		NewVCDClient: func(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error) {
 			return &vcdsdk.Client{}, nil
 		},
 	}
@@ -164,13 +179,50 @@ func TestReconcileDeleteWithoutInfraId(t *testing.T) {
 	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
 }
 
+func TestReconcileDeleteRefusesInvalidInfraId(t *testing.T) {
+	for name, infraId := range map[string]string{
+		"short":   "a",
+		"generic": "cluster",
+		"no uuid": "urn:vcloud:entity:vmware:capvcdCluster:",
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			ctx := context.Background()
+			name := uniqueName(t)
+
+			cluster := createCluster(t, ctx, newCluster(name))
+			vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraId)
+
+			stub := &stubCleaner{name: stubCleanerName}
+			r := newReconciler([]*stubCleaner{stub})
+			recorder := events.NewFakeRecorder(100)
+			r.Recorder = recorder
+
+			_, err := r.Reconcile(ctx, reconcileRequest(name))
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+
+			g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+			result, err := r.Reconcile(ctx, reconcileRequest(name))
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(result.IsZero()).To(gomega.BeTrue())
+
+			// Nothing is cleaned up, and the finalizer stays until someone
+			// looks into it.
+			g.Expect(stub.callCount()).To(gomega.Equal(0))
+			g.Expect(getVCDCluster(t, ctx, name).Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
+			g.Expect(drainEvents(recorder)).To(gomega.ContainElement(gomega.HavePrefix("Warning InvalidInfraId")))
+		})
+	}
+}
+
 func TestReconcileDeleteRunsCleaners(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
 	name := uniqueName(t)
 
 	cluster := createCluster(t, ctx, newCluster(name))
-	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), "infra-"+name)
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
 
 	order := []string{}
 	stubs := []*stubCleaner{
@@ -195,19 +247,131 @@ func TestReconcileDeleteRunsCleaners(t *testing.T) {
 	g.Expect(order).To(gomega.Equal([]string{"volumes", "virtualservices", "lbpools", "dnats", "appportprofiles"}))
 	for _, stub := range stubs {
 		g.Expect(stub.callCount()).To(gomega.Equal(1))
-		g.Expect(stub.calls[0].Status.InfraId).To(gomega.Equal("infra-" + name))
+		g.Expect(stub.calls[0].Status.InfraId).To(gomega.Equal(infraIdFor(t)))
 	}
 
 	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
 }
 
+func TestReconcileDeleteRunsCleanersAfterTheirDependencies(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	order := []string{}
+	r := newReconciler([]*stubCleaner{
+		{name: "appportprofiles", dependsOn: []string{"lbpools", "dnats"}, order: &order},
+		{name: "lbpools", dependsOn: []string{"virtualservices"}, order: &order},
+		{name: "virtualservices", order: &order},
+		{name: "dnats", order: &order},
+	})
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// A single worker starts the first ready cleaner of the list each time.
+	g.Expect(order).To(gomega.Equal([]string{"virtualservices", "lbpools", "dnats", "appportprofiles"}))
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+}
+
+func TestReconcileDeleteRunsIndependentCleanersInParallel(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	// Each of them only returns once both run, so a serial run times out.
+	rendezvous := &sync.WaitGroup{}
+	rendezvous.Add(2)
+	volumes := &stubCleaner{name: "volumes", rendezvous: rendezvous}
+	dnats := &stubCleaner{name: "dnats", rendezvous: rendezvous}
+	r := newReconciler([]*stubCleaner{volumes, dnats})
+	r.Workers = 2
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+}
+
+func TestReconcileDeleteWaitsForDependencyInProgress(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	virtualServices := &stubCleaner{name: "virtualservices", requeue: true}
+	lbPools := &stubCleaner{name: "lbpools", dependsOn: []string{"virtualservices"}}
+	dnats := &stubCleaner{name: "dnats"}
+	r := newReconciler([]*stubCleaner{virtualServices, lbPools, dnats})
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	result, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.RequeueAfter).NotTo(gomega.BeZero())
+
+	// The independent branch goes on, the dependent one waits.
+	g.Expect(lbPools.callCount()).To(gomega.Equal(0))
+	g.Expect(dnats.callCount()).To(gomega.Equal(1))
+	current := getVCDCluster(t, ctx, name)
+	g.Expect(controllers.GetCleanerStatus(current, "lbpools").State).To(gomega.Equal(controllers.CleanerPending))
+
+	virtualServices.requeue = false
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(lbPools.callCount()).To(gomega.Equal(1))
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+}
+
+func TestReconcileDeleteFailsOnUnknownDependency(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	stub := &stubCleaner{name: "lbpools", dependsOn: []string{"virtualservices"}}
+	r := newReconciler([]*stubCleaner{stub})
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(cleaner.IsInvalidDependency(err)).To(gomega.BeTrue())
+	g.Expect(stub.callCount()).To(gomega.Equal(0))
+	g.Expect(getVCDCluster(t, ctx, name).Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
+}
+
 func TestReconcileDeleteRequeuesWhileCleanupRuns(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
 	name := uniqueName(t)
 
 	cluster := createCluster(t, ctx, newCluster(name))
-	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), "infra-"+name)
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
 
 	pending := &stubCleaner{name: "pending", requeue: true}
 	done := &stubCleaner{name: "done"}
@@ -238,13 +402,62 @@ func TestReconcileDeleteRequeuesWhileCleanupRuns(t *testing.T) {
 	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
 }
 
+func TestReconcileDeleteKeepsVCDTasksBetweenRuns(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{
+		VAppName: name,
+		DiskPages: [][]vcdfake.Disk{{
+			{ID: "disk-1", Name: "pvc-one", Description: infraIdFor(t), AttachedVM: "node-0"},
+		}},
+	})
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, server.URL(), cluster), infraIdFor(t))
+
+	r := &controllers.VCDClusterReconciler{
+		Client:   k8sClient,
+		Log:      logr.Discard(),
+		Cleaners: []cleaner.Cleaner{cleaner.NewVolumeCleaner(k8sClient)},
+	}
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	// Every run starts the next task of the disk and leaves it on the
+	// VCDCluster for the following run.
+	for _, operation := range []string{cleaner.OperationDetach, cleaner.OperationDelete} {
+		result, err := r.Reconcile(ctx, reconcileRequest(name))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(result.RequeueAfter).To(gomega.Equal(10 * time.Second))
+
+		var tasks []cleaner.Task
+		raw := getVCDCluster(t, ctx, name).Annotations[key.CleanerTasksAnnotation(cleaner.VolumeCleanerName)]
+		g.Expect(json.Unmarshal([]byte(raw), &tasks)).To(gomega.Succeed())
+		g.Expect(tasks).To(gomega.HaveLen(1))
+		g.Expect(tasks[0].Operation).To(gomega.Equal(operation))
+		g.Expect(tasks[0].Object.Name).To(gomega.Equal("pvc-one"))
+	}
+
+	result, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.IsZero()).To(gomega.BeTrue())
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+
+	g.Expect(server.DetachedDisks()).To(gomega.Equal([]string{"pvc-one"}))
+	g.Expect(server.DeletedDisks()).To(gomega.Equal([]string{"pvc-one"}))
+}
+
 func TestReconcileDeleteKeepsFinalizerOnCleanerError(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
 	name := uniqueName(t)
 
 	cluster := createCluster(t, ctx, newCluster(name))
-	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), "infra-"+name)
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
 
 	failing := &stubCleaner{name: "failing", err: errStubVCDClient}
 	never := &stubCleaner{name: "never"}
@@ -263,6 +476,139 @@ func TestReconcileDeleteKeepsFinalizerOnCleanerError(t *testing.T) {
 	g.Expect(getVCDCluster(t, ctx, name).Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
 }
 
+func TestReconcileDeleteRecordsCleanerStatus(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	done := &stubCleaner{name: "done", deleted: []cleaner.Object{
+		{Kind: cleaner.KindDisk, Name: "pvc-one"},
+		{Kind: cleaner.KindDisk, Name: "pvc-two"},
+	}}
+	pending := &stubCleaner{name: "pending", requeue: true}
+	failing := &stubCleaner{name: "failing", err: errStubVCDClient}
+	never := &stubCleaner{name: "never"}
+	r := newReconciler([]*stubCleaner{done, pending, failing, never})
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// The status of every cleaner is on the object, so the blocking one can be
+	// found with kubectl describe.
+	current := getVCDCluster(t, ctx, name)
+	g.Expect(controllers.GetCleanerStatus(current, "done").State).To(gomega.Equal(controllers.CleanerSucceeded))
+	g.Expect(controllers.GetCleanerStatus(current, "done").Deleted).To(gomega.Equal(2))
+	g.Expect(controllers.GetCleanerStatus(current, "pending").State).To(gomega.Equal(controllers.CleanerInProgress))
+	g.Expect(controllers.GetCleanerStatus(current, "failing").State).To(gomega.Equal(controllers.CleanerFailed))
+	g.Expect(controllers.GetCleanerStatus(current, "failing").Reason).To(gomega.Equal(errStubVCDClient.Error()))
+	g.Expect(controllers.GetCleanerStatus(current, "never").State).To(gomega.Equal(controllers.CleanerPending))
+	g.Expect(current.Annotations).To(gomega.HaveKey(key.CleanerStatusAnnotation("never")))
+
+	// The deleted count adds up over the runs.
+	failing.err = nil
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	current = getVCDCluster(t, ctx, name)
+	g.Expect(controllers.GetCleanerStatus(current, "done").Deleted).To(gomega.Equal(4))
+	g.Expect(controllers.GetCleanerStatus(current, "failing").State).To(gomega.Equal(controllers.CleanerSucceeded))
+	g.Expect(controllers.GetCleanerStatus(current, "failing").Reason).To(gomega.BeEmpty())
+	g.Expect(controllers.GetCleanerStatus(current, "never").State).To(gomega.Equal(controllers.CleanerSucceeded))
+}
+
+func TestReconcileDeleteEmitsEvents(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	disks := &stubCleaner{name: "disks", deleted: []cleaner.Object{
+		{Kind: cleaner.KindDisk, ID: "urn:vcloud:disk:1", Name: "pvc-one"},
+	}}
+	failing := &stubCleaner{name: "failing", err: errStubVCDClient}
+	r := newReconciler([]*stubCleaner{disks, failing})
+	recorder := events.NewFakeRecorder(100)
+	r.Recorder = recorder
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	// Every event is emitted on the VCDCluster and on the Cluster.
+	g.Expect(drainEvents(recorder)).To(gomega.Equal([]string{
+		"Normal VCDObjectDeleted disks deleted disk pvc-one (urn:vcloud:disk:1)",
+		"Normal VCDObjectDeleted disks deleted disk pvc-one (urn:vcloud:disk:1)",
+		"Warning CleanerFailed failing failed: " + errStubVCDClient.Error(),
+		"Warning CleanerFailed failing failed: " + errStubVCDClient.Error(),
+	}))
+
+	failing.err = nil
+	disks.deleted = nil
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(drainEvents(recorder)).To(gomega.ConsistOf(
+		gomega.HavePrefix("Normal CleanupCompleted"),
+		gomega.HavePrefix("Normal CleanupCompleted"),
+	))
+}
+
+func TestReconcileDeleteRecordsMetrics(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	disks := &stubCleaner{name: "disks", deleted: []cleaner.Object{
+		{Kind: cleaner.KindDisk, Name: "pvc-one"},
+		{Kind: cleaner.KindDisk, Name: "pvc-two"},
+	}}
+	failing := &stubCleaner{name: "failing", err: errStubVCDClient}
+	r := newReconciler([]*stubCleaner{disks, failing})
+	// The management cluster label keeps the series of this test apart.
+	r.ManagementCluster = name
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).To(gomega.HaveOccurred())
+
+	g.Expect(metricValue(t, "cluster_api_cleaner_cloud_director_objects_deleted_total",
+		map[string]string{"management_cluster": name, "cleaner": "disks", "kind": cleaner.KindDisk})).To(gomega.Equal(2.0))
+	g.Expect(metricValue(t, "cluster_api_cleaner_cloud_director_errors_total",
+		map[string]string{"management_cluster": name, "class": controllers.ErrorClassCleaner})).To(gomega.Equal(1.0))
+
+	failing.err = nil
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(metricValue(t, "cluster_api_cleaner_cloud_director_objects_deleted_total",
+		map[string]string{"management_cluster": name, "cleaner": "disks", "kind": cleaner.KindDisk})).To(gomega.Equal(4.0))
+	g.Expect(metricValue(t, "cluster_api_cleaner_cloud_director_cleanup_duration_seconds",
+		map[string]string{"management_cluster": name, "cleaner": "total"})).To(gomega.Equal(1.0))
+	g.Expect(metricValue(t, "cluster_api_cleaner_cloud_director_cleanup_duration_seconds",
+		map[string]string{"management_cluster": name, "cleaner": "failing"})).To(gomega.Equal(2.0))
+}
+
 func TestReconcileDeleteWithoutClusterNameLabel(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
@@ -271,7 +617,7 @@ func TestReconcileDeleteWithoutClusterNameLabel(t *testing.T) {
 	cluster := createCluster(t, ctx, newCluster(name))
 	vcdCluster := newVCDCluster(name, "https://vcd.invalid", cluster)
 	delete(vcdCluster.Labels, key.CapiClusterLabelKey)
-	vcdCluster = createVCDCluster(t, ctx, vcdCluster, "infra-"+name)
+	vcdCluster = createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
 
 	stub := &stubCleaner{name: stubCleanerName}
 	r := newReconciler([]*stubCleaner{stub})
@@ -291,34 +637,416 @@ func TestReconcileDeleteWithoutClusterNameLabel(t *testing.T) {
 	g.Expect(getVCDCluster(t, ctx, name).Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
 }
 
-func TestReconcileDeleteSwallowsVCDClientError(t *testing.T) {
+func TestReconcileDeleteBacksOffOnVCDClientError(t *testing.T) {
 	g := gomega.NewWithT(t)
 	ctx := context.Background()
 	name := uniqueName(t)
 
 	cluster := createCluster(t, ctx, newCluster(name))
-	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), "infra-"+name)
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
 
 	stub := &stubCleaner{name: stubCleanerName}
 	r := newReconciler([]*stubCleaner{stub})
+	r.VCDClientBackoff = controllers.VCDClientBackoff{Base: time.Second, Max: 3 * time.Second}
+	recorder := events.NewFakeRecorder(100)
+	r.Recorder = recorder
 
 	_, err := r.Reconcile(ctx, reconcileRequest(name))
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 
 	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
 
-	r.NewVCDClient = func(ctx context.Context, c client.Client, vcdCluster *capvcd.VCDCluster, log logr.Logger) (*vcdsdk.Client, error) {
+	newVCDClient := r.NewVCDClient
+	r.NewVCDClient = func(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error) {
+		return nil, errStubUnreachable
+	}
+
+	// The delay doubles on every failure, up to the max.
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second} {
+		result, err := r.Reconcile(ctx, reconcileRequest(name))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(result.RequeueAfter).To(gomega.Equal(expected))
+	}
+	g.Expect(stub.callCount()).To(gomega.Equal(0))
+
+	current := getVCDCluster(t, ctx, name)
+	g.Expect(current.Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
+	condition, ok := controllers.GetVCDClientCondition(current)
+	g.Expect(ok).To(gomega.BeTrue())
+	g.Expect(condition.Type).To(gomega.Equal(controllers.VCDClientReadyCondition))
+	g.Expect(condition.Reason).To(gomega.Equal(vcd.ReasonSiteUnreachable))
+	g.Expect(condition.Failures).To(gomega.Equal(3))
+	g.Expect(condition.GaveUp).To(gomega.BeFalse())
+	g.Expect(drainEvents(recorder)).To(gomega.HaveEach(gomega.HavePrefix("Warning VCDClientFailed")))
+
+	// Once the client can be built again the clean-up goes on.
+	r.NewVCDClient = newVCDClient
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(stub.callCount()).To(gomega.Equal(1))
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+}
+
+func TestReconcileDeleteGivesUpOnVCDClientError(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	stub := &stubCleaner{name: stubCleanerName}
+	r := newReconciler([]*stubCleaner{stub})
+	r.VCDClientBackoff = controllers.VCDClientBackoff{
+		GiveUpAfter:  time.Nanosecond,
+		GiveUpPolicy: controllers.GiveUpKeepFinalizer,
+	}
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	r.NewVCDClient = func(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error) {
 		return nil, errStubVCDClient
 	}
 
+	// Keeping the finalizer stops the requeues.
 	result, err := r.Reconcile(ctx, reconcileRequest(name))
-
-	// Current behaviour: the error is swallowed and no requeue is asked for, so
-	// the object stays terminating until something else reconciles it.
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(result.IsZero()).To(gomega.BeTrue())
+
+	current := getVCDCluster(t, ctx, name)
+	g.Expect(current.Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
+	condition, ok := controllers.GetVCDClientCondition(current)
+	g.Expect(ok).To(gomega.BeTrue())
+	g.Expect(condition.Reason).To(gomega.Equal(vcd.ReasonClientFailed))
+	g.Expect(condition.GaveUp).To(gomega.BeTrue())
+
+	// Removing it lets the deletion go on without cleaning up.
+	r.VCDClientBackoff.GiveUpPolicy = controllers.GiveUpRemoveFinalizer
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(stub.callCount()).To(gomega.Equal(0))
-	g.Expect(getVCDCluster(t, ctx, name).Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+}
+
+// TestVCDClusterChangedIgnoresClientCondition checks that recording a failed
+// VCD client on the VCDCluster does not queue it again, which would skip the
+// backoff and log in to VCD in a loop.
+func TestVCDClusterChangedIgnoresClientCondition(t *testing.T) {
+	g := gomega.NewWithT(t)
+	changed := controllers.VCDClusterChanged()
+
+	oldObj := newVCDCluster("changed", "https://vcd.invalid", nil)
+	oldObj.Status.InfraId = infraIdFor(t)
+
+	conditionOnly := oldObj.DeepCopy()
+	conditionOnly.Annotations = map[string]string{key.VCDClientConditionAnnotation: `{"failures":2}`}
+	g.Expect(changed.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: conditionOnly})).To(gomega.BeFalse())
+
+	// Neither does the progress of the cleaners nor the notifications sent.
+	progress := conditionOnly.DeepCopy()
+	progress.Annotations[key.CleanerStatusAnnotation(cleaner.DNATCleanerName)] = `{"deleted":1}`
+	progress.Annotations[key.CleanerTasksAnnotation(cleaner.VolumeCleanerName)] = `["https://vcd.invalid/api/task/1"]`
+	progress.Annotations[key.NotificationsAnnotation] = `{"failures":1}`
+	g.Expect(changed.Update(event.UpdateEvent{ObjectOld: conditionOnly, ObjectNew: progress})).To(gomega.BeFalse())
+
+	// Annotations users set still count, e.g. pausing the cluster.
+	paused := conditionOnly.DeepCopy()
+	paused.Annotations[capi.PausedAnnotation] = "true"
+	g.Expect(changed.Update(event.UpdateEvent{ObjectOld: conditionOnly, ObjectNew: paused})).To(gomega.BeTrue())
+
+	deleted := conditionOnly.DeepCopy()
+	deleted.DeletionTimestamp = ptr.To(metav1.Now())
+	g.Expect(changed.Update(event.UpdateEvent{ObjectOld: conditionOnly, ObjectNew: deleted})).To(gomega.BeTrue())
+
+	reassigned := oldObj.DeepCopy()
+	reassigned.Status.InfraId = otherInfraId
+	g.Expect(changed.Update(event.UpdateEvent{ObjectOld: oldObj, ObjectNew: reassigned})).To(gomega.BeTrue())
+}
+
+func TestVCDClustersForSecret(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	secret := createSecret(t, ctx, name, map[string][]byte{
+		"username": []byte(testUsername),
+		"password": []byte(testPassword),
+	})
+
+	newDeletingVCDCluster := func(name, secretName string) *capvcd.VCDCluster {
+		vcdCluster := newVCDCluster(name, "https://vcd.invalid", nil)
+		vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(secretName)
+		vcdCluster.Finalizers = []string{key.CleanerFinalizerName}
+		createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+		g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+		return vcdCluster
+	}
+
+	deleting := newDeletingVCDCluster(name+"-deleting", name)
+	newDeletingVCDCluster(name+"-other-secret", name+"-other")
+	running := newVCDCluster(name+"-running", "https://vcd.invalid", nil)
+	running.Spec.UserCredentialsContext = credentialsFromSecret(name)
+	createVCDCluster(t, ctx, running, infraIdFor(t))
+
+	// Only the clean-up waiting for the secret is resumed.
+	r := newReconciler(nil)
+	g.Expect(r.VCDClustersForSecret(ctx, secret)).To(gomega.ConsistOf(reconcileRequest(deleting.Name)))
+
+	// The same VCDClusters are looked up in the index of the cache.
+	r.Client = newIndexedClient(t, ctx)
+	r.SecretRefsIndexed = true
+	g.Eventually(func() []reconcile.Request {
+		return r.VCDClustersForSecret(ctx, secret)
+	}).Should(gomega.ConsistOf(reconcileRequest(deleting.Name)))
+}
+
+// newIndexedClient builds a client that reads VCDClusters from a cache with
+// the index of controllers.IndexSecretRefs, as the manager does.
+func newIndexedClient(t *testing.T, ctx context.Context) client.Client {
+	t.Helper()
+	g := gomega.NewWithT(t)
+
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	c, err := cache.New(testConfig, cache.Options{Scheme: testScheme})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(controllers.IndexSecretRefs(ctx, c, nil)).To(gomega.Succeed())
+	go func() {
+		_ = c.Start(ctx)
+	}()
+	g.Expect(c.WaitForCacheSync(ctx)).To(gomega.BeTrue())
+
+	indexed, err := client.New(testConfig, client.Options{Scheme: testScheme, Cache: &client.CacheOptions{Reader: c}})
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	return indexed
+}
+
+func TestReconcileProtectsCredentialsSecret(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	createSecret(t, ctx, name, map[string][]byte{
+		"username": []byte(testUsername),
+		"password": []byte(testPassword),
+	})
+	secretHasFinalizer := func() bool {
+		var secret corev1.Secret
+		g.Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: name}, &secret)).To(gomega.Succeed())
+		return controllerutil.ContainsFinalizer(&secret, key.SecretFinalizerName)
+	}
+
+	newVCDClusterWithSecret := func(name, secretName string) *capvcd.VCDCluster {
+		cluster := createCluster(t, ctx, newCluster(name))
+		vcdCluster := newVCDCluster(name, "https://vcd.invalid", cluster)
+		vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(secretName)
+		return createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+	}
+	first := newVCDClusterWithSecret(name+"-first", name)
+	second := newVCDClusterWithSecret(name+"-second", name)
+
+	r := newReconciler(nil)
+	for _, vcdCluster := range []*capvcd.VCDCluster{first, second} {
+		_, err := r.Reconcile(ctx, reconcileRequest(vcdCluster.Name))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+	g.Expect(secretHasFinalizer()).To(gomega.BeTrue())
+
+	// The secret stays protected while another VCDCluster logs in with it.
+	g.Expect(k8sClient.Delete(ctx, first)).To(gomega.Succeed())
+	_, err := r.Reconcile(ctx, reconcileRequest(first.Name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vcdClusterIsGone(ctx, first.Name)).To(gomega.BeTrue())
+	g.Expect(secretHasFinalizer()).To(gomega.BeTrue())
+
+	g.Expect(k8sClient.Delete(ctx, second)).To(gomega.Succeed())
+	_, err = r.Reconcile(ctx, reconcileRequest(second.Name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vcdClusterIsGone(ctx, second.Name)).To(gomega.BeTrue())
+	g.Expect(secretHasFinalizer()).To(gomega.BeFalse())
+}
+
+func TestCredentialsSecretReconcilerReleasesUnusedSecrets(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	for _, secretName := range []string{name + "-old", name + "-new", name + "-unlabelled"} {
+		createSecret(t, ctx, secretName, map[string][]byte{
+			"username": []byte(testUsername),
+			"password": []byte(testPassword),
+		})
+	}
+	secretHasFinalizer := func(secretName string) bool {
+		var secret corev1.Secret
+		g.Expect(k8sClient.Get(ctx, client.ObjectKey{Namespace: testNamespace, Name: secretName}, &secret)).To(gomega.Succeed())
+		return controllerutil.ContainsFinalizer(&secret, key.SecretFinalizerName)
+	}
+
+	r := newReconciler(nil)
+	secrets := &controllers.CredentialsSecretReconciler{Client: k8sClient, Log: logr.Discard()}
+	releaseSecret := func(secretName string) {
+		_, err := secrets.Reconcile(ctx, reconcileRequest(secretName))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := newVCDCluster(name, "https://vcd.invalid", cluster)
+	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name + "-old")
+	vcdCluster = createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	releaseSecret(name + "-old")
+	g.Expect(secretHasFinalizer(name + "-old")).To(gomega.BeTrue())
+
+	// Switching to another secret releases the one no longer used.
+	vcdCluster = getVCDCluster(t, ctx, name)
+	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name + "-new")
+	g.Expect(k8sClient.Update(ctx, vcdCluster)).To(gomega.Succeed())
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	releaseSecret(name + "-old")
+	releaseSecret(name + "-new")
+	g.Expect(secretHasFinalizer(name + "-old")).To(gomega.BeFalse())
+	g.Expect(secretHasFinalizer(name + "-new")).To(gomega.BeTrue())
+
+	// So does removing the finalizer of the VCDCluster by hand.
+	vcdCluster = getVCDCluster(t, ctx, name)
+	controllerutil.RemoveFinalizer(vcdCluster, key.CleanerFinalizerName)
+	g.Expect(k8sClient.Update(ctx, vcdCluster)).To(gomega.Succeed())
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+	g.Eventually(func() bool { return vcdClusterIsGone(ctx, name) }).Should(gomega.BeTrue())
+	releaseSecret(name + "-new")
+	g.Expect(secretHasFinalizer(name + "-new")).To(gomega.BeFalse())
+
+	// A VCDCluster without the cluster name label is never cleaned up, so it
+	// does not need its secret once deleted.
+	unlabelled := newVCDCluster(name+"-unlabelled", "https://vcd.invalid", createCluster(t, ctx, newCluster(name+"-unlabelled")))
+	unlabelled.Spec.UserCredentialsContext = credentialsFromSecret(name + "-unlabelled")
+	unlabelled = createVCDCluster(t, ctx, unlabelled, infraIdFor(t))
+	_, err = r.Reconcile(ctx, reconcileRequest(unlabelled.Name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	unlabelled = getVCDCluster(t, ctx, unlabelled.Name)
+	delete(unlabelled.Labels, key.CapiClusterLabelKey)
+	g.Expect(k8sClient.Update(ctx, unlabelled)).To(gomega.Succeed())
+	g.Expect(k8sClient.Delete(ctx, unlabelled)).To(gomega.Succeed())
+	releaseSecret(name + "-unlabelled")
+	g.Expect(secretHasFinalizer(name + "-unlabelled")).To(gomega.BeFalse())
+}
+
+func TestReconcileDeleteInvalidatesRefusedVCDSession(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	expired := &stubCleaner{name: "expired", err: fmt.Errorf("failed to delete disk:[pvc-one] [API Error: 401: session expired]")}
+	r := newReconciler([]*stubCleaner{expired})
+	var invalidated []*vcdsdk.Client
+	r.InvalidateVCDClient = func(vcdClient *vcdsdk.Client) {
+		invalidated = append(invalidated, vcdClient)
+	}
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(invalidated).To(gomega.HaveLen(1))
+
+	// Other errors keep the session.
+	expired.err = errStubVCDClient
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(invalidated).To(gomega.HaveLen(1))
+}
+
+func TestReconcileDeleteDryRun(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	volumes := &stubCleaner{name: "volumes", plan: []cleaner.Object{{Kind: cleaner.KindDisk, ID: "disk-1", Name: "pvc-one"}}}
+	dnats := &stubCleaner{name: "dnats", plan: []cleaner.Object{{Kind: cleaner.KindDNATRule, ID: "nat-1", Name: "dnat-" + name}}}
+	r := newReconciler([]*stubCleaner{volumes, dnats})
+	r.DryRun = true
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	result, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(result.IsZero()).To(gomega.BeTrue())
+
+	// Only the plan runs, nothing is cleaned.
+	g.Expect(volumes.planCalls).To(gomega.Equal(1))
+	g.Expect(dnats.planCalls).To(gomega.Equal(1))
+	g.Expect(volumes.callCount()).To(gomega.Equal(0))
+	g.Expect(dnats.callCount()).To(gomega.Equal(0))
+
+	// The plan is recorded and the object is kept, so nothing leaks in VCD.
+	current := getVCDCluster(t, ctx, name)
+	g.Expect(current.Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
+	g.Expect(current.Annotations[key.DryRunPlanAnnotation]).To(gomega.MatchJSON(
+		`[{"kind":"disk","id":"disk-1","name":"pvc-one"},{"kind":"dnatRule","id":"nat-1","name":"dnat-` + name + `"}]`))
+
+	// An unchanged plan is not written again.
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(getVCDCluster(t, ctx, name).ResourceVersion).To(gomega.Equal(current.ResourceVersion))
+}
+
+func TestReconcileDeleteDryRunCapsPlan(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	const planned = 5000
+	plan := make([]cleaner.Object, 0, planned)
+	for i := 0; i < planned; i++ {
+		plan = append(plan, cleaner.Object{Kind: cleaner.KindDisk, ID: fmt.Sprintf("disk-%d", i), Name: fmt.Sprintf("pvc-%d", i)})
+	}
+	r := newReconciler([]*stubCleaner{{name: "volumes", plan: plan}})
+	r.DryRun = true
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// The plan keeps the first objects, and tells how many it leaves out.
+	current := getVCDCluster(t, ctx, name)
+	encoded := current.Annotations[key.DryRunPlanAnnotation]
+	g.Expect(len(encoded)).To(gomega.BeNumerically("<=", 64<<10))
+
+	var recorded []cleaner.Object
+	g.Expect(json.Unmarshal([]byte(encoded), &recorded)).To(gomega.Succeed())
+	g.Expect(recorded).NotTo(gomega.BeEmpty())
+	g.Expect(recorded[0].ID).To(gomega.Equal("disk-0"))
+
+	omitted, err := strconv.Atoi(current.Annotations[key.DryRunPlanOmittedAnnotation])
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(len(recorded) + omitted).To(gomega.Equal(planned))
 }
 
 // vcdClusterIsGone reports whether the object left the api server.
@@ -20,14 +20,20 @@ package integration
 
 import (
 	"context"
+	"net/url"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/onsi/gomega"
 	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcd"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/test/integration/vcdfake"
 )
 
@@ -47,9 +53,9 @@ func TestGetVCDClientFromSecret(t *testing.T) {
 
 	vcdCluster := newVCDCluster(name, server.URL(), nil)
 	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name)
-	createVCDCluster(t, ctx, vcdCluster, "infra-"+name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
 
-	client, err := vcd.GetVCDClient(ctx, k8sClient, vcdCluster, logr.Discard())
+	client, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 	g.Expect(client.ClusterOrgName).To(gomega.Equal(testOrgName))
 	g.Expect(client.ClusterOVDCName).To(gomega.Equal(testVdcName))
@@ -75,9 +81,9 @@ func TestGetVCDClientTrimsSecretNewlines(t *testing.T) {
 
 	vcdCluster := newVCDCluster(name, server.URL(), nil)
 	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name)
-	createVCDCluster(t, ctx, vcdCluster, "infra-"+name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
 
-	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdCluster, logr.Discard())
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 
 	// The fake rejects the login unless it receives the trimmed values.
@@ -95,9 +101,9 @@ func TestGetVCDClientFromInlineCredentials(t *testing.T) {
 	server := newVCDServer(t, vcdfake.Config{})
 
 	vcdCluster := newVCDCluster(name, server.URL(), nil)
-	createVCDCluster(t, ctx, vcdCluster, "infra-"+name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
 
-	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdCluster, logr.Discard())
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
 	g.Expect(err).NotTo(gomega.HaveOccurred())
 
 	user, password := server.Credentials()
@@ -116,11 +122,13 @@ func TestGetVCDClientWithMissingSecret(t *testing.T) {
 
 	vcdCluster := newVCDCluster(name, server.URL(), nil)
 	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret("no-such-secret")
-	createVCDCluster(t, ctx, vcdCluster, "infra-"+name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
 
-	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdCluster, logr.Discard())
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
 	g.Expect(err).To(gomega.HaveOccurred())
 	g.Expect(err.Error()).To(gomega.ContainSubstring("no-such-secret"))
+	g.Expect(vcd.IsSecretNotFound(err)).To(gomega.BeTrue())
+	g.Expect(vcd.ClassifyClientError(err)).To(gomega.Equal(vcd.ReasonSecretNotFound))
 }
 
 // TestGetVCDClientWithWrongPassword checks that a rejected login is an error
@@ -134,10 +142,359 @@ func TestGetVCDClientWithWrongPassword(t *testing.T) {
 
 	vcdCluster := newVCDCluster(name, server.URL(), nil)
 	vcdCluster.Spec.UserCredentialsContext.Password = "wrong-password"
-	createVCDCluster(t, ctx, vcdCluster, "infra-"+name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
 
-	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdCluster, logr.Discard())
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
 	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(vcd.IsInvalidCredentials(err)).To(gomega.BeTrue())
+}
+
+// TestGetVCDClientWithUnreachableSite checks that a site nobody listens on is
+// told apart from bad credentials.
+func TestGetVCDClientWithUnreachableSite(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{})
+	site := server.URL()
+	server.Close()
+
+	vcdCluster := newVCDCluster(name, site, nil)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(vcd.IsSiteUnreachable(err)).To(gomega.BeTrue())
+}
+
+// TestClientCacheReusesSessions checks that a session is reused until the
+// credentials change, it is invalidated or it gets too old.
+func TestClientCacheReusesSessions(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{})
+
+	secret := createSecret(t, ctx, name, map[string][]byte{
+		"username": []byte(testUsername),
+		"password": []byte(testPassword),
+	})
+
+	vcdCluster := newVCDCluster(name, server.URL(), nil)
+	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	cache := vcd.NewClientCache(time.Hour, 0)
+
+	first, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	second, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(second).To(gomega.BeIdenticalTo(first))
+
+	// Changed credentials log in again, and the fake refuses them.
+	secret.Data["password"] = []byte("rotated")
+	g.Expect(k8sClient.Update(ctx, secret)).To(gomega.Succeed())
+	_, err = cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(vcd.IsInvalidCredentials(err)).To(gomega.BeTrue())
+
+	secret.Data["password"] = []byte(testPassword)
+	g.Expect(k8sClient.Update(ctx, secret)).To(gomega.Succeed())
+	third, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(third).To(gomega.BeIdenticalTo(first))
+
+	// A session VCD refused is not handed out again.
+	cache.Invalidate(first)
+	fourth, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fourth).NotTo(gomega.BeIdenticalTo(first))
+
+	// Neither is one that is too old.
+	cache.MaxAge = 100 * time.Millisecond
+	time.Sleep(150 * time.Millisecond)
+	fifth, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fifth).NotTo(gomega.BeIdenticalTo(fourth))
+}
+
+// TestClientCacheReusesServiceAccountSessions checks that the session of a
+// service account is kept while the cleaner rotates its token, and dropped
+// when the token is replaced outside of the cache.
+func TestClientCacheReusesServiceAccountSessions(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{ServiceAccountToken: "sa-token"})
+
+	createSecret(t, ctx, name, map[string][]byte{
+		"serviceAccountToken": []byte("sa-token"),
+	})
+
+	vcdCluster := newVCDCluster(name, server.URL(), nil)
+	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	cache := vcd.NewClientCache(time.Hour, 0)
+
+	first, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	second, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(second).To(gomega.BeIdenticalTo(first))
+	g.Expect(server.TokenExchanges()).To(gomega.Equal(1))
+
+	// Another login rotates the token in the secret, the cache logs in with
+	// the new one.
+	_, err = vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	third, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(third).NotTo(gomega.BeIdenticalTo(first))
+	g.Expect(server.TokenExchanges()).To(gomega.Equal(3))
+
+	fourth, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fourth).To(gomega.BeIdenticalTo(third))
+	g.Expect(server.TokenExchanges()).To(gomega.Equal(3))
+
+	// A session VCD refused is dropped under its stable key.
+	cache.Invalidate(third)
+	fifth, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(fifth).NotTo(gomega.BeIdenticalTo(third))
+}
+
+// TestClientCacheKeysSessionsOnConnection checks that clusters with the same
+// credentials do not share a session when they trust different certificates.
+func TestClientCacheKeysSessionsOnConnection(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{TLS: true})
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			vcd.CABundleKey: string(server.Certificate()),
+		},
+	}
+	g.Expect(k8sClient.Create(ctx, configMap)).To(gomega.Succeed())
+	t.Cleanup(func() {
+		_ = k8sClient.Delete(context.Background(), configMap)
+	})
+
+	trusting := newVCDCluster(name, server.URL(), nil)
+	trusting.Annotations = map[string]string{key.CABundleAnnotation: name}
+	createVCDCluster(t, ctx, trusting, infraIdFor(t))
+
+	other := newVCDCluster(name+"-other", server.URL(), nil)
+	createVCDCluster(t, ctx, other, infraIdFor(t))
+
+	cache := vcd.NewClientCache(time.Hour, 0)
+
+	_, err := cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(trusting), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// The session verified with the ca bundle of the first cluster is not
+	// handed to one that does not trust the certificate.
+	_, err = cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(other), logr.Discard())
+	g.Expect(vcd.IsUntrustedCertificate(err)).To(gomega.BeTrue())
+
+	cache.Transport = &vcd.Transport{InsecureSites: []string{server.URL()}}
+	_, err = cache.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(other), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+}
+
+// TestGetVCDClientWithAPIToken checks that an api token is exchanged for a
+// session instead of a password login.
+func TestGetVCDClientWithAPIToken(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{APIToken: "api-token"})
+
+	createSecret(t, ctx, name, map[string][]byte{
+		"apiToken": []byte("api-token\n"),
+	})
+
+	vcdCluster := newVCDCluster(name, server.URL(), nil)
+	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server.TokenExchanges()).To(gomega.Equal(1))
+
+	user, _ := server.Credentials()
+	g.Expect(user).To(gomega.BeEmpty())
+}
+
+// TestGetVCDClientWithServiceAccountToken checks that the token VCD hands out
+// in exchange for a service account token is written back, and used by the
+// next login.
+func TestGetVCDClientWithServiceAccountToken(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{ServiceAccountToken: "sa-token"})
+
+	secret := createSecret(t, ctx, name, map[string][]byte{
+		"serviceAccountToken": []byte("sa-token"),
+	})
+
+	vcdCluster := newVCDCluster(name, server.URL(), nil)
+	vcdCluster.Spec.UserCredentialsContext = credentialsFromSecret(name)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server.ServiceAccountToken()).To(gomega.Equal("sa-token-1"))
+
+	g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: name, Namespace: testNamespace}, secret)).To(gomega.Succeed())
+	g.Expect(string(secret.Data["serviceAccountToken"])).To(gomega.Equal("sa-token-1"))
+
+	// The fake refuses the spent token, so the second login must use the
+	// new one.
+	_, err = vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server.ServiceAccountToken()).To(gomega.Equal("sa-token-2"))
+	g.Expect(server.TokenExchanges()).To(gomega.Equal(2))
+}
+
+// TestGetVCDClientVerifiesCertificates checks that the certificate of a site
+// is verified unless the site opted out, and that the ca bundle of a
+// VCDCluster is trusted.
+func TestGetVCDClientVerifiesCertificates(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{TLS: true})
+
+	vcdCluster := newVCDCluster(name, server.URL(), nil)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	// The fake certificate is self-signed.
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(vcd.IsUntrustedCertificate(err)).To(gomega.BeTrue())
+	g.Expect(vcd.ClassifyClientError(err)).To(gomega.Equal(vcd.ReasonUntrustedCertificate))
+
+	insecure := &vcd.Transport{InsecureSites: []string{server.URL()}}
+	_, err = insecure.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	trusted := &vcd.Transport{CABundle: server.Certificate()}
+	_, err = trusted.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// The annotation names a ConfigMap that does not exist yet.
+	vcdCluster.Annotations = map[string]string{key.CABundleAnnotation: name}
+	_, err = vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(vcd.IsInvalidCABundle(err)).To(gomega.BeTrue())
+
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+		Data: map[string]string{
+			vcd.CABundleKey: string(server.Certificate()),
+		},
+	}
+	g.Expect(k8sClient.Create(ctx, configMap)).To(gomega.Succeed())
+	t.Cleanup(func() {
+		_ = k8sClient.Delete(context.Background(), configMap)
+	})
+
+	_, err = vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+}
+
+// TestGetVCDClientThroughProxy checks that both the govcd and the swagger
+// clients reach a site through its proxy, authenticated with the credentials
+// of its secret.
+func TestGetVCDClientThroughProxy(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	// vcd.invalid does not resolve, the site is only reached through the
+	// proxy.
+	server := newVCDServer(t, vcdfake.Config{Host: "vcd.invalid"})
+	proxy := vcdfake.NewProxy("proxy-user", "proxy-password")
+	t.Cleanup(proxy.Close)
+
+	createSecret(t, ctx, name, map[string][]byte{
+		"username": []byte("proxy-user"),
+		"password": []byte("proxy-password"),
+	})
+
+	vcdCluster := newVCDCluster(name, server.URL(), nil)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	_, err := vcd.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(vcd.IsSiteUnreachable(err)).To(gomega.BeTrue())
+
+	transport := &vcd.Transport{Proxies: []vcd.Proxy{{
+		Site: server.URL(),
+		URL:  proxy.URL(),
+		CredentialsSecret: &corev1.SecretReference{
+			Name:      name,
+			Namespace: testNamespace,
+		},
+	}}}
+	client, err := transport.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// The gateway is looked up with the swagger client.
+	_, err = vcd.GetGateway(ctx, client, vcdcluster.FromV1beta1(vcdCluster).DefaultZone())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(proxy.Requests()).To(gomega.ContainElement(gomega.ContainSubstring("/api/versions")))
+	g.Expect(proxy.Requests()).To(gomega.ContainElement(gomega.ContainSubstring("/cloudapi/1.0.0/orgVdcNetworks")))
+
+	// The proxy refuses requests without its credentials.
+	transport.Proxies[0].CredentialsSecret = nil
+	_, err = transport.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).To(gomega.HaveOccurred())
+}
+
+// TestGetVCDClientIsRateLimited checks that the requests to a site wait for
+// its rate limit, and that the wait is counted.
+func TestGetVCDClientIsRateLimited(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	server := newVCDServer(t, vcdfake.Config{})
+
+	vcdCluster := newVCDCluster(name, server.URL(), nil)
+	createVCDCluster(t, ctx, vcdCluster, infraIdFor(t))
+
+	transport := &vcd.Transport{
+		RateLimit:         vcd.RateLimit{RequestsPerSecond: 10, Burst: 1},
+		ManagementCluster: name,
+	}
+
+	// Logging in takes half a dozen requests.
+	start := time.Now()
+	_, err := transport.GetVCDClient(ctx, k8sClient, vcdcluster.FromV1beta1(vcdCluster), logr.Discard())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(time.Since(start)).To(gomega.BeNumerically(">=", 400*time.Millisecond))
+
+	site, err := url.Parse(server.URL())
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(metricValue(t, "cluster_api_cleaner_cloud_director_vcd_requests_throttled_total",
+		map[string]string{"management_cluster": name, "site": site.Host})).To(gomega.BeNumerically(">=", 4))
 }
 
 // credentialsFromSecret points the cluster at a credentials secret.
@@ -21,21 +21,28 @@ package integration
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-logr/logr"
 	"github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
 	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	"k8s.io/utils/ptr"
 	capi "sigs.k8s.io/cluster-api/api/core/v1beta2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
 	"github.com/giantswarm/cluster-api-cleaner-cloud-director/test/integration/vcdfake"
 )
 
@@ -157,7 +164,7 @@ func createVCDCluster(t *testing.T, ctx context.Context, vcdCluster *capvcd.VCDC
 
 // cleanupVCDCluster removes the object even when the test left the finalizer on
 // it, so that a failing test does not block the next run.
-func cleanupVCDCluster(vcdCluster *capvcd.VCDCluster) {
+func cleanupVCDCluster(vcdCluster client.Object) {
 	ctx := context.Background()
 
 	current := &capvcd.VCDCluster{}
@@ -232,20 +239,67 @@ func reconcileRequest(name string) ctrl.Request {
 }
 
 // stubCleaner records the clusters it was asked to clean and returns a scripted
-// result.
+// result. It reports the objects in deleted as removed.
 type stubCleaner struct {
 	name    string
 	requeue bool
 	err     error
+	plan    []cleaner.Object
+	deleted []cleaner.Object
+	// retained are reported as left alone on every run.
+	retained []cleaner.Object
+	// dependsOn names the cleaners that have to succeed first.
+	dependsOn []string
+	// rendezvous makes Clean wait until every cleaner sharing it is running.
+	rendezvous *sync.WaitGroup
+
+	calls     []*vcdcluster.Cluster
+	planCalls int
+	order     *[]string
+}
+
+// orderMu guards the order slices shared by cleaners running in parallel.
+var orderMu sync.Mutex
 
-	calls []*capvcd.VCDCluster
-	order *[]string
+func (s *stubCleaner) Name() string {
+	return s.name
 }
 
-func (s *stubCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *capvcd.VCDCluster) (bool, error) {
+func (s *stubCleaner) DependsOn() []string {
+	return s.dependsOn
+}
+
+func (s *stubCleaner) Plan(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) ([]cleaner.Object, error) {
+	s.planCalls++
+
+	return s.plan, s.err
+}
+
+func (s *stubCleaner) Clean(ctx context.Context, log logr.Logger, vcdClient *vcdsdk.Client, c *vcdcluster.Cluster) (bool, error) {
 	s.calls = append(s.calls, c.DeepCopy())
 	if s.order != nil {
+		orderMu.Lock()
 		*s.order = append(*s.order, s.name)
+		orderMu.Unlock()
+	}
+	if s.rendezvous != nil {
+		s.rendezvous.Done()
+		met := make(chan struct{})
+		go func() {
+			s.rendezvous.Wait()
+			close(met)
+		}()
+		select {
+		case <-met:
+		case <-time.After(10 * time.Second):
+			return false, errStubRendezvous
+		}
+	}
+	for _, o := range s.deleted {
+		cleaner.ReportDeleted(ctx, o)
+	}
+	for _, o := range s.retained {
+		cleaner.ReportRetained(ctx, o)
 	}
 
 	return s.requeue, s.err
@@ -257,3 +311,64 @@ func (s *stubCleaner) callCount() int {
 }
 
 var errStubVCDClient = fmt.Errorf("stub vcd client failure")
+
+var errStubRendezvous = fmt.Errorf("stub cleaner timed out waiting for the cleaners it should run with")
+
+// errStubUnreachable reads like the error vcdsdk returns for a site nobody
+// listens on.
+var errStubUnreachable = fmt.Errorf("unable to get swagger client from secrets: [error finding LoginUrl: dial tcp 127.0.0.1:1: connect: connection refused]")
+
+// drainEvents returns the events recorded so far.
+func drainEvents(recorder *events.FakeRecorder) []string {
+	var recorded []string
+	for {
+		select {
+		case e := <-recorder.Events:
+			recorded = append(recorded, e)
+		default:
+			return recorded
+		}
+	}
+}
+
+// metricValue returns the value of the counter, or the sample count of the
+// histogram, with the given labels in the controller-runtime registry.
+func metricValue(t *testing.T, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if !hasLabels(m.GetLabel(), labels) {
+				continue
+			}
+			if m.GetHistogram() != nil {
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+
+	return 0
+}
+
+func hasLabels(pairs []*dto.LabelPair, labels map[string]string) bool {
+	matched := 0
+	for _, pair := range pairs {
+		if value, ok := labels[pair.GetName()]; ok {
+			if value != pair.GetValue() {
+				return false
+			}
+			matched++
+		}
+	}
+
+	return matched == len(labels)
+}
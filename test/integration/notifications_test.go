@@ -0,0 +1,302 @@
+//go:build integration
+
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/onsi/gomega"
+	"github.com/vmware/cloud-provider-for-cloud-director/pkg/vcdsdk"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/controllers"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/notify"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// notificationEndpoint records the payloads posted to it.
+type notificationEndpoint struct {
+	mu       sync.Mutex
+	payloads [][]byte
+}
+
+func (e *notificationEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.payloads = append(e.payloads, body)
+}
+
+func (e *notificationEndpoint) Payloads() []string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	payloads := make([]string, 0, len(e.payloads))
+	for _, p := range e.payloads {
+		payloads = append(payloads, string(p))
+	}
+	return payloads
+}
+
+func (e *notificationEndpoint) Notifications(t *testing.T) []notify.Notification {
+	t.Helper()
+	g := gomega.NewWithT(t)
+
+	var notifications []notify.Notification
+	for _, p := range e.Payloads() {
+		var n notify.Notification
+		g.Expect(json.Unmarshal([]byte(p), &n)).To(gomega.Succeed())
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+func TestReconcileDeleteNotifiesRepeatedFailuresAndCompletion(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+	infraId := infraIdFor(t)
+
+	endpoint := &notificationEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraId)
+
+	done := &stubCleaner{name: "done", deleted: []cleaner.Object{{Kind: cleaner.KindDisk, Name: "pvc-one"}}}
+	failing := &stubCleaner{name: "failing", err: errStubVCDClient}
+	r := newReconciler([]*stubCleaner{done, failing})
+	r.ManagementCluster = "test-mc"
+	r.Notifier = &notify.Notifier{Endpoints: []notify.Endpoint{{URL: server.URL}}}
+	r.NotifyAfterFailures = 2
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	// The first failure is not notified yet.
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).To(gomega.HaveOccurred())
+	g.Expect(endpoint.Payloads()).To(gomega.BeEmpty())
+
+	// The second one is, and the third one not again.
+	for range 2 {
+		_, err = r.Reconcile(ctx, reconcileRequest(name))
+		g.Expect(err).To(gomega.HaveOccurred())
+	}
+	g.Expect(endpoint.Notifications(t)).To(gomega.ConsistOf(gomega.And(
+		gomega.HaveField("Event", notify.EventFailed),
+		gomega.HaveField("ManagementCluster", "test-mc"),
+		gomega.HaveField("Namespace", testNamespace),
+		gomega.HaveField("Cluster", name),
+		gomega.HaveField("VCDCluster", name),
+		gomega.HaveField("InfraId", infraId),
+		gomega.HaveField("Failures", 2),
+		gomega.HaveField("Errors", gomega.ContainElement(gomega.ContainSubstring(errStubVCDClient.Error()))),
+		gomega.HaveField("Objects", gomega.HaveKeyWithValue(cleaner.KindDisk, gomega.HaveKey(cleanerv1alpha1.OutcomeDeleted))),
+	)))
+	g.Expect(getVCDCluster(t, ctx, name).Annotations).To(gomega.HaveKey(key.NotificationsAnnotation))
+
+	failing.err = nil
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+
+	notifications := endpoint.Notifications(t)
+	g.Expect(notifications).To(gomega.HaveLen(2))
+	g.Expect(notifications[1]).To(gomega.And(
+		gomega.HaveField("Event", notify.EventCompleted),
+		gomega.HaveField("Cluster", name),
+		gomega.HaveField("InfraId", infraId),
+		gomega.HaveField("Objects", gomega.HaveKeyWithValue(cleaner.KindDisk, gomega.HaveKey(cleanerv1alpha1.OutcomeDeleted))),
+		gomega.HaveField("DurationSeconds", gomega.BeNumerically(">=", 0)),
+	))
+}
+
+func TestReconcileDeleteNotifiesSlowCleanup(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	endpoint := &notificationEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	// The endpoint only wants slow clean-ups, in its own format.
+	file := filepath.Join(t.TempDir(), "endpoints.yaml")
+	g.Expect(os.WriteFile(file, []byte(`- url: `+server.URL+`
+  events: [slow]
+  template: '{"text": {{ printf "%s is %s" .Cluster .Event | json }}}'
+`), 0o600)).To(gomega.Succeed())
+	endpoints, err := notify.LoadEndpoints(file)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	pending := &stubCleaner{name: "pending", requeue: true}
+	r := newReconciler([]*stubCleaner{pending})
+	r.Notifier = &notify.Notifier{Endpoints: endpoints}
+	r.NotifyAfter = time.Nanosecond
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	// A slow clean-up is notified once however often it is requeued.
+	for range 2 {
+		_, err = r.Reconcile(ctx, reconcileRequest(name))
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+	}
+	g.Expect(endpoint.Payloads()).To(gomega.ConsistOf(gomega.MatchJSON(`{"text": "` + name + ` is slow"}`)))
+
+	// The endpoint did not ask for the completion.
+	pending.requeue = false
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+	g.Expect(endpoint.Payloads()).To(gomega.HaveLen(1))
+}
+
+// TestReconcileDeleteNotifiesStuckCleanups checks that clean-ups that are not
+// retried, of an invalid infra id or a VCD client that was given up on, are
+// notified right away and requeued for their slow notification.
+func TestReconcileDeleteNotifiesStuckCleanups(t *testing.T) {
+	for name, tc := range map[string]struct {
+		infraId func(t *testing.T) string
+		setup   func(r *controllers.VCDClusterReconciler)
+	}{
+		"invalid infra id": {
+			infraId: func(t *testing.T) string { return "cluster" },
+		},
+		"gave up on the VCD client": {
+			infraId: infraIdFor,
+			setup: func(r *controllers.VCDClusterReconciler) {
+				r.VCDClientBackoff = controllers.VCDClientBackoff{
+					GiveUpAfter:  time.Nanosecond,
+					GiveUpPolicy: controllers.GiveUpKeepFinalizer,
+				}
+				r.NewVCDClient = func(ctx context.Context, c client.Client, vcdCluster *vcdcluster.Cluster, log logr.Logger) (*vcdsdk.Client, error) {
+					return nil, errStubVCDClient
+				}
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			g := gomega.NewWithT(t)
+			ctx := context.Background()
+			name := uniqueName(t)
+
+			endpoint := &notificationEndpoint{}
+			server := httptest.NewServer(endpoint)
+			defer server.Close()
+
+			cluster := createCluster(t, ctx, newCluster(name))
+			vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), tc.infraId(t))
+
+			r := newReconciler([]*stubCleaner{{name: stubCleanerName}})
+			r.Notifier = &notify.Notifier{Endpoints: []notify.Endpoint{{URL: server.URL}}}
+			r.NotifyAfterFailures = 3
+			r.NotifyAfter = time.Hour
+
+			_, err := r.Reconcile(ctx, reconcileRequest(name))
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+
+			g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+			if tc.setup != nil {
+				tc.setup(r)
+			}
+
+			// Nothing counts further failures, the first one is notified.
+			result, err := r.Reconcile(ctx, reconcileRequest(name))
+			g.Expect(err).NotTo(gomega.HaveOccurred())
+			g.Expect(endpoint.Notifications(t)).To(gomega.ConsistOf(gomega.And(
+				gomega.HaveField("Event", notify.EventFailed),
+				gomega.HaveField("Failures", 1),
+			)))
+
+			// It comes back once the slow notification is due.
+			g.Expect(result.RequeueAfter).To(gomega.And(
+				gomega.BeNumerically(">", 59*time.Minute),
+				gomega.BeNumerically("<=", time.Hour),
+			))
+			g.Expect(getVCDCluster(t, ctx, name).Finalizers).To(gomega.ContainElement(key.CleanerFinalizerName))
+		})
+	}
+}
+
+// TestBufferedNotifierSendsInTheBackground checks that notifications are
+// queued without waiting for the endpoint, and sent once the notifier runs.
+func TestBufferedNotifierSendsInTheBackground(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	endpoint := &notificationEndpoint{}
+	server := httptest.NewServer(endpoint)
+	defer server.Close()
+
+	notifier := notify.NewBufferedNotifier(&notify.Notifier{Endpoints: []notify.Endpoint{{URL: server.URL}}}, 1, logr.Discard())
+
+	// Nothing sends the buffer before the notifier is started.
+	g.Expect(notifier.Notify(ctx, notify.Notification{Event: notify.EventCompleted, Cluster: "first"})).To(gomega.Succeed())
+	g.Expect(notify.IsBufferFull(notifier.Notify(ctx, notify.Notification{Event: notify.EventCompleted, Cluster: "second"}))).To(gomega.BeTrue())
+	g.Expect(endpoint.Payloads()).To(gomega.BeEmpty())
+
+	go func() {
+		_ = notifier.Start(ctx)
+	}()
+
+	g.Eventually(func() []notify.Notification { return endpoint.Notifications(t) }).Should(
+		gomega.ConsistOf(gomega.HaveField("Cluster", "first")))
+}
+
+func TestLoadNotificationEndpointsRefusesInvalidTemplates(t *testing.T) {
+	g := gomega.NewWithT(t)
+
+	file := filepath.Join(t.TempDir(), "endpoints.yaml")
+	g.Expect(os.WriteFile(file, []byte(`- url: https://hooks.example.com
+  template: '{"text": {{ .Cluster }}}'
+`), 0o600)).To(gomega.Succeed())
+
+	// An unquoted empty cluster name renders no json.
+	_, err := notify.LoadEndpoints(file)
+	g.Expect(notify.IsInvalidEndpoint(err)).To(gomega.BeTrue())
+}
@@ -27,18 +27,24 @@ import (
 	"testing"
 
 	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	capvcdv1beta2 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta2"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	capi "sigs.k8s.io/cluster-api/api/core/v1beta2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	cleanerv1alpha1 "github.com/giantswarm/cluster-api-cleaner-cloud-director/api/v1alpha1"
 )
 
 var (
 	testScheme *runtime.Scheme
+	testConfig *rest.Config
 	k8sClient  client.Client
 )
 
@@ -49,14 +55,24 @@ func TestMain(m *testing.M) {
 func run(m *testing.M) int {
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true), zap.WriteTo(os.Stderr)))
 
-	testScheme = runtime.NewScheme()
-	// Register exactly the api versions that main.go registers, and no more.
 	// envtest injects a conversion webhook when it finds more than one version of
-	// a convertible Kind in the scheme. Nothing serves that webhook, so adding
-	// capvcd api/v1beta2 here breaks every VCDCluster test.
+	// a convertible Kind in its scheme. Nothing serves that webhook, so envtest
+	// only gets capvcd api/v1beta1. Without the webhook the api server serves
+	// every version of a VCDCluster as it is stored, so the client registers
+	// the same versions as main.go.
+	envtestScheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(envtestScheme))
+	utilruntime.Must(capvcd.AddToScheme(envtestScheme))
+	utilruntime.Must(capi.AddToScheme(envtestScheme))
+	utilruntime.Must(cleanerv1alpha1.AddToScheme(envtestScheme))
+
+	testScheme = runtime.NewScheme()
 	utilruntime.Must(clientgoscheme.AddToScheme(testScheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(testScheme))
 	utilruntime.Must(capvcd.AddToScheme(testScheme))
+	utilruntime.Must(capvcdv1beta2.AddToScheme(testScheme))
 	utilruntime.Must(capi.AddToScheme(testScheme))
+	utilruntime.Must(cleanerv1alpha1.AddToScheme(testScheme))
 
 	capvcdDir, err := moduleDir("github.com/vmware/cluster-api-provider-cloud-director")
 	if err != nil {
@@ -70,10 +86,11 @@ func run(m *testing.M) int {
 	}
 
 	testEnv := &envtest.Environment{
-		Scheme: testScheme,
+		Scheme: envtestScheme,
 		CRDDirectoryPaths: []string{
 			filepath.Join(capvcdDir, "config", "crd", "bases", "infrastructure.cluster.x-k8s.io_vcdclusters.yaml"),
 			filepath.Join(capiDir, "config", "crd", "bases", "cluster.x-k8s.io_clusters.yaml"),
+			filepath.Join("..", "..", "config", "crd", "bases"),
 		},
 		ErrorIfCRDPathMissing: true,
 	}
@@ -90,6 +107,7 @@ func run(m *testing.M) int {
 		}
 	}()
 
+	testConfig = cfg
 	k8sClient, err = client.New(cfg, client.Options{Scheme: testScheme})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error building client: %s\n", err)
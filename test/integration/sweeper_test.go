@@ -0,0 +1,325 @@
+//go:build integration
+
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/cleaner"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/sweeper"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/test/integration/vcdfake"
+)
+
+// rdeInfraId is an infra id the way CAPVCD hands them out.
+func rdeInfraId() string {
+	return "urn:vcloud:entity:vmware:capvcdCluster:" + string(uuid.NewUUID())
+}
+
+// cseInfraId is an infra id the way CSE hands them out.
+func cseInfraId() string {
+	return "urn:vcloud:entity:cse:nativeCluster:" + string(uuid.NewUUID())
+}
+
+// newLedger builds a ledger of its own for the test, holding infraIds without
+// a location.
+func newLedger(t *testing.T, ctx context.Context, infraIds ...string) *sweeper.Ledger {
+	t.Helper()
+	g := gomega.NewWithT(t)
+
+	ledger := &sweeper.Ledger{
+		Client:            k8sClient,
+		Namespace:         testNamespace,
+		Name:              uniqueName(t),
+		ManagementCluster: "test",
+	}
+	t.Cleanup(func() {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: ledger.Namespace, Name: ledger.Name}}
+		_ = k8sClient.Delete(context.Background(), cm)
+	})
+
+	for _, infraId := range infraIds {
+		g.Expect(ledger.Add(ctx, infraId, sweeper.Entry{})).To(gomega.Succeed())
+	}
+
+	return ledger
+}
+
+func TestOrphanSweeper(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	known := rdeInfraId()
+	orphaned := rdeInfraId()
+	// foreign is a cluster of another management cluster in the same vdc.
+	foreign := rdeInfraId()
+
+	server := newVCDServer(t, vcdfake.Config{
+		VAppName: name,
+		DiskPages: [][]vcdfake.Disk{
+			{
+				{ID: "disk-1", Name: "pvc-known", Description: known},
+				{ID: "disk-2", Name: "pvc-orphaned", Description: orphaned},
+				{ID: "disk-3", Name: "scratch", Description: "made by hand"},
+			},
+		},
+		NatRules: []vcdfake.Resource{
+			{ID: "nat-1", Name: "dnat-" + controlPlaneName(name, known)},
+			{ID: "nat-2", Name: "dnat-" + controlPlaneName("gone", orphaned)},
+			{ID: "nat-3", Name: "ssh-jumphost"},
+			{ID: "nat-4", Name: "dnat-" + controlPlaneName("foreign", foreign)},
+		},
+		Pools: []vcdfake.Resource{
+			{ID: "pool-1", Name: ingressName("ingress-pool-", "nginx", orphaned, "http")},
+		},
+	})
+	createVCDCluster(t, ctx, newVCDCluster(name, server.URL(), nil), known)
+	ledger := newLedger(t, ctx, known, orphaned)
+
+	s := &sweeper.OrphanSweeper{
+		Client: k8sClient,
+		Log:    logr.Discard(),
+		Cleaners: []cleaner.Cleaner{
+			cleaner.NewVolumeCleaner(k8sClient),
+			cleaner.NewVirtualServiceCleaner(k8sClient),
+			cleaner.NewLBPoolCleaner(k8sClient),
+			cleaner.NewDNATCleaner(k8sClient),
+			cleaner.NewAppPortProfileCleaner(k8sClient),
+		},
+		Delete: true,
+		Ledger: ledger,
+	}
+
+	// Objects without an infra id, and those of a known cluster, are not orphans.
+	orphans, err := s.Sweep(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(orphanNames(orphans, orphaned)).To(gomega.ConsistOf("pvc-orphaned", "dnat-"+controlPlaneName("gone", orphaned), ingressName("ingress-pool-", "nginx", orphaned, "http")))
+	g.Expect(orphanNames(orphans, known)).To(gomega.BeEmpty())
+	g.Expect(orphanNames(orphans, foreign)).To(gomega.ConsistOf("dnat-" + controlPlaneName("foreign", foreign)))
+	for _, o := range orphans {
+		g.Expect(o.Owned).To(gomega.Equal(o.InfraId == orphaned), o.Name)
+	}
+
+	// The first sweep only reports.
+	g.Expect(server.DeletedDisks()).To(gomega.BeEmpty())
+	g.Expect(server.DeletedNatRules()).To(gomega.BeEmpty())
+
+	// The second one deletes what it finds again.
+	_, err = s.Sweep(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server.DeletedDisks()).To(gomega.ConsistOf("pvc-orphaned"))
+	g.Expect(server.DeletedNatRules()).To(gomega.ConsistOf("dnat-" + controlPlaneName("gone", orphaned)))
+	g.Expect(server.DeletedPools()).To(gomega.ConsistOf(ingressName("ingress-pool-", "nginx", orphaned, "http")))
+
+	// Once its objects are gone the infra id leaves the ledger. The orphans
+	// of the other management cluster are only ever reported.
+	owned, err := ledger.Owned(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(owned).To(gomega.Equal(map[string]bool{known: true}))
+
+	orphans, err = s.Sweep(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(orphanNames(orphans, orphaned)).To(gomega.BeEmpty())
+	g.Expect(orphanNames(orphans, foreign)).To(gomega.ConsistOf("dnat-" + controlPlaneName("foreign", foreign)))
+
+	g.Expect(server.Unhandled()).To(gomega.BeEmpty())
+}
+
+func TestOrphanSweeperOnlyReportsWithoutDelete(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	orphaned := rdeInfraId()
+
+	server := newVCDServer(t, vcdfake.Config{
+		VAppName: name,
+		NatRules: []vcdfake.Resource{
+			{ID: "nat-1", Name: "dnat-" + controlPlaneName("gone", orphaned)},
+		},
+	})
+	createVCDCluster(t, ctx, newVCDCluster(name, server.URL(), nil), rdeInfraId())
+
+	s := &sweeper.OrphanSweeper{
+		Client:   k8sClient,
+		Log:      logr.Discard(),
+		Cleaners: []cleaner.Cleaner{cleaner.NewDNATCleaner(k8sClient)},
+		Ledger:   newLedger(t, ctx, orphaned),
+	}
+
+	for i := 0; i < 2; i++ {
+		orphans, err := s.Sweep(ctx)
+		g.Expect(err).NotTo(gomega.HaveOccurred())
+		g.Expect(orphanNames(orphans, orphaned)).To(gomega.ConsistOf("dnat-" + controlPlaneName("gone", orphaned)))
+	}
+	g.Expect(server.DeletedNatRules()).To(gomega.BeEmpty())
+}
+
+// TestOrphanSweeperMatchesTrimmedInfraIds checks that a CSE infra id, which the
+// cloud provider trims to a bare uuid, is matched with the clusters and the
+// ledger.
+func TestOrphanSweeperMatchesTrimmedInfraIds(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	known := cseInfraId()
+	orphaned := cseInfraId()
+
+	server := newVCDServer(t, vcdfake.Config{
+		VAppName: name,
+		Pools: []vcdfake.Resource{
+			{ID: "pool-1", Name: ingressName("ingress-pool-", "nginx", known, "http")},
+			{ID: "pool-2", Name: ingressName("ingress-pool-", "nginx", orphaned, "http")},
+		},
+	})
+	createVCDCluster(t, ctx, newVCDCluster(name, server.URL(), nil), known)
+
+	s := &sweeper.OrphanSweeper{
+		Client: k8sClient,
+		Log:    logr.Discard(),
+		Cleaners: []cleaner.Cleaner{
+			cleaner.NewVirtualServiceCleaner(k8sClient),
+			cleaner.NewLBPoolCleaner(k8sClient),
+		},
+		Delete: true,
+		Ledger: newLedger(t, ctx, orphaned),
+	}
+
+	orphans, err := s.Sweep(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(orphanNames(orphans, orphaned)).To(gomega.ConsistOf(ingressName("ingress-pool-", "nginx", orphaned, "http")))
+	for _, o := range orphans {
+		g.Expect(o.Name).NotTo(gomega.Equal(ingressName("ingress-pool-", "nginx", known, "http")))
+		if o.InfraId == orphaned {
+			g.Expect(o.Owned).To(gomega.BeTrue())
+		}
+	}
+
+	_, err = s.Sweep(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server.DeletedPools()).To(gomega.ConsistOf(ingressName("ingress-pool-", "nginx", orphaned, "http")))
+}
+
+// TestOrphanSweeperSweepsLedgerLocations checks that the location of a cluster
+// that is gone is still swept, with the credentials the ledger recorded.
+func TestOrphanSweeperSweepsLedgerLocations(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	orphaned := rdeInfraId()
+
+	server := newVCDServer(t, vcdfake.Config{
+		VAppName: name,
+		NatRules: []vcdfake.Resource{
+			{ID: "nat-1", Name: "dnat-" + controlPlaneName("gone", orphaned)},
+		},
+	})
+	createSecret(t, ctx, name, map[string][]byte{
+		"username": []byte(testUsername),
+		"password": []byte(testPassword),
+	})
+
+	// No VCDCluster points at the site any more.
+	gone := newVCDCluster(name, server.URL(), nil)
+	gone.Spec.UserCredentialsContext = credentialsFromSecret(name)
+	ledger := newLedger(t, ctx)
+	g.Expect(ledger.Add(ctx, orphaned, sweeper.EntryOf(vcdcluster.FromV1beta1(gone)))).To(gomega.Succeed())
+
+	s := &sweeper.OrphanSweeper{
+		Client:   k8sClient,
+		Log:      logr.Discard(),
+		Cleaners: []cleaner.Cleaner{cleaner.NewDNATCleaner(k8sClient)},
+		Delete:   true,
+		Ledger:   ledger,
+	}
+
+	orphans, err := s.Sweep(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(orphanNames(orphans, orphaned)).To(gomega.ConsistOf("dnat-" + controlPlaneName("gone", orphaned)))
+
+	_, err = s.Sweep(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(server.DeletedNatRules()).To(gomega.ConsistOf("dnat-" + controlPlaneName("gone", orphaned)))
+
+	// With its objects gone the infra id, and so its location, leaves the
+	// ledger.
+	entries, err := ledger.Entries(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(entries).To(gomega.BeEmpty())
+}
+
+// orphanNames lists the names of the orphans of one infra id. Other tests
+// leave VCDClusters behind, so a sweep may see more.
+func orphanNames(orphans []sweeper.Orphan, infraId string) []string {
+	var names []string
+	for _, o := range orphans {
+		if o.InfraId == infraId {
+			names = append(names, o.Name)
+		}
+	}
+
+	return names
+}
+
+func TestReconcileKeepsInfraIdInLedgerUntilCleanedUp(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := createVCDCluster(t, ctx, newVCDCluster(name, "https://vcd.invalid", cluster), infraIdFor(t))
+
+	ledger := newLedger(t, ctx)
+	r := newReconciler([]*stubCleaner{{name: "volumes"}})
+	r.Ledger = ledger
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	owned, err := ledger.Owned(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(owned).To(gomega.HaveKey(infraIdFor(t)))
+	entries, err := ledger.Entries(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(entries).To(gomega.HaveKeyWithValue(infraIdFor(t), sweeper.Entry{
+		Namespace: testNamespace,
+		Site:      "https://vcd.invalid",
+		Org:       testOrgName,
+		Zones:     []vcdcluster.Zone{{Ovdc: testVdcName, OvdcNetwork: testNetworkName}},
+	}))
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+
+	owned, err = ledger.Owned(ctx)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(owned).NotTo(gomega.HaveKey(infraIdFor(t)))
+}
@@ -0,0 +1,155 @@
+//go:build integration
+
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/onsi/gomega"
+	capvcd "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta1"
+	capvcdv1beta2 "github.com/vmware/cluster-api-provider-cloud-director/api/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/key"
+	"github.com/giantswarm/cluster-api-cleaner-cloud-director/pkg/vcdcluster"
+)
+
+// asV1beta2 returns the VCDCluster in api version v1beta2. The fields the
+// cleaner reads are the same in both versions.
+func asV1beta2(t *testing.T, vcdCluster *capvcd.VCDCluster) *capvcdv1beta2.VCDCluster {
+	t.Helper()
+	g := gomega.NewWithT(t)
+
+	encoded, err := json.Marshal(vcdCluster)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	converted := &capvcdv1beta2.VCDCluster{}
+	g.Expect(json.Unmarshal(encoded, converted)).To(gomega.Succeed())
+	converted.APIVersion = capvcdv1beta2.GroupVersion.String()
+
+	return converted
+}
+
+func TestStorageVersion(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+
+	var crd apiextensionsv1.CustomResourceDefinition
+	g.Expect(k8sClient.Get(ctx, client.ObjectKey{Name: vcdcluster.CRDName}, &crd)).To(gomega.Succeed())
+	expected := capvcdv1beta2.GroupVersion.Version
+	for _, version := range crd.Spec.Versions {
+		if version.Storage && (version.Name == capvcd.GroupVersion.Version || version.Name == capvcdv1beta2.GroupVersion.Version) {
+			expected = version.Name
+		}
+	}
+
+	version, err := vcdcluster.StorageVersion(ctx, k8sClient)
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(version.GroupVersion.Version).To(gomega.Equal(expected))
+}
+
+func TestReconcileV1beta2VCDCluster(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cluster := createCluster(t, ctx, newCluster(name))
+	vcdCluster := asV1beta2(t, newVCDCluster(name, "https://vcd.invalid", cluster))
+	g.Expect(k8sClient.Create(ctx, vcdCluster)).To(gomega.Succeed())
+	t.Cleanup(func() {
+		cleanupVCDCluster(vcdCluster)
+	})
+	vcdCluster.Status.InfraId = infraIdFor(t)
+	g.Expect(k8sClient.Status().Update(ctx, vcdCluster)).To(gomega.Succeed())
+
+	volumes := &stubCleaner{name: "volumes"}
+	r := newReconciler([]*stubCleaner{volumes})
+	r.Version = vcdcluster.V1beta2
+
+	_, err := r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(vcdCluster), vcdCluster)).To(gomega.Succeed())
+	g.Expect(controllerutil.ContainsFinalizer(vcdCluster, key.CleanerFinalizerName)).To(gomega.BeTrue())
+
+	g.Expect(k8sClient.Delete(ctx, vcdCluster)).To(gomega.Succeed())
+
+	_, err = r.Reconcile(ctx, reconcileRequest(name))
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+
+	// The cleaner gets the cluster as read in v1beta2.
+	g.Expect(volumes.callCount()).To(gomega.Equal(1))
+	g.Expect(volumes.calls[0].Status.InfraId).To(gomega.Equal(infraIdFor(t)))
+	g.Expect(volumes.calls[0].Org).To(gomega.Equal(testOrgName))
+	g.Expect(volumes.calls[0].ClientObject()).To(gomega.BeAssignableToTypeOf(&capvcdv1beta2.VCDCluster{}))
+	g.Expect(vcdClusterIsGone(ctx, name)).To(gomega.BeTrue())
+}
+
+func TestZonesFromV1beta2Spec(t *testing.T) {
+	g := gomega.NewWithT(t)
+	ctx := context.Background()
+	name := uniqueName(t)
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace},
+		Data: map[string]string{"zones.yaml": `zones:
+- name: a
+  ovdcName: vdc-a
+  ovdcNetworkName: network-a
+- name: b
+  ovdcName: vdc-b
+  ovdcNetworkName: network-b
+  loadBalancerVIPSubnet: 10.2.0.0/24
+`},
+	}
+	g.Expect(k8sClient.Create(ctx, cm)).To(gomega.Succeed())
+	t.Cleanup(func() {
+		_ = k8sClient.Delete(context.Background(), cm)
+	})
+
+	vcdCluster := asV1beta2(t, newVCDCluster(name, "https://vcd.invalid", nil))
+	vcdCluster.Spec.OvdcZoneConfigMapName = name
+	vcdCluster.Spec.LoadBalancerConfigSpec.VipSubnet = "10.1.0.0/24"
+
+	c := vcdcluster.FromV1beta2(vcdCluster)
+	g.Expect(vcdcluster.LoadZones(ctx, k8sClient, c)).To(gomega.Succeed())
+	zones, err := c.Zones()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(zones).To(gomega.Equal([]vcdcluster.Zone{
+		{Name: "a", Ovdc: "vdc-a", OvdcNetwork: "network-a", VipSubnet: "10.1.0.0/24"},
+		{Name: "b", Ovdc: "vdc-b", OvdcNetwork: "network-b", VipSubnet: "10.2.0.0/24"},
+	}))
+
+	// The annotation overrides them.
+	vcdCluster.SetAnnotations(map[string]string{key.ZonesAnnotation: `[{"name": "c", "ovdc": "vdc-c", "ovdcNetwork": "network-c"}]`})
+	zones, err = c.Zones()
+	g.Expect(err).NotTo(gomega.HaveOccurred())
+	g.Expect(zones).To(gomega.Equal([]vcdcluster.Zone{{Name: "c", Ovdc: "vdc-c", OvdcNetwork: "network-c", VipSubnet: "10.1.0.0/24"}}))
+
+	// A topology that can't be read is an error rather than a single zone.
+	cm.Data = map[string]string{"zones.yaml": "zones:\n- name: a\n"}
+	g.Expect(k8sClient.Update(ctx, cm)).To(gomega.Succeed())
+	g.Eventually(func() bool {
+		return vcdcluster.IsInvalidZones(vcdcluster.LoadZones(ctx, k8sClient, vcdcluster.FromV1beta2(vcdCluster)))
+	}).Should(gomega.BeTrue())
+}
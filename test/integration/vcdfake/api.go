@@ -19,6 +19,7 @@ limitations under the License.
 package vcdfake
 
 import (
+	"encoding/xml"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -63,6 +64,39 @@ func (s *Server) handleSession(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// handleToken exchanges an api or service account token for a bearer token.
+// Tokens only work in the tenant org, a login as the provider is refused like
+// VCD refuses it to a tenant.
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request, rest string) {
+	if r.Method != http.MethodPost || rest != "tenant/"+s.cfg.OrgName+"/token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "refresh_token" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	token := r.PostForm.Get("refresh_token")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case token != "" && token == s.cfg.APIToken:
+		s.tokenExchanges++
+		writeJSON(w, fmt.Sprintf(`{"access_token":%q,"token_type":"Bearer","expires_in":3600}`, accessToken))
+	case token != "" && token == s.serviceAccountToken:
+		s.tokenExchanges++
+		s.serviceAccountToken = fmt.Sprintf("%s-%d", s.cfg.ServiceAccountToken, s.tokenExchanges)
+		writeJSON(w, fmt.Sprintf(`{"access_token":%q,"token_type":"Bearer","expires_in":3600,"refresh_token":%q}`,
+			accessToken, s.serviceAccountToken))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprint(w, `{"error":"invalid_grant"}`)
+	}
+}
+
 func (s *Server) handleOrgList(w http.ResponseWriter) {
 	writeXML(w, fmt.Sprintf(`<OrgList xmlns="http://www.vmware.com/vcloud/v1.5" href="%[1]s/api/org/" type="application/vnd.vmware.vcloud.orgList+xml">
   <Org href="%[1]s/api/org/%[2]s" name="%[3]s" type="application/vnd.vmware.vcloud.org+xml"/>
@@ -83,7 +117,7 @@ func (s *Server) handleOrg(w http.ResponseWriter) {
 func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 	switch rawParam(r, "type") {
 	case "orgVdc", "adminOrgVdc":
-		s.handleVdcQuery(w)
+		s.handleVdcQuery(w, r)
 	case "disk":
 		s.handleDiskQuery(w, r)
 	default:
@@ -91,16 +125,32 @@ func (s *Server) handleQuery(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *Server) handleVdcQuery(w http.ResponseWriter) {
-	writeXML(w, fmt.Sprintf(`<QueryResultRecords xmlns="http://www.vmware.com/vcloud/v1.5" type="application/vnd.vmware.vcloud.query.records+xml" name="orgVdc" page="1" pageSize="25" total="1">
-  <OrgVdcRecord href="%[1]s/api/vdc/%[2]s" name="%[3]s" orgName="%[4]s" isEnabled="true" status="READY"/>
-</QueryResultRecords>`, s.url, vdcID, s.cfg.VdcName, s.cfg.OrgName))
+// handleVdcQuery lists the vdcs of the org. govcd looks a vdc up by name and
+// refuses anything but exactly one match, so the name filter is honoured.
+func (s *Server) handleVdcQuery(w http.ResponseWriter, r *http.Request) {
+	wanted := filterName(r)
+
+	records := ""
+	total := 0
+	for i := 0; i < s.zoneCount(); i++ {
+		if wanted != "" && s.zoneVdcName(i) != wanted {
+			continue
+		}
+		total++
+		records += fmt.Sprintf(`  <OrgVdcRecord href="%s/api/vdc/%s" name="%s" orgName="%s" isEnabled="true" status="READY"/>`+"\n",
+			s.url, zoneID(vdcID, i), s.zoneVdcName(i), s.cfg.OrgName)
+	}
+
+	writeXML(w, fmt.Sprintf(`<QueryResultRecords xmlns="http://www.vmware.com/vcloud/v1.5" type="application/vnd.vmware.vcloud.query.records+xml" name="orgVdc" page="1" pageSize="25" total="%d">
+%s</QueryResultRecords>`, total, records))
 }
 
 // handleDiskQuery returns one page of disk records. The cleaner walks the pages
 // through the nextPage link, so every page but the last carries one.
 func (s *Server) handleDiskQuery(w http.ResponseWriter, r *http.Request) {
-	description := strings.TrimPrefix(rawParam(r, "filter"), "description==")
+	// Without a filter every disk is listed.
+	filter := rawParam(r, "filter")
+	description := strings.TrimPrefix(filter, "description==")
 
 	page, err := strconv.Atoi(rawParam(r, "page"))
 	if err != nil || page < 1 {
@@ -109,13 +159,20 @@ func (s *Server) handleDiskQuery(w http.ResponseWriter, r *http.Request) {
 
 	records := ""
 	if page <= len(s.cfg.DiskPages) {
-		for _, disk := range s.cfg.DiskPages[page-1] {
-			if disk.Description != description || s.isDeleted(kindDisk, disk.Name) {
+		for _, configured := range s.cfg.DiskPages[page-1] {
+			// A disk may have been renamed since.
+			disk := s.disk(configured.ID)
+			if (filter != "" && disk.Description != description) || s.isDeleted(kindDisk, disk.Name) {
 				continue
 			}
 
-			records += fmt.Sprintf(`  <DiskRecord href="%s/api/disk/%s" id="urn:vcloud:disk:%s" type="application/vnd.vmware.vcloud.disk+xml" name="%s" description="%s" sizeMb="8192" status="RESOLVED"/>`+"\n",
-				s.url, disk.ID, disk.ID, disk.Name, disk.Description)
+			vdcName := disk.VdcName
+			if vdcName == "" {
+				vdcName = s.cfg.VdcName
+			}
+
+			records += fmt.Sprintf(`  <DiskRecord href="%s/api/disk/%s" id="urn:vcloud:disk:%s" type="application/vnd.vmware.vcloud.disk+xml" name="%s" description="%s" vdcName="%s" sizeMb="8192" status="RESOLVED"/>`+"\n",
+				s.url, disk.ID, disk.ID, disk.Name, disk.Description, vdcName)
 		}
 	}
 
@@ -129,15 +186,27 @@ func (s *Server) handleDiskQuery(w http.ResponseWriter, r *http.Request) {
 %s%s</QueryResultRecords>`, page, nextPage, records))
 }
 
-// handleVdc answers with the vdc and the vApp that holds the vms.
-func (s *Server) handleVdc(w http.ResponseWriter) {
+// handleVdc answers with a vdc and the vApp that holds the vms. Every vdc
+// shows the same vApp.
+func (s *Server) handleVdc(w http.ResponseWriter, r *http.Request, id string) {
+	zone := -1
+	for i := 0; i < s.zoneCount(); i++ {
+		if zoneID(vdcID, i) == id {
+			zone = i
+		}
+	}
+	if zone < 0 {
+		s.notImplemented(w, r)
+		return
+	}
+
 	writeXML(w, fmt.Sprintf(`<Vdc xmlns="http://www.vmware.com/vcloud/v1.5" href="%[1]s/api/vdc/%[2]s" id="urn:vcloud:vdc:%[2]s" type="application/vnd.vmware.vcloud.vdc+xml" name="%[3]s" status="1">
   <AllocationModel>Flex</AllocationModel>
   <ResourceEntities>
     <ResourceEntity href="%[1]s/api/vApp/vapp-%[4]s" name="%[5]s" type="application/vnd.vmware.vcloud.vApp+xml"/>
   </ResourceEntities>
   <IsEnabled>true</IsEnabled>
-</Vdc>`, s.url, vdcID, s.cfg.VdcName, vappID, s.cfg.VAppName))
+</Vdc>`, s.url, id, s.zoneVdcName(zone), vappID, s.cfg.VAppName))
 }
 
 // handleVApp lists every vm that a configured disk is attached to.
@@ -191,7 +260,7 @@ func (s *Server) detachDisk(w http.ResponseWriter, vmName string) {
 	s.writeTask(w)
 }
 
-// handleDisk serves a disk, its attached vms, or a delete.
+// handleDisk serves a disk, its attached vms, an update or a delete.
 func (s *Server) handleDisk(w http.ResponseWriter, r *http.Request, rest string) {
 	id, action, _ := strings.Cut(rest, "/")
 
@@ -216,6 +285,8 @@ func (s *Server) handleDisk(w http.ResponseWriter, r *http.Request, rest string)
 		}
 		s.recordDelete(kindDisk, disk.Name)
 		s.writeTask(w)
+	case r.Method == http.MethodPut:
+		s.updateDisk(w, r, disk)
 	default:
 		s.writeDisk(w, disk)
 	}
@@ -225,8 +296,9 @@ func (s *Server) writeDisk(w http.ResponseWriter, disk *Disk) {
 	links := fmt.Sprintf(`  <Link rel="down" type="application/vnd.vmware.vcloud.vms+xml" href="%s/api/disk/%s/attachedVms"/>`+"\n",
 		s.url, disk.ID)
 
-	// The remove link only exists while nothing holds the disk.
+	// The edit and remove links only exist while nothing holds the disk.
 	if disk.AttachedVM == "" {
+		links += fmt.Sprintf(`  <Link rel="edit" type="application/vnd.vmware.vcloud.disk+xml" href="%s/api/disk/%s"/>`+"\n", s.url, disk.ID)
 		links += fmt.Sprintf(`  <Link rel="remove" href="%s/api/disk/%s"/>`+"\n", s.url, disk.ID)
 	}
 
@@ -235,6 +307,29 @@ func (s *Server) writeDisk(w http.ResponseWriter, disk *Disk) {
 </Disk>`, s.url, disk.ID, disk.Name, links, disk.Description))
 }
 
+// updateDisk renames a disk and replaces its description.
+func (s *Server) updateDisk(w http.ResponseWriter, r *http.Request, disk *Disk) {
+	var update struct {
+		Name        string `xml:"name,attr"`
+		Description string `xml:"Description"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&update); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if disk.AttachedVM != "" {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	s.mu.Lock()
+	disk.Name = update.Name
+	disk.Description = update.Description
+	s.mu.Unlock()
+
+	s.writeTask(w)
+}
+
 func (s *Server) writeAttachedVMs(w http.ResponseWriter, disk *Disk) {
 	references := ""
 	if disk.AttachedVM != "" {
@@ -246,7 +341,25 @@ func (s *Server) writeAttachedVMs(w http.ResponseWriter, disk *Disk) {
 %s</Vms>`, s.url, disk.ID, references))
 }
 
-func (s *Server) handleTask(w http.ResponseWriter) {
+// handleTask answers a poll of the task every change starts. Other tasks are
+// not found, like those VCD purged.
+func (s *Server) handleTask(w http.ResponseWriter, id string) {
+	if id != taskID {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	s.taskPolls++
+	running := s.taskPolls <= s.cfg.RunningTaskPolls
+	s.mu.Unlock()
+
+	if running {
+		writeXML(w, fmt.Sprintf(`<Task xmlns="http://www.vmware.com/vcloud/v1.5" href="%[1]s/api/task/%[2]s" id="urn:vcloud:task:%[2]s" type="application/vnd.vmware.vcloud.task+xml" name="task" operation="fake" status="running"/>`,
+			s.url, taskID))
+		return
+	}
+
 	s.writeTask(w)
 }
 
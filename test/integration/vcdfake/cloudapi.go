@@ -28,13 +28,20 @@ import (
 // routeCloudAPI dispatches the json api. path has the /cloudapi/1.0.0 prefix
 // removed.
 func (s *Server) routeCloudAPI(w http.ResponseWriter, r *http.Request, path string) {
+	for i := 0; i < s.zoneCount(); i++ {
+		switch {
+		case path == "/orgVdcNetworks/"+zoneNetworkURN(i):
+			s.handleNetwork(w, i)
+			return
+		case strings.HasPrefix(path, "/edgeGateways/"+ZoneGatewayURN(i)):
+			s.routeEdgeGateway(w, r, i, strings.TrimPrefix(path, "/edgeGateways/"+ZoneGatewayURN(i)))
+			return
+		}
+	}
+
 	switch {
 	case path == "/orgVdcNetworks":
 		s.handleNetworkList(w, r)
-	case path == "/orgVdcNetworks/"+networkURN:
-		s.handleNetwork(w)
-	case strings.HasPrefix(path, "/edgeGateways/"+GatewayURN):
-		s.routeEdgeGateway(w, r, strings.TrimPrefix(path, "/edgeGateways/"+GatewayURN))
 	case strings.HasPrefix(path, "/loadBalancer/virtualServices/"):
 		s.handleResource(w, r, kindVirtualService, strings.TrimPrefix(path, "/loadBalancer/virtualServices/"))
 	case strings.HasPrefix(path, "/loadBalancer/pools/"):
@@ -49,18 +56,19 @@ func (s *Server) routeCloudAPI(w http.ResponseWriter, r *http.Request, path stri
 	}
 }
 
-func (s *Server) routeEdgeGateway(w http.ResponseWriter, r *http.Request, path string) {
+// routeEdgeGateway dispatches the api of the edge gateway of zone i.
+func (s *Server) routeEdgeGateway(w http.ResponseWriter, r *http.Request, zone int, path string) {
 	switch {
 	case path == "":
-		s.handleEdgeGateway(w)
+		s.handleEdgeGateway(w, zone)
 	case path == "/nat/rules":
-		s.handleNatRuleList(w, r)
+		s.handleNatRuleList(w, r, zone)
 	case strings.HasPrefix(path, "/nat/rules/"):
 		s.handleNatRuleDelete(w, r, strings.TrimPrefix(path, "/nat/rules/"))
 	case path == "/loadBalancer/virtualServiceSummaries":
-		s.handleSummaries(w, r, kindVirtualService)
+		s.handleSummaries(w, r, zone, kindVirtualService)
 	case path == "/loadBalancer/poolSummaries":
-		s.handleSummaries(w, r, kindPool)
+		s.handleSummaries(w, r, zone, kindPool)
 	default:
 		s.notImplemented(w, r)
 	}
@@ -74,18 +82,25 @@ func (s *Server) handleNetworkList(w http.ResponseWriter, r *http.Request) {
 		page = 1
 	}
 
+	total := s.zoneCount()
 	if page > 1 {
-		writeJSON(w, `{"resultTotal":1,"pageCount":1,"page":2,"pageSize":32,"values":[]}`)
+		writeJSON(w, fmt.Sprintf(`{"resultTotal":%d,"pageCount":1,"page":2,"pageSize":32,"values":[]}`, total))
 		return
 	}
 
-	writeJSON(w, fmt.Sprintf(`{"resultTotal":1,"pageCount":1,"page":1,"pageSize":32,"values":[{"id":%q,"name":%q}]}`,
-		networkURN, s.cfg.NetworkName))
+	values := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		values = append(values, fmt.Sprintf(`{"id":%q,"name":%q}`, zoneNetworkURN(i), s.zoneNetworkName(i)))
+	}
+
+	writeJSON(w, fmt.Sprintf(`{"resultTotal":%d,"pageCount":1,"page":1,"pageSize":32,"values":[%s]}`,
+		total, strings.Join(values, ",")))
 }
 
-// handleNetwork answers with the network and the edge gateway behind it. The
-// cleaners fail with a nil gateway reference if connection.routerRef is absent.
-func (s *Server) handleNetwork(w http.ResponseWriter) {
+// handleNetwork answers with the network of zone i and the edge gateway behind
+// it. The cleaners fail with a nil gateway reference if connection.routerRef
+// is absent.
+func (s *Server) handleNetwork(w http.ResponseWriter, zone int) {
 	writeJSON(w, fmt.Sprintf(`{
   "id": %q,
   "name": %q,
@@ -95,13 +110,13 @@ func (s *Server) handleNetwork(w http.ResponseWriter) {
     "connectionType": "INTERNAL",
     "connected": true
   }
-}`, networkURN, s.cfg.NetworkName, GatewayURN))
+}`, zoneNetworkURN(zone), s.zoneNetworkName(zone), ZoneGatewayURN(zone)))
 }
 
 // handleEdgeGateway reports the gateway as realized. vcdsdk dereferences the
 // status without a nil check, so it must always be present.
-func (s *Server) handleEdgeGateway(w http.ResponseWriter) {
-	writeJSON(w, fmt.Sprintf(`{"id":%q,"name":"fake-edge","status":"REALIZED"}`, GatewayURN))
+func (s *Server) handleEdgeGateway(w http.ResponseWriter, zone int) {
+	writeJSON(w, fmt.Sprintf(`{"id":%q,"name":"fake-edge","status":"REALIZED"}`, ZoneGatewayURN(zone)))
 }
 
 // handleNatRuleList returns one cursor page of nat rules. Paging runs over the
@@ -110,13 +125,13 @@ func (s *Server) handleEdgeGateway(w http.ResponseWriter) {
 //
 // The Link header must have no space after the closing angle bracket, otherwise
 // the cursor parser silently returns nothing and the listing stops early.
-func (s *Server) handleNatRuleList(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleNatRuleList(w http.ResponseWriter, r *http.Request, zone int) {
 	page, err := strconv.Atoi(r.URL.Query().Get("cursor"))
 	if err != nil || page < 1 {
 		page = 1
 	}
 
-	live := s.liveNatRules()
+	live := s.liveNatRules(zone)
 
 	size := s.cfg.NatRulePageSize
 	if size < 1 {
@@ -142,16 +157,16 @@ func (s *Server) handleNatRuleList(w http.ResponseWriter, r *http.Request) {
 
 	if end < len(live) {
 		w.Header().Set("Link", fmt.Sprintf(`<%s/cloudapi/1.0.0/edgeGateways/%s/nat/rules?cursor=%d&pageSize=128>;rel="nextPage";type="application/json"`,
-			s.url, GatewayURN, page+1))
+			s.url, ZoneGatewayURN(zone), page+1))
 	}
 
 	writeJSON(w, fmt.Sprintf(`{"status":"REALIZED","values":[%s]}`, strings.Join(values, ",")))
 }
 
-// liveNatRules lists the rules that are not deleted yet.
-func (s *Server) liveNatRules() []Resource {
+// liveNatRules lists the rules of zone i that are not deleted yet.
+func (s *Server) liveNatRules(zone int) []Resource {
 	live := []Resource{}
-	for _, rule := range s.cfg.NatRules {
+	for _, rule := range s.zoneNatRules(zone) {
 		if !s.isDeleted(kindNatRule, rule.Name) {
 			live = append(live, rule)
 		}
@@ -177,17 +192,25 @@ func (s *Server) handleNatRuleDelete(w http.ResponseWriter, r *http.Request, id
 		return
 	}
 
+	if !s.startDelete(w, name) {
+		return
+	}
 	s.recordDelete(kindNatRule, name)
 	s.acceptTask(w)
 }
 
 // handleSummaries lists virtual services or load balancer pools. vcdsdk looks
 // an object up by name and treats anything other than exactly one match as not
-// found, so the name filter has to be honoured.
-func (s *Server) handleSummaries(w http.ResponseWriter, r *http.Request, kind string) {
+// found, so the name filter has to be honoured. Only the gateway of zone 0
+// holds any.
+func (s *Server) handleSummaries(w http.ResponseWriter, r *http.Request, zone int, kind string) {
 	wanted := filterName(r)
 
 	values := []string{}
+	if zone > 0 {
+		s.writePages(w, values)
+		return
+	}
 	for _, resource := range s.liveResources(kind) {
 		if wanted != "" && resource.Name != wanted {
 			continue
@@ -228,6 +251,9 @@ func (s *Server) handleResource(w http.ResponseWriter, r *http.Request, kind, id
 	}
 
 	if r.Method == http.MethodDelete {
+		if !s.startDelete(w, name) {
+			return
+		}
 		s.recordDelete(kind, name)
 
 		// govcd finishes on 204, the swagger client insists on 202 plus a task.
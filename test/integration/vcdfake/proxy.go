@@ -0,0 +1,104 @@
+//go:build integration
+
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package vcdfake
+
+import (
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+// Proxy is a fake egress proxy. It forwards plain http requests to 127.0.0.1
+// on the port of their url, so it reaches a Server started with a Host that
+// does not resolve.
+type Proxy struct {
+	server *httptest.Server
+
+	username string
+	password string
+
+	mu       sync.Mutex
+	requests []string
+}
+
+// NewProxy starts a fake proxy. With a username it answers 407 to requests
+// without its credentials. The caller must close it.
+func NewProxy(username, password string) *Proxy {
+	p := &Proxy{username: username, password: password}
+	p.server = httptest.NewServer(http.HandlerFunc(p.forward))
+
+	return p
+}
+
+// URL is the address of the proxy.
+func (p *Proxy) URL() string {
+	return p.server.URL
+}
+
+// Close stops the proxy.
+func (p *Proxy) Close() {
+	p.server.Close()
+}
+
+// Requests are the "METHOD url" of the requests the proxy forwarded.
+func (p *Proxy) Requests() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.requests...)
+}
+
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.IsAbs() {
+		http.Error(w, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+	if p.username != "" {
+		want := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.username+":"+p.password))
+		if r.Header.Get("Proxy-Authorization") != want {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+	}
+
+	p.mu.Lock()
+	p.requests = append(p.requests, r.Method+" "+r.URL.String())
+	p.mu.Unlock()
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	out.Header.Del("Proxy-Authorization")
+	out.URL.Host = net.JoinHostPort("127.0.0.1", r.URL.Port())
+
+	resp, err := (&http.Transport{}).RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
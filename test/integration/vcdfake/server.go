@@ -26,12 +26,16 @@ limitations under the License.
 package vcdfake
 
 import (
+	"encoding/pem"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"slices"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 // Fixed identifiers. Real VCD uses random uuids, the values only need to be
@@ -44,8 +48,7 @@ const (
 	gatewayID = "bbbbbbbb-bbbb-bbbb-bbbb-bbbbbbbbbbbb"
 	taskID    = "cccccccc-cccc-cccc-cccc-cccccccccccc"
 
-	orgURN     = "urn:vcloud:org:" + orgID
-	networkURN = "urn:vcloud:network:" + networkID
+	orgURN = "urn:vcloud:org:" + orgID
 
 	// GatewayURN is the edge gateway the fake network is attached to.
 	GatewayURN = "urn:vcloud:gateway:" + gatewayID
@@ -67,6 +70,9 @@ type Disk struct {
 	// remove link only once nothing is attached, so an attached disk must be
 	// detached before it can be deleted.
 	AttachedVM string
+
+	// VdcName is the vdc the disk is in. Empty is the vdc of the Config.
+	VdcName string
 }
 
 // Resource is any VCD object the cleaners match by name.
@@ -75,6 +81,16 @@ type Resource struct {
 	Name string
 }
 
+// Zone is one more vdc of the org, with a network of its own behind an edge
+// gateway of its own.
+type Zone struct {
+	VdcName     string
+	NetworkName string
+
+	// NatRules are the nat rules of the edge gateway of the zone.
+	NatRules []Resource
+}
+
 // Config describes the state the server starts with.
 type Config struct {
 	OrgName     string
@@ -83,6 +99,20 @@ type Config struct {
 	Username    string
 	Password    string
 
+	// APIToken is a VCD api token the server accepts. Exchanging it for a
+	// bearer token leaves it valid.
+	APIToken string
+	// ServiceAccountToken is the refresh token of a service account. Every
+	// exchange replaces it with a new one and the old one stops working, the
+	// way VCD does it.
+	ServiceAccountToken string
+
+	// TLS serves https with a self-signed certificate, see Certificate.
+	TLS bool
+	// Host replaces 127.0.0.1 in the url of the server, for a server that is
+	// only reached through a Proxy.
+	Host string
+
 	// VAppName holds the vms. The volume cleaner looks vms up in the vApp named
 	// after the VCDCluster.
 	VAppName string
@@ -99,6 +129,19 @@ type Config struct {
 	VirtualServices []Resource
 	Pools           []Resource
 	AppPortProfiles []Resource
+
+	// Zones are the vdcs of the org besides VdcName. The virtual services
+	// and pools are on the gateway of VdcName only.
+	Zones []Zone
+
+	// FailDeletes names the gateway objects whose deletion fails.
+	FailDeletes []string
+	// DeleteLatency is how long the deletion of a gateway object takes.
+	DeleteLatency time.Duration
+
+	// RunningTaskPolls is how many polls of a task answer that it is still
+	// running. The changes a task makes are applied when it is started.
+	RunningTaskPolls int
 }
 
 // Server is a fake VCD endpoint.
@@ -118,6 +161,14 @@ type Server struct {
 
 	loginUser     string
 	loginPassword string
+
+	serviceAccountToken string
+	tokenExchanges      int
+
+	taskPolls int
+
+	deleting    int
+	maxDeleting int
 }
 
 // New starts a fake VCD server. The caller must close it.
@@ -128,6 +179,8 @@ func New(cfg Config) *Server {
 		natRules:  map[string]string{},
 		resources: map[string]map[string]string{},
 		deletes:   map[string][]string{},
+
+		serviceAccountToken: cfg.ServiceAccountToken,
 	}
 
 	for _, page := range cfg.DiskPages {
@@ -136,22 +189,38 @@ func New(cfg Config) *Server {
 			s.disks[d.ID] = &d
 		}
 	}
-	for _, rule := range cfg.NatRules {
-		s.natRules[rule.ID] = rule.Name
+	for i := 0; i < s.zoneCount(); i++ {
+		for _, rule := range s.zoneNatRules(i) {
+			s.natRules[rule.ID] = rule.Name
+		}
 	}
 	s.resources[kindVirtualService] = resourceMap(cfg.VirtualServices)
 	s.resources[kindPool] = resourceMap(cfg.Pools)
 	s.resources[kindAppPortProfile] = resourceMap(cfg.AppPortProfiles)
 
-	s.server = httptest.NewServer(http.HandlerFunc(s.route))
+	if cfg.TLS {
+		s.server = httptest.NewTLSServer(http.HandlerFunc(s.route))
+	} else {
+		s.server = httptest.NewServer(http.HandlerFunc(s.route))
+	}
 	s.url = s.server.URL
+	if cfg.Host != "" {
+		u, _ := url.Parse(s.server.URL)
+		u.Host = cfg.Host + ":" + u.Port()
+		s.url = u.String()
+	}
 
 	return s
 }
 
+// Certificate is the PEM certificate of a server started with TLS.
+func (s *Server) Certificate() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+}
+
 // URL is the site address to put into VCDCluster.Spec.Site.
 func (s *Server) URL() string {
-	return s.server.URL
+	return s.url
 }
 
 // Close shuts the server down.
@@ -196,14 +265,16 @@ func (s *Server) route(w http.ResponseWriter, r *http.Request) {
 		s.handleVersions(w)
 	case strings.HasPrefix(path, "/cloudapi/1.0.0/sessions"):
 		s.handleSession(w, r)
+	case strings.HasPrefix(path, "/oauth/"):
+		s.handleToken(w, r, strings.TrimPrefix(path, "/oauth/"))
 	case path == "/api/org" || path == "/api/org/":
 		s.handleOrgList(w)
 	case path == "/api/org/"+orgID:
 		s.handleOrg(w)
 	case path == "/api/query":
 		s.handleQuery(w, r)
-	case path == "/api/vdc/"+vdcID:
-		s.handleVdc(w)
+	case strings.HasPrefix(path, "/api/vdc/"):
+		s.handleVdc(w, r, strings.TrimPrefix(path, "/api/vdc/"))
 	case path == "/api/vApp/vapp-"+vappID:
 		s.handleVApp(w)
 	case strings.HasPrefix(path, "/api/vApp/vm-"):
@@ -211,7 +282,7 @@ func (s *Server) route(w http.ResponseWriter, r *http.Request) {
 	case strings.HasPrefix(path, "/api/disk/"):
 		s.handleDisk(w, r, strings.TrimPrefix(path, "/api/disk/"))
 	case strings.HasPrefix(path, "/api/task/"):
-		s.handleTask(w)
+		s.handleTask(w, strings.TrimPrefix(path, "/api/task/"))
 	case strings.HasPrefix(path, "/cloudapi/"):
 		s.routeCloudAPI(w, r, strings.TrimPrefix(path, "/cloudapi/1.0.0"))
 	default:
@@ -228,6 +299,74 @@ func (s *Server) notImplemented(w http.ResponseWriter, r *http.Request) {
 	_, _ = fmt.Fprintf(w, "vcdfake has no handler for %s %s", r.Method, r.URL.Path) //nolint:gosec // test double, the body is not a web page
 }
 
+// zoneCount is how many vdcs the org has. Zone 0 is the vdc of the Config,
+// zone i the i-th entry of Config.Zones.
+func (s *Server) zoneCount() int {
+	return 1 + len(s.cfg.Zones)
+}
+
+func (s *Server) zoneVdcName(i int) string {
+	if i == 0 {
+		return s.cfg.VdcName
+	}
+	return s.cfg.Zones[i-1].VdcName
+}
+
+func (s *Server) zoneNetworkName(i int) string {
+	if i == 0 {
+		return s.cfg.NetworkName
+	}
+	return s.cfg.Zones[i-1].NetworkName
+}
+
+func (s *Server) zoneNatRules(i int) []Resource {
+	if i == 0 {
+		return s.cfg.NatRules
+	}
+	return s.cfg.Zones[i-1].NatRules
+}
+
+// zoneID derives the id of an object of zone i from the one of zone 0.
+func zoneID(id string, i int) string {
+	if i == 0 {
+		return id
+	}
+	return fmt.Sprintf("%s%012d", id[:len(id)-12], i)
+}
+
+func zoneNetworkURN(i int) string {
+	return "urn:vcloud:network:" + zoneID(networkID, i)
+}
+
+// ZoneGatewayURN is the edge gateway the network of zone i is attached to.
+// Zone 0 is the vdc of the Config, zone i the i-th entry of Config.Zones.
+func ZoneGatewayURN(i int) string {
+	return "urn:vcloud:gateway:" + zoneID(gatewayID, i)
+}
+
+// disk returns the current state of a configured disk.
+func (s *Server) disk(id string) Disk {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return *s.disks[id]
+}
+
+// Disks lists the configured disks as they are now, renamed or not, sorted
+// by id.
+func (s *Server) Disks() []Disk {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	disks := make([]Disk, 0, len(s.disks))
+	for _, d := range s.disks {
+		disks = append(disks, *d)
+	}
+	sort.Slice(disks, func(i, j int) bool { return disks[i].ID < disks[j].ID })
+
+	return disks
+}
+
 // recordDelete notes a deletion so a test can assert on it.
 func (s *Server) recordDelete(kind, name string) {
 	s.mu.Lock()
@@ -236,6 +375,38 @@ func (s *Server) recordDelete(kind, name string) {
 	s.deletes[kind] = append(s.deletes[kind], name)
 }
 
+// startDelete holds the deletion of a gateway object for DeleteLatency and
+// counts the deletions running at the same time. It answers 500 and returns
+// false for an object in FailDeletes.
+func (s *Server) startDelete(w http.ResponseWriter, name string) bool {
+	if slices.Contains(s.cfg.FailDeletes, name) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+
+	s.mu.Lock()
+	s.deleting++
+	s.maxDeleting = max(s.maxDeleting, s.deleting)
+	s.mu.Unlock()
+
+	time.Sleep(s.cfg.DeleteLatency)
+
+	s.mu.Lock()
+	s.deleting--
+	s.mu.Unlock()
+
+	return true
+}
+
+// MaxConcurrentDeletes is the most gateway object deletions the server handled
+// at the same time.
+func (s *Server) MaxConcurrentDeletes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.maxDeleting
+}
+
 // Deleted lists the names deleted for a kind, in order.
 func (s *Server) Deleted(kind string) []string {
 	s.mu.Lock()
@@ -287,6 +458,24 @@ func (s *Server) Credentials() (string, string) {
 	return s.loginUser, s.loginPassword
 }
 
+// ServiceAccountToken is the refresh token of the service account that works
+// now.
+func (s *Server) ServiceAccountToken() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.serviceAccountToken
+}
+
+// TokenExchanges is how many api or service account tokens were exchanged
+// for a bearer token.
+func (s *Server) TokenExchanges() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.tokenExchanges
+}
+
 // rawParam reads a query parameter straight from the raw query string.
 // url.Values drops any parameter containing a semicolon, and VCD filters use
 // semicolons to join conditions.